@@ -2,23 +2,139 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"time"
 
 	"gb-backend2/internal/api/handler"
 	"gb-backend2/internal/api/server"
+	"gb-backend2/internal/app/events"
+	"gb-backend2/internal/app/repos/user"
 	"gb-backend2/internal/app/starter"
 	"gb-backend2/internal/app/store"
+	"gb-backend2/internal/db/file/auditfile"
 )
 
 func main() {
+	retention := flag.Int("retention", 30, "days to keep deleted users and groups, 0 to keep forever")
+	eventsFile := flag.String("events-file", "", "append domain events to this NDJSON file")
+	eventsStdout := flag.Bool("events-stdout", false, "write domain events to stdout as NDJSON")
+	auditFile := flag.String("audit-file", "", "keep the audit log in this NDJSON file instead of memory")
+	adminPassword := flag.String("admin-password", "", "create user admin with all permissions and this password")
+	minPassword := flag.Int("password-min-length", user.DefaultPasswordPolicy.MinLength, "minimal length of new passwords")
+	tokenKey := flag.String("token-key", "", "key to sign access tokens, random if empty (tokens do not survive restart)")
+	accessTTL := flag.Duration("access-ttl", user.DefaultAccessTTL, "access token lifetime")
+	refreshTTL := flag.Duration("refresh-ttl", user.DefaultRefreshTTL, "refresh token lifetime")
+	inviteTTL := flag.Duration("invite-ttl", user.DefaultInviteTTL, "group invitation lifetime")
+	reviewKey := flag.String("review-key", "", "Ed25519 private key (PKCS#8 PEM) to sign access review reports, random if empty")
+	breached := flag.String("breached-passwords", "", "reject new passwords listed in this file (plain text or SHA-1 hex per line)")
+	authChain := flag.String("auth", "password,bearer,apikey", "ordered authenticators: password, htpasswd, bearer, apikey, cert")
+	htpasswd := flag.String("htpasswd", "", "htpasswd file (bcrypt) for the htpasswd authenticator")
+	tlsCert := flag.String("tls-cert", "", "serve HTTPS with this certificate")
+	tlsKey := flag.String("tls-key", "", "private key for -tls-cert")
+	clientCA := flag.String("client-ca", "", "verify client certificates against these CAs (PEM) for the cert authenticator")
+	maxUsers := flag.Int("max-users", 0, "default per-tenant limit on users, 0 for no limit")
+	maxGroups := flag.Int("max-groups", 0, "default per-tenant limit on groups, 0 for no limit")
+	tenantQuotas := flag.String("tenant-quotas", "", "per-tenant limits as tenant=users/groups,... (e.g. sales=1000/100)")
+	flag.Parse()
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 
-	store, _ := store.NewStore()
-	a := starter.NewApp(store)
-	h := handler.NewRouter(store)
+	var opts []store.Option
+	if *auditFile != "" {
+		af, err := auditfile.Open(*auditFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer af.Close()
+		opts = append(opts, store.WithAuditStore(af))
+	}
+	policy := user.DefaultPasswordPolicy
+	policy.MinLength = *minPassword
+	if *breached != "" {
+		f, err := os.Open(*breached)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = policy.LoadBreached(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	quotas, err := parseQuotas(*tenantQuotas)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rkey ed25519.PrivateKey
+	if *reviewKey != "" {
+		rkey, err = loadReviewKey(*reviewKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	quotas.Default = user.Quota{MaxUsers: *maxUsers, MaxGroups: *maxGroups}
+	opts = append(opts, store.WithQuotas(quotas))
+
+	opts = append(opts, store.WithPasswordPolicy(policy), store.WithTokenConfig(user.TokenConfig{
+		Key:        []byte(*tokenKey),
+		AccessTTL:  *accessTTL,
+		RefreshTTL: *refreshTTL,
+	}), store.WithInviteTTL(*inviteTTL), store.WithReviewKey(rkey))
+	store, _ := store.NewStore(opts...)
+
+	if *adminPassword != "" {
+		if _, err := store.Credentials.Bootstrap(ctx, store.User, "admin", *adminPassword); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// подписчики внутри процесса получают события всегда,
+	// подписываются через a.Subscribers
+	subs := events.NewSubscribers()
+	sinks := []events.Sink{subs}
+	if *eventsFile != "" {
+		fs, err := events.OpenFileSink(*eventsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer fs.Close()
+		sinks = append(sinks, fs)
+	}
+	if *eventsStdout {
+		sinks = append(sinks, events.NewWriterSink(os.Stdout))
+	}
+	disp := events.NewDispatcher(store.Outbox, time.Second, sinks...)
+
+	a := starter.NewApp(store, time.Duration(*retention)*24*time.Hour, subs, disp)
+	auth, err := authenticators(*authChain, store, *htpasswd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	h := handler.NewRouter(store, auth...)
 	srv := server.NewServer(":8000", h, store)
+	if *tlsCert != "" {
+		var pool *x509.CertPool
+		if *clientCA != "" {
+			pem, err := os.ReadFile(*clientCA)
+			if err != nil {
+				log.Fatal(err)
+			}
+			pool = x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				log.Fatalf("no certificates in %s", *clientCA)
+			}
+		}
+		srv.UseTLS(*tlsCert, *tlsKey, pool)
+	}
 
 	wg := &sync.WaitGroup{}
 	wg.Add(1)
@@ -29,3 +145,81 @@ func main() {
 	cancel()
 	wg.Wait()
 }
+
+// authenticators собирает цепочку способов входа в порядке names.
+// password и htpasswd проверяют один заголовок Basic: пользователя,
+// неизвестного первому, проверяет второй, см. handler.Chain.
+func authenticators(names string, st *store.Store, htpasswd string) ([]handler.Authenticator, error) {
+	var chain []handler.Authenticator
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "password":
+			chain = append(chain, handler.PasswordAuth{Credentials: st.Credentials})
+		case "htpasswd":
+			if htpasswd == "" {
+				return nil, fmt.Errorf("htpasswd authenticator needs -htpasswd")
+			}
+			a, err := handler.OpenHtpasswd(htpasswd, st.User)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, a)
+		case "bearer":
+			chain = append(chain, handler.BearerAuth{Tokens: st.Tokens})
+		case "apikey":
+			chain = append(chain, handler.APIKeyAuth{Keys: st.APIKeys})
+		case "cert":
+			chain = append(chain, handler.CertAuth{Users: st.User})
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown authenticator %q", name)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no authenticators configured")
+	}
+	return chain, nil
+}
+
+// loadReviewKey читает закрытый ключ Ed25519 в PKCS#8 PEM
+// (openssl genpkey -algorithm ed25519)
+func loadReviewKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ek, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return ek, nil
+}
+
+// parseQuotas разбирает квоты вида sales=1000/100,hr=50/5
+func parseQuotas(s string) (user.Quotas, error) {
+	q := user.Quotas{Tenants: make(map[string]user.Quota)}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var tq user.Quota
+		i := strings.LastIndexByte(item, '=')
+		if i < 0 {
+			return q, fmt.Errorf("bad tenant quota %q", item)
+		}
+		if _, err := fmt.Sscanf(item[i+1:], "%d/%d", &tq.MaxUsers, &tq.MaxGroups); err != nil {
+			return q, fmt.Errorf("bad tenant quota %q: %w", item, err)
+		}
+		q.Tenants[item[:i]] = tq
+	}
+	return q, nil
+}
@@ -2,4 +2,8 @@ module gb-backend2
 
 go 1.17
 
-require github.com/google/uuid v1.3.0
+require (
+	github.com/google/uuid v1.3.0
+	github.com/gorilla/mux v1.8.1
+	golang.org/x/crypto v0.9.0
+)
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// APIKey - ключ доступа, Key заполнен только в ответе на создание
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func apiKeyFrom(k user.APIKey) APIKey {
+	return APIKey{
+		ID:         k.ID,
+		UserID:     k.UserID,
+		Name:       k.Name,
+		Scopes:     k.Scopes.Names(),
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
+
+type scopesKey struct{}
+
+// withScopes ограничивает права запроса правами API-ключа
+func withScopes(ctx context.Context, p user.Permissions) context.Context {
+	return context.WithValue(ctx, scopesKey{}, p)
+}
+
+func scopesFromContext(ctx context.Context) (user.Permissions, bool) {
+	p, ok := ctx.Value(scopesKey{}).(user.Permissions)
+	return p, ok
+}
+
+// keyOwner возвращает пользователя из параметра uid (по умолчанию - самого
+// пользователя запроса) и проверяет, что с чужими ключами работает
+// пользователь с правами perm
+func (rt *Router) keyOwner(w http.ResponseWriter, r *http.Request, perm user.Permissions) (uuid.UUID, bool) {
+	p, ok := user.PrincipalFromContext(r.Context())
+	if !ok {
+		httpError(w, "unautorized", http.StatusUnauthorized)
+		return uuid.Nil, false
+	}
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		return p.ID, true
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	if uid != p.ID && !rt.hasPermissions(r, perm) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return uuid.Nil, false
+	}
+	return uid, true
+}
+
+// /apikey/create?uid=... - без uid ключ создаётся себе
+// {"name":"nightly-sync","scopes":["user:read"],"expires_at":"2030-01-01T00:00:00Z"}
+func (rt *Router) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	uid, ok := rt.keyOwner(w, r, user.PermUserWrite)
+	if !ok {
+		return
+	}
+	req := CreateAPIKeyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	scopes, err := user.ParsePermissions(strings.Join(req.Scopes, ","))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// ключ не может дать больше прав, чем есть у того, кто его создаёт
+	if !rt.hasPermissions(r, scopes) {
+		writeError(w, user.Forbidden("scopes exceed your permissions"))
+		return
+	}
+
+	k, key, err := rt.store.APIKeys.Create(r.Context(), user.APIKey{
+		UserID:    uid,
+		Name:      req.Name,
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	ak := apiKeyFrom(*k)
+	ak.Key = key
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(ak)
+}
+
+// /apikey/list?uid=...
+func (rt *Router) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uid, ok := rt.keyOwner(w, r, user.PermUserRead)
+	if !ok {
+		return
+	}
+	kk, err := rt.store.APIKeys.List(r.Context(), uid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]APIKey, 0, len(kk))
+	for _, k := range kk {
+		res = append(res, apiKeyFrom(k))
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// /apikey/revoke?id=...
+func (rt *Router) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := uuid.Parse(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	k, err := rt.store.APIKeys.Read(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	p, _ := user.PrincipalFromContext(r.Context())
+	if p == nil || (k.UserID != p.ID && !rt.hasPermissions(r, user.PermUserWrite)) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := rt.store.APIKeys.Revoke(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_APIKeys(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	svc, _ := store.User.Create(ctx, user.User{Name: "sync", Permissions: user.PermUserRead | user.PermUserWrite})
+
+	do := func(method, target, body string, auth func(r *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		auth(r)
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	admin := func(r *http.Request) { r.SetBasicAuth("admin", "admin") }
+
+	w := do(http.MethodPost, "/apikey/create?uid="+svc.ID.String(), `{"name":"nightly","scopes":["user:read"]}`, admin)
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	k := APIKey{}
+	if err := json.NewDecoder(w.Body).Decode(&k); err != nil {
+		t.Fatal(err)
+	}
+	if k.Key == "" || k.UserID != svc.ID {
+		t.Fatalf("wrong key: %+v", k)
+	}
+	withKey := func(key string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "ApiKey "+key) }
+	}
+
+	if w := do(http.MethodGet, "/user/search", "", withKey(k.Key)); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+	// у пользователя есть user:write, но у ключа - нет
+	if w := do(http.MethodPost, "/user/create", `{"name":"x"}`, withKey(k.Key)); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/apikey/create", `{"name":"wider","scopes":["user:write"]}`, withKey(k.Key)); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodGet, "/user/search", "", withKey(k.Key+"x")); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+
+	w = do(http.MethodGet, "/apikey/list?uid="+svc.ID.String(), "", admin)
+	kk := []APIKey{}
+	if err := json.NewDecoder(w.Body).Decode(&kk); err != nil {
+		t.Fatal(err)
+	}
+	if len(kk) != 1 || kk[0].Key != "" || kk[0].LastUsedAt == nil {
+		t.Errorf("wrong keys: %+v", kk)
+	}
+
+	if w := do(http.MethodDelete, "/apikey/revoke?id="+k.ID.String(), "", admin); w.Code != http.StatusNoContent {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodGet, "/user/search", "", withKey(k.Key)); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+}
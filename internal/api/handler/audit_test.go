@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/audit"
+	"gb-backend2/internal/app/repos/user"
+	"gb-backend2/internal/app/store"
+	"gb-backend2/internal/db/file/auditfile"
+
+	"github.com/google/uuid"
+)
+
+func TestRouter_Audit(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "user123"})
+	g, _ := store.Group.Create(ctx, user.Group{Name: "group123"})
+	_ = store.UserGroup.AddUserToGroup(ctx, *u, *g, user.RoleMember, time.Time{})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/group/delete_user?gid="+g.ID.String()+"&uid="+u.ID.String(), nil)
+	r.SetBasicAuth("admin", "admin")
+	r.Header.Set("X-Request-ID", "req-1")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/audit?action=membership.removed&user="+u.ID.String(), nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	page := AuditPage{}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 {
+		t.Fatalf("wrong entries: %+v", page.Entries)
+	}
+	e := page.Entries[0]
+	if e.RequestID != "req-1" || e.ActorName != "admin" || e.GroupID != g.ID || e.Before == nil {
+		t.Errorf("wrong entry: %+v", e)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/audit/verify", nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), `"ok":true`) {
+		t.Errorf("chain broken: %s", w.Body.String())
+	}
+}
+
+func TestRouter_AuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.ndjson")
+	af, err := auditfile.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, rt := newTestRouter(t, store.WithAuditStore(af))
+	ctx := user.AllTenants(context.Background())
+	u, _ := store.User.Create(ctx, user.User{Name: "user123"})
+
+	// вторая группа откатывается вместе с записями аудита о ней
+	for _, members := range []string{u.ID.String(), uuid.New().String()} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/group/create",
+			strings.NewReader(`{"name":"group-`+members+`","members":["`+members+`"]}`))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+	}
+	n, _ := store.Audit.Search(ctx, audit.Filter{})
+	af.Close()
+
+	af, err = auditfile.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, _ := af.SearchEntries(ctx, audit.Filter{Action: string(user.EventGroupCreated)})
+	all, _ := af.SearchEntries(ctx, audit.Filter{})
+	af.Close()
+	if len(es) != 1 || len(all) != len(n) {
+		t.Errorf("wrong entries: %d groups, %d of %d", len(es), len(all), len(n))
+	}
+
+	b, _ := os.ReadFile(path)
+	b = []byte(strings.Replace(string(b), "user123", "user124", 1))
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auditfile.Open(path); err == nil {
+		t.Error("tampered audit file opened")
+	}
+}
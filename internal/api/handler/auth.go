@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// LoginRequest - вход по имени или ID пользователя и паролю
+type LoginRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenResponse - выданные токены, ExpiresIn - время жизни access-токена в секундах
+type TokenResponse struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int       `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+type claimsKey struct{}
+
+func withClaims(ctx context.Context, c *user.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// claimsFromContext возвращает содержимое access-токена запроса,
+// если пользователь вошёл по токену
+func claimsFromContext(ctx context.Context) (*user.Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*user.Claims)
+	return c, ok
+}
+
+func writeTokens(w http.ResponseWriter, tp *user.TokenPair) {
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:      tp.AccessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(time.Until(tp.AccessExpiresAt).Round(time.Second).Seconds()),
+		RefreshToken:     tp.RefreshToken,
+		RefreshExpiresAt: tp.RefreshExpiresAt,
+	})
+}
+
+// loginContext - контекст поиска пользователя при входе: с заголовком
+// арендатора - в нём, без заголовка - среди всех арендаторов
+func loginContext(r *http.Request) context.Context {
+	if _, ok := user.TenantFromContext(r.Context()); ok {
+		return r.Context()
+	}
+	return user.AllTenants(r.Context())
+}
+
+// /auth/login
+// {"login":"admin","password":"..."}
+func (rt *Router) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	req := LoginRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	tp, err := rt.store.Tokens.Login(loginContext(r), req.Login, req.Password)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeTokens(w, tp)
+}
+
+// /auth/refresh - обмен refresh-токена на новую пару токенов,
+// старый refresh-токен больше не действует
+// {"refresh_token":"..."}
+func (rt *Router) Refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	req := RefreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	tp, err := rt.store.Tokens.Refresh(loginContext(r), req.RefreshToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeTokens(w, tp)
+}
+
+// /auth/logout - отзыв сеанса, по токену которого выполнен запрос
+func (rt *Router) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c, ok := claimsFromContext(r.Context())
+	if !ok {
+		httpError(w, "not a token session", http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.store.Tokens.Revoke(r.Context(), c.SessionID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /auth/revoke?sid=... - отзыв одного сеанса
+// /auth/revoke?uid=... - отзыв всех сеансов пользователя
+func (rt *Router) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	n := 1
+	switch {
+	case q.Get("sid") != "":
+		sid, err := uuid.Parse(q.Get("sid"))
+		if err != nil {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if err := rt.store.Tokens.Revoke(r.Context(), sid); err != nil {
+			writeError(w, err)
+			return
+		}
+	case q.Get("uid") != "":
+		uid, err := uuid.Parse(q.Get("uid"))
+		if err != nil {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		n, err = rt.store.Tokens.RevokeUser(r.Context(), uid)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	default:
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]int{"revoked": n})
+}
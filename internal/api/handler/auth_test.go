@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter_Tokens(t *testing.T) {
+	_, rt := newTestRouter(t)
+
+	do := func(method, target, body, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	tokens := func(w *httptest.ResponseRecorder) TokenResponse {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatal("status wrong:", w.Code)
+		}
+		tr := TokenResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&tr); err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+
+	if w := do(http.MethodPost, "/auth/login", `{"login":"admin","password":"wrong"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+	tr := tokens(do(http.MethodPost, "/auth/login", `{"login":"admin","password":"admin"}`, ""))
+	if w := do(http.MethodGet, "/user/search", "", tr.AccessToken); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodGet, "/user/search", "", tr.AccessToken+"x"); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+
+	// обновление выдаёт новую пару, старый refresh-токен больше не действует,
+	// а его повторное использование отзывает сеанс
+	ntr := tokens(do(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tr.RefreshToken+`"}`, ""))
+	if ntr.RefreshToken == tr.RefreshToken {
+		t.Error("refresh token not rotated")
+	}
+	if w := do(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tr.RefreshToken+`"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodGet, "/user/search", "", ntr.AccessToken); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+
+	tr = tokens(do(http.MethodPost, "/auth/login", `{"login":"admin","password":"admin"}`, ""))
+	if w := do(http.MethodPost, "/auth/logout", "", tr.AccessToken); w.Code != http.StatusNoContent {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodGet, "/user/search", "", tr.AccessToken); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/auth/refresh", `{"refresh_token":"`+tr.RefreshToken+`"}`, ""); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+}
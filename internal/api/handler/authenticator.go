@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"gb-backend2/internal/app/repos/user"
+	"gb-backend2/internal/app/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrNoCredentials - в запросе нет данных для этого способа входа,
+// цепочка переходит к следующему
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrUnknownLogin - данные для входа есть, но этот способ не знает такого
+// пользователя. Цепочка переходит к следующему способу, а если никто
+// не опознает пользователя, отвечает user.ErrUnauthorized.
+var ErrUnknownLogin = fmt.Errorf("%w: unknown login", ErrNoCredentials)
+
+// Anonymous - пользователь публичных маршрутов, пришедших без данных для входа.
+// У него нет ID и прав.
+var Anonymous = user.User{Name: "anonymous"}
+
+// Identity - пользователь, от имени которого выполняется запрос
+type Identity struct {
+	User user.User
+	// Scopes, если задан, ограничивает права запроса (API-ключ)
+	Scopes *user.Permissions
+	// Claims - содержимое access-токена, если вход по токену
+	Claims *user.Claims
+}
+
+// Authenticator проверяет данные для входа из запроса. Если их нет,
+// возвращает ErrNoCredentials, если пользователь ему неизвестен -
+// ErrUnknownLogin, если данные неверны - user.ErrUnauthorized.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// challenger - способ входа, о котором сообщается в WWW-Authenticate
+type challenger interface {
+	Challenge() string
+}
+
+// Chain пробует способы входа по порядку. Решает первый, знающий
+// пользователя из запроса: при ошибке остальные не пробуются. Поэтому
+// PasswordAuth и HtpasswdAuth можно ставить в любом порядке: пользователь
+// без пароля в хранилище или без строки в htpasswd проверяется следующим.
+// Если имя есть в обоих, решает первый в цепочке.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	res := ErrNoCredentials
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if errors.Is(err, ErrUnknownLogin) {
+			res = user.ErrUnauthorized
+			continue
+		}
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	return nil, res
+}
+
+func (c Chain) Challenge() string {
+	var cs []string
+	for _, a := range c {
+		if ch, ok := a.(challenger); ok {
+			cs = append(cs, ch.Challenge())
+		}
+	}
+	return strings.Join(cs, ", ")
+}
+
+// DefaultAuthenticators - вход по сохранённому паролю, access-токену и API-ключу
+func DefaultAuthenticators(st *store.Store) Chain {
+	return Chain{
+		PasswordAuth{Credentials: st.Credentials},
+		BearerAuth{Tokens: st.Tokens},
+		APIKeyAuth{Keys: st.APIKeys},
+	}
+}
+
+// PasswordAuth - Basic-авторизация по паролям из хранилища. Пользователь
+// без сохранённого пароля ему неизвестен (ErrUnknownLogin).
+type PasswordAuth struct {
+	Credentials *user.Credentials
+}
+
+func (a PasswordAuth) Authenticate(r *http.Request) (*Identity, error) {
+	login, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	u, err := a.Credentials.Authenticate(r.Context(), login, password)
+	if errors.Is(err, user.ErrNoPassword) {
+		return nil, ErrUnknownLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{User: *u}, nil
+}
+
+func (a PasswordAuth) Challenge() string {
+	return `Basic realm="reguser"`
+}
+
+// BearerAuth - access-токены, выданные /auth/login
+type BearerAuth struct {
+	Tokens *user.Tokens
+}
+
+func (a BearerAuth) Authenticate(r *http.Request) (*Identity, error) {
+	token := authToken(r, "Bearer")
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+	u, c, err := a.Tokens.Authenticate(r.Context(), token)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{User: *u, Claims: c}, nil
+}
+
+func (a BearerAuth) Challenge() string {
+	return `Bearer realm="reguser"`
+}
+
+// APIKeyAuth - API-ключи (Authorization: ApiKey), права ограничены scopes ключа
+type APIKeyAuth struct {
+	Keys *user.APIKeys
+}
+
+func (a APIKeyAuth) Authenticate(r *http.Request) (*Identity, error) {
+	key := authToken(r, "ApiKey")
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	u, k, err := a.Keys.Authenticate(r.Context(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{User: *u, Scopes: &k.Scopes}, nil
+}
+
+// CertAuth - клиентский сертификат (mTLS), проверенный сервером.
+// CommonName сертификата - имя или ID пользователя.
+type CertAuth struct {
+	Users *user.Users
+}
+
+func (a CertAuth) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	u, err := a.Users.FindByLogin(r.Context(), cn)
+	if errors.Is(err, user.ErrNotFound) || errors.Is(err, user.ErrConflict) {
+		return nil, user.ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{User: *u}, nil
+}
+
+// HtpasswdAuth - Basic-авторизация по файлу htpasswd (только bcrypt).
+// Имя из файла должно совпадать с именем пользователя в хранилище,
+// права берутся оттуда.
+type HtpasswdAuth struct {
+	Users  *user.Users
+	hashes map[string][]byte
+}
+
+// OpenHtpasswd читает файл htpasswd
+func OpenHtpasswd(path string, users *user.Users) (*HtpasswdAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadHtpasswd(f, users)
+}
+
+// LoadHtpasswd разбирает строки вида name:$2y$...
+func LoadHtpasswd(r io.Reader, users *user.Users) (*HtpasswdAuth, error) {
+	a := &HtpasswdAuth{
+		Users:  users,
+		hashes: make(map[string][]byte),
+	}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("htpasswd line %d: malformed", n)
+		}
+		hash := line[i+1:]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: only bcrypt hashes are supported", n)
+		}
+		a.hashes[line[:i]] = []byte(hash)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *HtpasswdAuth) Authenticate(r *http.Request) (*Identity, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash, ok := a.hashes[name]
+	if !ok {
+		// этого имени нет в файле - пусть проверят следующие способы
+		return nil, ErrUnknownLogin
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, user.ErrUnauthorized
+	}
+	u, err := a.Users.FindByLogin(r.Context(), name)
+	if errors.Is(err, user.ErrNotFound) || errors.Is(err, user.ErrConflict) {
+		return nil, user.ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{User: *u}, nil
+}
+
+func (a *HtpasswdAuth) Challenge() string {
+	return `Basic realm="reguser"`
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRouter_Authenticators(t *testing.T) {
+	st, _ := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	svc, _ := st.User.Create(ctx, user.User{Name: "svc", Permissions: user.PermUserRead})
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	ht, err := LoadHtpasswd(strings.NewReader("# users\nsvc:"+string(hash)+"\n"), st.User)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := NewRouter(st, ht, PasswordAuth{Credentials: st.Credentials}, CertAuth{Users: st.User})
+
+	do := func(target string, prepare func(r *http.Request)) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		prepare(r)
+		rt.ServeHTTP(w, r)
+		return w.Code
+	}
+	none := func(r *http.Request) {}
+	basic := func(login, password string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(login, password) }
+	}
+	cert := func(cn string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: cn}},
+			}}}
+		}
+	}
+
+	if code := do("/health", none); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+	if code := do("/user/search", none); code != http.StatusUnauthorized {
+		t.Error("status wrong:", code)
+	}
+	if code := do("/user/search", basic("svc", "secret")); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+	// имя есть в htpasswd, дальше по цепочке неверный пароль не проверяется
+	if code := do("/user/search", basic("svc", "wrong")); code != http.StatusUnauthorized {
+		t.Error("status wrong:", code)
+	}
+	if code := do("/user/search", basic("admin", "admin")); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+	if code := do("/user/search", cert(svc.ID.String())); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+	if code := do("/user/search", cert("stranger")); code != http.StatusUnauthorized {
+		t.Error("status wrong:", code)
+	}
+	if code := do("/user/create", cert("svc")); code != http.StatusForbidden {
+		t.Error("status wrong:", code)
+	}
+
+	// пароля svc нет в хранилище, поэтому его проверяет htpasswd и после PasswordAuth
+	rt = NewRouter(st, PasswordAuth{Credentials: st.Credentials}, ht)
+	if code := do("/user/search", basic("svc", "secret")); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+	if code := do("/user/search", basic("admin", "admin")); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+	for _, login := range []string{"svc", "nobody"} {
+		if code := do("/health", basic(login, "wrong")); code != http.StatusUnauthorized {
+			t.Errorf("%s: status wrong: %d", login, code)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// deletedFields возвращает поля удаления для ответа, nil если запись не удалена
+func deletedFields(at time.Time, by uuid.UUID) (*time.Time, *uuid.UUID) {
+	if at.IsZero() {
+		return nil, nil
+	}
+	if (by == uuid.UUID{}) {
+		return &at, nil
+	}
+	return &at, &by
+}
+
+// restore?uid=...
+func (rt *Router) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (uid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	nbu, err := rt.store.User.Restore(r.Context(), uid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		User{
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
+		},
+	)
+}
+
+func (rt *Router) SearchDeletedUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ch, err := rt.store.User.SearchDeleted(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+
+	first := true
+	fmt.Fprintf(w, "[")
+	defer fmt.Fprintln(w, "]")
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case u, ok := <-ch:
+			if !ok {
+				return
+			}
+			if first {
+				first = false
+			} else {
+				fmt.Fprintf(w, ",")
+			}
+			at, by := deletedFields(u.DeletedAt, u.DeletedBy)
+			_ = enc.Encode(
+				User{
+					ID:            u.ID,
+					Tenant:        u.Tenant,
+					Name:          u.Name,
+					Email:         u.Email,
+					EmailVerified: u.EmailVerified,
+					State:         u.State,
+					Attrs:         u.Attrs,
+					Permission:    u.Permissions,
+					Version:       u.Version,
+					CreatedAt:     u.CreatedAt,
+					DeletedAt:     at,
+					DeletedBy:     by,
+				},
+			)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// restore?uid=...
+func (rt *Router) RestoreGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (uid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ngu, err := rt.store.Group.Restore(r.Context(), uid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		Group{
+			ID:         ngu.ID,
+			Tenant:     ngu.Tenant,
+			Name:       ngu.Name,
+			JoinPolicy: ngu.JoinPolicy,
+			Approvals:  ngu.Approvals,
+			Rule:       ngu.Rule,
+			Permission: ngu.Permissions,
+			Version:    ngu.Version,
+			CreatedAt:  ngu.CreatedAt,
+		},
+	)
+}
+
+func (rt *Router) SearchDeletedGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ch, err := rt.store.Group.SearchDeleted(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+
+	first := true
+	fmt.Fprintf(w, "[")
+	defer fmt.Fprintln(w, "]")
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case g, ok := <-ch:
+			if !ok {
+				return
+			}
+			if first {
+				first = false
+			} else {
+				fmt.Fprintf(w, ",")
+			}
+			at, by := deletedFields(g.DeletedAt, g.DeletedBy)
+			_ = enc.Encode(
+				Group{
+					ID:         g.ID,
+					Tenant:     g.Tenant,
+					Name:       g.Name,
+					JoinPolicy: g.JoinPolicy,
+					Approvals:  g.Approvals,
+					Rule:       g.Rule,
+					Permission: g.Permissions,
+					Version:    g.Version,
+					CreatedAt:  g.CreatedAt,
+					DeletedAt:  at,
+					DeletedBy:  by,
+				},
+			)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
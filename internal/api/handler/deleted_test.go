@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_RestoreUser(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "user123"})
+	g, _ := store.Group.Create(ctx, user.Group{Name: "group123"})
+	_ = store.UserGroup.AddUserToGroup(ctx, *u, *g, user.RoleMember, time.Time{})
+
+	for _, tc := range []struct {
+		method, url string
+		code        int
+	}{
+		{http.MethodDelete, "/user/delete?uid=" + u.ID.String(), http.StatusOK},
+		{http.MethodGet, "/user/read?uid=" + u.ID.String(), http.StatusNotFound},
+		{http.MethodPost, "/user/restore?uid=" + u.ID.String(), http.StatusOK},
+		{http.MethodGet, "/user/read?uid=" + u.ID.String(), http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: status wrong: %d", tc.method, tc.url, w.Code)
+		}
+	}
+
+	ms, err := store.UserGroup.GetGroupUsersTransitive(ctx, *g)
+	if err != nil || len(ms) != 1 {
+		t.Errorf("membership lost: %v %v", ms, err)
+	}
+
+	_, _ = store.User.Delete(ctx, u.ID)
+	n, err := store.User.Purge(ctx, time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Errorf("purge: %d %v", n, err)
+	}
+	if _, err := store.User.Restore(ctx, u.ID); err == nil {
+		t.Error("purged user restored")
+	}
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+// ErrorResponse - тело ответа при любой ошибке
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+var errorStatus = map[user.ErrorCode]int{
+	user.CodeNotFound:      http.StatusNotFound,
+	user.CodeAlreadyExists: http.StatusConflict,
+	user.CodeConflict:      http.StatusConflict,
+	user.CodeInvalid:       http.StatusBadRequest,
+	user.CodeForbidden:     http.StatusForbidden,
+	user.CodeUnauthorized:  http.StatusUnauthorized,
+	user.CodeQuotaExceeded: http.StatusForbidden,
+}
+
+var statusCode = map[int]string{
+	http.StatusBadRequest:          string(user.CodeInvalid),
+	http.StatusUnauthorized:        string(user.CodeUnauthorized),
+	http.StatusForbidden:           string(user.CodeForbidden),
+	http.StatusNotFound:            string(user.CodeNotFound),
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            string(user.CodeConflict),
+	http.StatusInternalServerError: "internal",
+}
+
+// httpError - аналог http.Error, отвечающий JSON с кодом ошибки по статусу
+func httpError(w http.ResponseWriter, message string, status int) {
+	code, ok := statusCode[status]
+	if !ok {
+		code = "error"
+	}
+	writeErrorBody(w, status, ErrorBody{Code: code, Message: message})
+}
+
+// writeError переводит ошибку предметной области в статус ответа,
+// остальные ошибки считаются внутренними и клиенту не показываются
+func writeError(w http.ResponseWriter, err error) {
+	status, body := errorBody(err)
+	writeErrorBody(w, status, body)
+}
+
+func errorBody(err error) (int, ErrorBody) {
+	var de *user.Error
+	if !errors.As(err, &de) {
+		log.Println(err)
+		return http.StatusInternalServerError,
+			ErrorBody{Code: statusCode[http.StatusInternalServerError], Message: "internal error"}
+	}
+	status, ok := errorStatus[de.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	msg := de.Message
+	if msg == "" {
+		msg = de.Error()
+	}
+	return status, ErrorBody{
+		Code:    string(de.Code),
+		Message: msg,
+		Fields:  de.Fields,
+	}
+}
+
+func writeErrorBody(w http.ResponseWriter, status int, body ErrorBody) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ErrorResponse{Error: body})
+}
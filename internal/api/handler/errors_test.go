@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRouter_ErrorBody(t *testing.T) {
+	_, rt := newTestRouter(t)
+
+	for _, tc := range []struct {
+		method, url string
+		code        int
+		errCode     string
+	}{
+		{http.MethodGet, "/user/read?uid=" + uuid.New().String(), http.StatusNotFound, "not_found"},
+		{http.MethodPost, "/user/grant?uid=" + uuid.New().String() + "&perm=nope", http.StatusBadRequest, "invalid"},
+		{http.MethodPost, "/user/read?uid=" + uuid.New().String(), http.StatusMethodNotAllowed, "method_not_allowed"},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: status wrong: %d", tc.method, tc.url, w.Code)
+		}
+		er := ErrorResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&er); err != nil {
+			t.Fatal(err)
+		}
+		if er.Error.Code != tc.errCode {
+			t.Errorf("%s %s: code wrong: %s", tc.method, tc.url, er.Error.Code)
+		}
+	}
+}
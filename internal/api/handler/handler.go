@@ -1,12 +1,16 @@
 package handler
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"gb-backend2/internal/app/repos/audit"
 	"gb-backend2/internal/app/repos/user"
 	"gb-backend2/internal/app/store"
 
@@ -16,67 +20,337 @@ import (
 type Router struct {
 	*http.ServeMux
 	store *store.Store
+	auth  Chain
 }
 
-func NewRouter(store *store.Store) *Router {
+// NewRouter создаёт роутер со способами входа auth, проверяемыми по порядку,
+// без них - с DefaultAuthenticators
+func NewRouter(store *store.Store, auth ...Authenticator) *Router {
+	if len(auth) == 0 {
+		auth = DefaultAuthenticators(store)
+	}
 	r := &Router{
 		ServeMux: http.NewServeMux(),
 		store:    store,
-	}
-	r.Handle("/user/create",
-		r.AuthMiddleware(
-			http.HandlerFunc(r.CreateUser),
-		),
-	)
-	r.Handle("/user/read", r.AuthMiddleware(http.HandlerFunc(r.ReadUser)))
-	r.Handle("/user/delete", r.AuthMiddleware(http.HandlerFunc(r.DeleteUser)))
-	r.Handle("/user/search", r.AuthMiddleware(http.HandlerFunc(r.SearchUser)))
-	r.Handle("/user/get_groups", r.AuthMiddleware(http.HandlerFunc(r.GetGroups)))
-	r.Handle("/user/add_group", r.AuthMiddleware(http.HandlerFunc(r.AddUserToGroup)))
-	r.Handle("/user/delete_group", r.AuthMiddleware(http.HandlerFunc(r.DeleteUserFromGroup)))
-
-	r.Handle("/group/create",
-		r.AuthMiddleware(
-			http.HandlerFunc(r.CreateGroup),
-		),
-	)
-	r.Handle("/group/read", r.AuthMiddleware(http.HandlerFunc(r.ReadGroup)))
-	r.Handle("/group/delete", r.AuthMiddleware(http.HandlerFunc(r.DeleteGroup)))
-	r.Handle("/group/search", r.AuthMiddleware(http.HandlerFunc(r.SearchGroup)))
-	r.Handle("/group/add_user", r.AuthMiddleware(http.HandlerFunc(r.AddUserToGroup)))
-	r.Handle("/group/delete_user", r.AuthMiddleware(http.HandlerFunc(r.DeleteUserFromGroup)))
+		auth:     auth,
+	}
+	r.route("/user/create", user.PermUserWrite, r.CreateUser)
+	r.route("/user/read", user.PermUserRead, r.ReadUser)
+	r.route("/user/by_name", user.PermUserRead, r.ReadUserByName)
+	r.route("/user/by_email", user.PermUserRead, r.ReadUserByEmail)
+	r.route("/user/update", user.PermUserWrite, r.UpdateUser)
+	r.route("/user/delete", user.PermUserWrite, r.DeleteUser)
+	r.route("/user/search", user.PermUserRead, r.SearchUser)
+	r.route("/user/import", user.PermUserWrite, r.ImportUsers)
+	r.route("/user/restore", user.PermUserWrite, r.RestoreUser)
+	r.route("/user/deleted", user.PermUserRead, r.SearchDeletedUsers)
+	r.route("/user/get_groups", user.PermUserRead|user.PermGroupRead, r.GetGroups)
+	r.route("/user/add_group", user.PermGroupWrite, r.AddUserToGroup)
+	r.route("/user/delete_group", user.PermGroupWrite, r.DeleteUserFromGroup)
+	r.route("/user/grant", user.PermGrant, r.GrantUser)
+	r.route("/user/revoke", user.PermGrant, r.RevokeUser)
+	r.route("/user/permissions", user.PermUserRead, r.UserPermissions)
+	r.route("/user/suspend", user.PermUserWrite, r.SuspendUser)
+	r.route("/user/reactivate", user.PermUserWrite, r.ReactivateUser)
+	r.route("/user/set_password", user.PermUserWrite, r.SetPassword)
+	r.route("/user/change_password", 0, r.ChangePassword)
+
+	r.route("/group/create", user.PermGroupWrite, r.CreateGroup)
+	r.route("/group/read", user.PermGroupRead, r.ReadGroup)
+	r.route("/group/by_name", user.PermGroupRead, r.ReadGroupByName)
+	r.route("/group/update", user.PermGroupWrite, r.UpdateGroup)
+	r.route("/group/delete", user.PermGroupWrite, r.DeleteGroup)
+	r.route("/group/search", user.PermGroupRead, r.SearchGroup)
+	r.route("/group/restore", user.PermGroupWrite, r.RestoreGroup)
+	r.route("/group/deleted", user.PermGroupRead, r.SearchDeletedGroups)
+	r.route("/group/add_user", user.PermGroupWrite, r.AddUserToGroup)
+	r.route("/group/delete_user", user.PermGroupWrite, r.DeleteUserFromGroup)
+	r.route("/group/members", user.PermUserRead|user.PermGroupRead, r.GroupMembers)
+	r.route("/group/set_role", user.PermGroupWrite, r.SetUserRole)
+	r.route("/group/extend", user.PermGroupWrite, r.ExtendMembership)
+	r.route("/group/invite", 0, r.CreateInvitation)
+	r.route("/group/invites", 0, r.ListInvitations)
+	r.route("/group/revoke_invite", 0, r.RevokeInvitation)
+	r.route("/invite/accept", 0, r.AcceptInvitation)
+	r.route("/invite/decline", 0, r.DeclineInvitation)
+	r.route("/group/preview", user.PermUserRead, r.PreviewRule)
+	r.route("/group/join", 0, r.JoinGroup)
+	r.route("/group/requests", 0, r.ListJoinRequests)
+	r.route("/group/approve_request", 0, r.ApproveJoinRequest)
+	r.route("/group/reject_request", 0, r.RejectJoinRequest)
+	r.route("/review/start", user.PermGroupWrite, r.StartReview)
+	r.route("/review/list", user.PermGroupRead, r.ListReviews)
+	r.route("/review/items", 0, r.ListReviewItems)
+	r.route("/review/decide", 0, r.DecideReview)
+	r.route("/review/report", user.PermAuditRead, r.ReviewReport)
+	r.public("/review/public_key", r.ReviewPublicKey)
+	r.route("/group/add_group", user.PermGroupWrite, r.AddGroupToGroup)
+	r.route("/group/delete_group", user.PermGroupWrite, r.DeleteGroupFromGroup)
+	r.route("/group/grant", user.PermGrant, r.GrantGroup)
+	r.route("/group/revoke", user.PermGrant, r.RevokeGroup)
+
+	r.route("/schema/attrs", user.PermUserRead, r.ListAttrs)
+	r.route("/schema/define", user.PermSchemaWrite, r.DefineAttr)
+	r.route("/schema/remove", user.PermSchemaWrite, r.RemoveAttr)
+
+	r.public("/health", r.Health)
+
+	r.public("/auth/login", r.Login)
+	r.public("/auth/refresh", r.Refresh)
+	r.route("/auth/logout", 0, r.Logout)
+	r.route("/auth/revoke", user.PermUserWrite, r.RevokeSessions)
+
+	r.route("/apikey/create", 0, r.CreateAPIKey)
+	r.route("/apikey/list", 0, r.ListAPIKeys)
+	r.route("/apikey/revoke", 0, r.RevokeAPIKey)
+
+	r.route("/audit", user.PermAuditRead, r.SearchAudit)
+	r.route("/audit/verify", user.PermAuditRead, r.VerifyAudit)
 
 	return r
 }
 
+// CreateGroupRequest - новая группа и её начальные участники
+type CreateGroupRequest struct {
+	Group
+	Members []uuid.UUID `json:"members"`
+}
+
+var errNoPrincipal = errors.New("no principal")
+
 type User struct {
-	ID         uuid.UUID `json:"id"`
-	Name       string    `json:"name"`
-	Data       string    `json:"data"`
-	Permission int       `json:"perms"`
+	ID     uuid.UUID `json:"id"`
+	Tenant string    `json:"tenant,omitempty"`
+	Name   string    `json:"name"`
+	Email  string    `json:"email,omitempty"`
+	// EmailVerified задаётся только вместе с тем же адресом: при смене
+	// адреса подтверждение сбрасывается
+	EmailVerified bool `json:"email_verified,omitempty"`
+	// State при создании - invited или active (по умолчанию),
+	// дальше меняется через /user/suspend и /user/reactivate
+	State      user.State       `json:"state,omitempty"`
+	Attrs      user.Attributes  `json:"attrs,omitempty"`
+	Permission user.Permissions `json:"perms"`
+	Version    int              `json:"version"`
+	CreatedAt  time.Time        `json:"created_at"`
+	DeletedAt  *time.Time       `json:"deleted_at,omitempty"`
+	DeletedBy  *uuid.UUID       `json:"deleted_by,omitempty"`
 }
 
 type Group struct {
-	ID   uuid.UUID `json:"id"`
-	Name string    `json:"name"`
+	ID     uuid.UUID `json:"id"`
+	Tenant string    `json:"tenant,omitempty"`
+	Name   string    `json:"name"`
+	// JoinPolicy - closed (по умолчанию), open или approval,
+	// Approvals - сколько одобрений нужно заявке (1 или 2)
+	JoinPolicy user.JoinPolicy `json:"join_policy,omitempty"`
+	Approvals  int             `json:"approvals,omitempty"`
+	// Rule - правило динамической группы, её состав меняется только им
+	Rule       string           `json:"rule,omitempty"`
+	Permission user.Permissions `json:"perms"`
+	Version    int              `json:"version"`
+	CreatedAt  time.Time        `json:"created_at"`
+	DeletedAt  *time.Time       `json:"deleted_at,omitempty"`
+	DeletedBy  *uuid.UUID       `json:"deleted_by,omitempty"`
+}
+
+// route регистрирует обработчик, доступный только пользователям с правами perm
+func (rt *Router) route(pattern string, perm user.Permissions, h http.HandlerFunc) {
+	rt.Handle(pattern, RequestID(rt.AuthMiddleware(rt.RequirePermissions(perm, h))))
+}
+
+// public регистрирует обработчик, доступный без входа
+func (rt *Router) public(pattern string, h http.HandlerFunc) {
+	rt.Handle(pattern, RequestID(rt.AllowAnonymous(h)))
+}
+
+// RequestID берёт идентификатор запроса из заголовка X-Request-ID или
+// создаёт новый, кладёт его в контекст для журнала аудита и в ответ
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get("X-Request-ID")
+			if id == "" || len(id) > 128 {
+				id = uuid.NewString()
+			}
+			w.Header().Set("X-Request-ID", id)
+			r = r.WithContext(audit.WithRequestID(r.Context(), id))
+			next.ServeHTTP(w, r)
+		},
+	)
 }
 
+// AuthMiddleware пропускает запрос, только если его пользователя
+// опознал один из способов входа роутера
 func (rt *Router) AuthMiddleware(next http.Handler) http.Handler {
+	return rt.authenticate(next, false)
+}
+
+// AllowAnonymous - как AuthMiddleware, но запрос без данных для входа
+// выполняется от имени Anonymous
+func (rt *Router) AllowAnonymous(next http.Handler) http.Handler {
+	return rt.authenticate(next, true)
+}
+
+func (rt *Router) authenticate(next http.Handler, anonymous bool) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			tenant, fromHeader := tenantHeader(r)
+			id, err := rt.identify(r, tenant, fromHeader)
+			if errors.Is(err, ErrNoCredentials) && anonymous {
+				// без заголовка публичный запрос не видит ни одного арендатора,
+				// вход ищет пользователя среди всех сам (loginContext)
+				ctx := user.WithPrincipal(r.Context(), Anonymous)
+				if fromHeader {
+					ctx = user.WithTenant(ctx, tenant)
+				}
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			if err != nil {
+				if ch := rt.auth.Challenge(); ch != "" {
+					w.Header().Set("WWW-Authenticate", ch)
+				}
+				if errors.Is(err, ErrNoCredentials) {
+					err = user.ErrUnauthorized
+				}
+				writeError(w, err)
+				return
+			}
+
+			// без заголовка запрос работает в арендаторе пользователя,
+			// с заголовком - в указанном, если пользователь из него или оператор
+			if !fromHeader {
+				tenant = id.User.Tenant
+			} else if id.User.Tenant != tenant && id.User.Tenant != user.DefaultTenant {
+				writeError(w, user.Forbidden("tenant %q is not available", tenant))
+				return
+			}
+
+			if err := id.User.CanSignIn(); err != nil {
+				writeError(w, err)
+				return
+			}
+
+			ctx := user.WithTenant(user.WithPrincipal(r.Context(), id.User), tenant)
+			if id.Scopes != nil {
+				ctx = withScopes(ctx, *id.Scopes)
+			}
+			if id.Claims != nil {
+				ctx = withClaims(ctx, id.Claims)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		},
+	)
+}
+
+// TenantHeader - заголовок с арендатором запроса
+const TenantHeader = "X-Tenant-ID"
+
+func tenantHeader(r *http.Request) (string, bool) {
+	vs, ok := r.Header[http.CanonicalHeaderKey(TenantHeader)]
+	if !ok || len(vs) == 0 {
+		return "", false
+	}
+	return strings.TrimSpace(vs[0]), true
+}
+
+// identify проверяет данные для входа. С заголовком арендатора пользователь
+// ищется в этом арендаторе, а затем среди операторов арендатора
+// по умолчанию, без заголовка - среди всех арендаторов.
+func (rt *Router) identify(r *http.Request, tenant string, fromHeader bool) (*Identity, error) {
+	if !fromHeader {
+		return rt.auth.Authenticate(r.WithContext(user.AllTenants(r.Context())))
+	}
+	id, err := rt.auth.Authenticate(r.WithContext(user.WithTenant(r.Context(), tenant)))
+	if errors.Is(err, user.ErrUnauthorized) && tenant != user.DefaultTenant {
+		return rt.auth.Authenticate(r.WithContext(user.WithTenant(r.Context(), user.DefaultTenant)))
+	}
+	return id, err
+}
+
+// authToken возвращает значение заголовка Authorization со схемой scheme
+func authToken(r *http.Request, scheme string) string {
+	prefix := scheme + " "
+	h := r.Header.Get("Authorization")
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(h[len(prefix):])
+}
+
+// RequirePermissions пропускает запрос, только если у пользователя из контекста
+// (с учётом его групп) есть все права perm
+func (rt *Router) RequirePermissions(perm user.Permissions, next http.Handler) http.Handler {
 	return http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
-			if u, p, ok := r.BasicAuth(); !ok || !(u == "admin" && p == "admin") {
-				http.Error(w, "unautorized", http.StatusUnauthorized)
+			perms, err := rt.principalPermissions(r)
+			if err != nil {
+				httpError(w, "unautorized", http.StatusUnauthorized)
+				return
+			}
+			if !perms.Has(perm) {
+				httpError(w, "forbidden", http.StatusForbidden)
 				return
 			}
-			// r = r.WithContext(context.WithValue(r.Context(), 1, 0))
+			// репозиторий проверяет право раздавать права там, где его
+			// выдаёт не сам запрос, а правило динамической группы
+			r = r.WithContext(user.WithGrant(r.Context(), perms.Has(user.PermGrant)))
 			next.ServeHTTP(w, r)
 		},
 	)
 }
 
+func (rt *Router) principalPermissions(r *http.Request) (user.Permissions, error) {
+	u, ok := user.PrincipalFromContext(r.Context())
+	if !ok {
+		return 0, errNoPrincipal
+	}
+	// права оператора считаются по его группам, а не по группам
+	// арендатора, с которым он работает
+	ctx := user.WithTenant(r.Context(), u.Tenant)
+	perms, err := rt.store.UserGroup.EffectivePermissions(ctx, *u)
+	if err != nil {
+		return 0, err
+	}
+	if scopes, ok := scopesFromContext(r.Context()); ok {
+		perms &= scopes
+	}
+	return perms, nil
+}
+
+// hasPermissions сообщает, есть ли у пользователя из запроса все права perm
+func (rt *Router) hasPermissions(r *http.Request, perm user.Permissions) bool {
+	perms, err := rt.principalPermissions(r)
+	return err == nil && perms.Has(perm)
+}
+
+// canGrant сообщает, может ли пользователь из запроса раздавать права
+func (rt *Router) canGrant(r *http.Request) bool {
+	return rt.hasPermissions(r, user.PermGrant)
+}
+
+// checkGroupGrant запрещает добавлять участников в группу g, которая сама
+// или через родительские группы даёт права, если пользователь запроса не
+// может раздавать права: иначе group:write позволял бы выдать себе любые.
+// grant - результат canGrant, посчитанный до транзакции ctx.
+func (rt *Router) checkGroupGrant(ctx context.Context, g user.Group, grant bool) error {
+	if grant {
+		return nil
+	}
+	perms, err := rt.store.UserGroup.GroupPermissions(ctx, g)
+	if err != nil {
+		return err
+	}
+	if perms != 0 {
+		return user.Forbidden("group %s grants %s, changing its members requires %s", g.ID, perms, user.PermGrant)
+	}
+	return nil
+}
+
 func (rt *Router) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -84,18 +358,27 @@ func (rt *Router) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	u := User{}
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if u.Permission != 0 && !rt.canGrant(r) {
+		httpError(w, "forbidden", http.StatusForbidden)
 		return
 	}
 
 	bu := user.User{
-		Name: u.Name,
-		Data: u.Data,
+		Name:          u.Name,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerified,
+		State:         u.State,
+		Attrs:         u.Attrs,
+		Permissions:   u.Permission,
 	}
 
 	nbu, err := rt.store.User.Create(r.Context(), bu)
 	if err != nil {
-		http.Error(w, "error when creating", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -103,10 +386,16 @@ func (rt *Router) CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	_ = json.NewEncoder(w).Encode(
 		User{
-			ID:         nbu.ID,
-			Name:       nbu.Name,
-			Data:       nbu.Data,
-			Permission: nbu.Permissions,
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
 		},
 	)
 }
@@ -114,158 +403,281 @@ func (rt *Router) CreateUser(w http.ResponseWriter, r *http.Request) {
 // read?uid=...
 func (rt *Router) ReadUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	suid := r.URL.Query().Get("uid")
 	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	uid, err := uuid.Parse(suid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (uid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	nbu, err := rt.store.User.Read(r.Context(), uid)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
-		}
+		writeError(w, err)
 		return
 	}
 
 	_ = json.NewEncoder(w).Encode(
 		User{
-			ID:         nbu.ID,
-			Name:       nbu.Name,
-			Data:       nbu.Data,
-			Permission: nbu.Permissions,
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
 		},
 	)
 }
 
-func (rt *Router) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+// update?uid=...
+// PUT заменяет пользователя целиком, PATCH принимает JSON merge patch (RFC 7386).
+// В обоих случаях в теле должна быть version, прочитанная клиентом.
+func (rt *Router) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	suid := r.URL.Query().Get("uid")
 	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	uid, err := uuid.Parse(suid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (uid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	nbu, err := rt.store.User.Delete(r.Context(), uid)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	bu, err := rt.store.User.Read(r.Context(), uid)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		u := User{}
+		if err := json.Unmarshal(body, &u); err != nil {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
 		}
+		bu.Name = u.Name
+		bu.Email = u.Email
+		bu.EmailVerified = u.EmailVerified
+		bu.Attrs = u.Attrs
+		bu.Version = u.Version
+	} else if err := patchUser(bu, body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	nbu, err := rt.store.User.Update(r.Context(), *bu)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	_ = json.NewEncoder(w).Encode(
 		User{
-			ID:         nbu.ID,
-			Name:       nbu.Name,
-			Data:       nbu.Data,
-			Permission: nbu.Permissions,
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
 		},
 	)
 }
 
-// /search?q=...
-func (rt *Router) SearchUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+func (rt *Router) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
-	q := r.URL.Query().Get("q")
-	if q == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (uid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	ch, err := rt.store.User.SearchUsers(r.Context(), q)
+	nbu, err := rt.store.User.Delete(r.Context(), uid)
 	if err != nil {
-		http.Error(w, "error when reading", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	enc := json.NewEncoder(w)
+	_ = json.NewEncoder(w).Encode(
+		User{
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
+		},
+	)
+}
 
-	first := true
-	fmt.Fprintf(w, "[")
-	defer fmt.Fprintln(w, "]")
+// /search?q=...&state=active&attr.department=sales&sort=-created&limit=...&cursor=...
+// attr.<имя> отбирает пользователей по значению атрибута, для списков -
+// по наличию значения в списке. Следующую страницу запрашивают
+// с cursor из next, пока has_more.
+func (rt *Router) SearchUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
 
-	for {
-		select {
-		case <-r.Context().Done():
+	q := user.UserQuery{Name: r.URL.Query().Get("q")}
+	if s := r.URL.Query().Get("state"); s != "" {
+		st, err := user.ParseState(s)
+		if err != nil {
+			writeError(w, err)
 			return
-		case u, ok := <-ch:
-			if !ok {
-				return
-			}
-			if first {
-				first = false
-			} else {
-				fmt.Fprintf(w, ",")
+		}
+		q.State = st
+	}
+	for k, vs := range r.URL.Query() {
+		if name := strings.TrimPrefix(k, "attr."); name != k && len(vs) > 0 {
+			if q.Attrs == nil {
+				q.Attrs = make(map[string]string)
 			}
-			_ = enc.Encode(
-				User{
-					ID:         u.ID,
-					Name:       u.Name,
-					Data:       u.Data,
-					Permission: u.Permissions,
-				},
-			)
-			w.(http.Flusher).Flush()
+			q.Attrs[name] = vs[0]
 		}
 	}
+
+	p, err := pageParams(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	page, err := rt.store.User.SearchUsers(r.Context(), q, p)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := UserPage{
+		Users:   make([]User, 0, len(page.Users)),
+		Next:    page.Next,
+		HasMore: page.HasMore,
+	}
+	for _, u := range page.Users {
+		resp.Users = append(resp.Users,
+			User{
+				ID:            u.ID,
+				Tenant:        u.Tenant,
+				Name:          u.Name,
+				Email:         u.Email,
+				EmailVerified: u.EmailVerified,
+				State:         u.State,
+				Attrs:         u.Attrs,
+				Permission:    u.Permissions,
+				Version:       u.Version,
+				CreatedAt:     u.CreatedAt,
+			},
+		)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
 func (rt *Router) CreateGroup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	defer r.Body.Close()
 
-	u := User{}
+	u := CreateGroupRequest{}
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	gu := user.Group{
-		Name: u.Name,
+	if u.Permission != 0 && !rt.canGrant(r) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
 	}
 
-	ngu, err := rt.store.Group.Create(r.Context(), gu)
+	gu := user.Group{
+		Name:        u.Name,
+		JoinPolicy:  u.JoinPolicy,
+		Approvals:   u.Approvals,
+		Rule:        u.Rule,
+		Permissions: u.Permission,
+	}
+
+	// группа создаётся только вместе со всеми участниками
+	var ngu *user.Group
+	err := rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		var err error
+		ngu, err = rt.store.Group.Create(ctx, gu)
+		if err != nil {
+			return err
+		}
+		for _, uid := range u.Members {
+			mu, err := rt.store.User.Read(ctx, uid)
+			if err != nil {
+				return err
+			}
+			if err := rt.store.UserGroup.AddUserToGroup(ctx, *mu, *ngu, user.RoleMember, time.Time{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "error when creating", http.StatusInternalServerError)
+		if errors.Is(err, user.ErrNotFound) {
+			err = user.InvalidField("members", "%s", err.Error())
+		}
+		writeError(w, err)
 		return
 	}
 
@@ -273,8 +685,15 @@ func (rt *Router) CreateGroup(w http.ResponseWriter, r *http.Request) {
 
 	_ = json.NewEncoder(w).Encode(
 		Group{
-			ID:   ngu.ID,
-			Name: ngu.Name,
+			ID:         ngu.ID,
+			Tenant:     ngu.Tenant,
+			Name:       ngu.Name,
+			JoinPolicy: ngu.JoinPolicy,
+			Approvals:  ngu.Approvals,
+			Rule:       ngu.Rule,
+			Permission: ngu.Permissions,
+			Version:    ngu.Version,
+			CreatedAt:  ngu.CreatedAt,
 		},
 	)
 }
@@ -282,165 +701,266 @@ func (rt *Router) CreateGroup(w http.ResponseWriter, r *http.Request) {
 // read?uid=...
 func (rt *Router) ReadGroup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	suid := r.URL.Query().Get("uid")
 	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	uid, err := uuid.Parse(suid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (uid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	ngu, err := rt.store.Group.Read(r.Context(), uid)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		Group{
+			ID:         ngu.ID,
+			Tenant:     ngu.Tenant,
+			Name:       ngu.Name,
+			JoinPolicy: ngu.JoinPolicy,
+			Approvals:  ngu.Approvals,
+			Rule:       ngu.Rule,
+			Permission: ngu.Permissions,
+			Version:    ngu.Version,
+			CreatedAt:  ngu.CreatedAt,
+		},
+	)
+}
+
+// update?uid=...
+// PUT заменяет группу целиком, PATCH принимает JSON merge patch (RFC 7386).
+// В обоих случаях в теле должна быть version, прочитанная клиентом.
+func (rt *Router) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (uid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	gu, err := rt.store.Group.Read(r.Context(), uid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rule := gu.Rule
+	grant := rt.canGrant(r)
+
+	if r.Method == http.MethodPut {
+		g := Group{}
+		if err := json.Unmarshal(body, &g); err != nil {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		gu.Name = g.Name
+		gu.JoinPolicy = g.JoinPolicy
+		gu.Approvals = g.Approvals
+		gu.Rule = g.Rule
+		gu.Version = g.Version
+	} else if err := patchGroup(gu, body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// правило решает, кто войдёт в группу, поэтому для группы с правами
+	// его смена требует того же, что и добавление участников
+	var ngu *user.Group
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		if gu.Rule != rule {
+			if err := rt.checkGroupGrant(ctx, *gu, grant); err != nil {
+				return err
+			}
 		}
+		var err error
+		ngu, err = rt.store.Group.Update(ctx, *gu)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
 	_ = json.NewEncoder(w).Encode(
 		Group{
-			ID:   ngu.ID,
-			Name: ngu.Name,
+			ID:         ngu.ID,
+			Tenant:     ngu.Tenant,
+			Name:       ngu.Name,
+			JoinPolicy: ngu.JoinPolicy,
+			Approvals:  ngu.Approvals,
+			Rule:       ngu.Rule,
+			Permission: ngu.Permissions,
+			Version:    ngu.Version,
+			CreatedAt:  ngu.CreatedAt,
 		},
 	)
 }
 
 func (rt *Router) DeleteGroup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	suid := r.URL.Query().Get("uid")
 	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	uid, err := uuid.Parse(suid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (uid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	nbu, err := rt.store.Group.Delete(r.Context(), uid)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
-		}
+		writeError(w, err)
 		return
 	}
 
 	_ = json.NewEncoder(w).Encode(
 		Group{
-			ID:   nbu.ID,
-			Name: nbu.Name,
+			ID:         nbu.ID,
+			Tenant:     nbu.Tenant,
+			Name:       nbu.Name,
+			JoinPolicy: nbu.JoinPolicy,
+			Approvals:  nbu.Approvals,
+			Rule:       nbu.Rule,
+			Permission: nbu.Permissions,
+			Version:    nbu.Version,
+			CreatedAt:  nbu.CreatedAt,
 		},
 	)
 }
 
-// /search?q=...
+// /search?q=...&sort=name&limit=...&cursor=...
 func (rt *Router) SearchGroup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
-	q := r.URL.Query().Get("q")
-	if q == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	p, err := pageParams(r)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	ch, err := rt.store.Group.SearchGroups(r.Context(), q)
+	page, err := rt.store.Group.SearchGroups(r.Context(), r.URL.Query().Get("q"), p)
 	if err != nil {
-		http.Error(w, "error when reading", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
-	enc := json.NewEncoder(w)
-
-	first := true
-	fmt.Fprintf(w, "[")
-	defer fmt.Fprintln(w, "]")
-
-	for {
-		select {
-		case <-r.Context().Done():
-			return
-		case u, ok := <-ch:
-			if !ok {
-				return
-			}
-			if first {
-				first = false
-			} else {
-				fmt.Fprintf(w, ",")
-			}
-			_ = enc.Encode(
-				Group{
-					ID:   u.ID,
-					Name: u.Name,
-				},
-			)
-			w.(http.Flusher).Flush()
-		}
-	}
+	resp := GroupPage{
+		Groups:  make([]Group, 0, len(page.Groups)),
+		Next:    page.Next,
+		HasMore: page.HasMore,
+	}
+	for _, g := range page.Groups {
+		resp.Groups = append(resp.Groups,
+			Group{
+				ID:         g.ID,
+				Tenant:     g.Tenant,
+				Name:       g.Name,
+				JoinPolicy: g.JoinPolicy,
+				Approvals:  g.Approvals,
+				Rule:       g.Rule,
+				Permission: g.Permissions,
+				Version:    g.Version,
+				CreatedAt:  g.CreatedAt,
+			},
+		)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// get_groups?uid=...[&transitive=1]
+// с transitive=1 возвращает и группы, в которые пользователь входит
+// через вложенные группы, вместе с путём
 func (rt *Router) GetGroups(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	suid := r.URL.Query().Get("uid")
 	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	uid, err := uuid.Parse(suid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (uid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	user, err := rt.store.User.Read(r.Context(), uid)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
+		writeError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("transitive") == "1" {
+		ms, err := rt.store.UserGroup.GetUserGroupsTransitive(r.Context(), *user)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res := make([]GroupMembership, 0, len(ms))
+		for _, m := range ms {
+			res = append(res, groupMembership(m))
 		}
+		_ = json.NewEncoder(w).Encode(res)
 		return
 	}
 
 	ch, err := rt.store.UserGroup.GetUserGroups(r.Context(), *user)
 	if err != nil {
-		http.Error(w, "error when reading", http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -454,7 +974,7 @@ func (rt *Router) GetGroups(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			return
-		case u, ok := <-ch:
+		case m, ok := <-ch:
 			if !ok {
 				return
 			}
@@ -463,76 +983,79 @@ func (rt *Router) GetGroups(w http.ResponseWriter, r *http.Request) {
 			} else {
 				fmt.Fprintf(w, ",")
 			}
-			_ = enc.Encode(
-				Group{
-					ID:   u.ID,
-					Name: u.Name,
-				},
-			)
+			_ = enc.Encode(groupMembership(m))
 			w.(http.Flusher).Flush()
 		}
 	}
 }
 
+// add_user?uid=...&gid=...[&role=owner|manager|member][&expires_at=...]
+// expires_at в RFC 3339, без него членство бессрочное
 func (rt *Router) AddUserToGroup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	suid := r.URL.Query().Get("uid")
 	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	uid, err := uuid.Parse(suid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (uid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	sgid := r.URL.Query().Get("gid")
 	if sgid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	gid, err := uuid.Parse(sgid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (gid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	user, err := rt.store.User.Read(r.Context(), uid)
+	role, err := user.ParseRole(r.URL.Query().Get("role"))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
-		}
+		writeError(w, err)
 		return
 	}
-
-	group, err := rt.store.Group.Read(r.Context(), gid)
+	expiresAt, err := expiresParam(r)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
-		}
+		writeError(w, err)
 		return
 	}
 
-	err = rt.store.UserGroup.AddUserToGroup(r.Context(), *user, *group)
+	grant := rt.canGrant(r)
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		user, err := rt.store.User.Read(ctx, uid)
+		if err != nil {
+			return err
+		}
+		group, err := rt.store.Group.Read(ctx, gid)
+		if err != nil {
+			return err
+		}
+		if err := rt.checkGroupGrant(ctx, *group, grant); err != nil {
+			return err
+		}
+		return rt.store.UserGroup.AddUserToGroup(ctx, *user, *group, role, expiresAt)
+	})
 	if err != nil {
-		http.Error(w, "error add group", http.StatusInternalServerError)
+		writeError(w, err)
+		return
 	}
 
 	fmt.Fprintln(w, `{"status":"ok"}`)
@@ -540,64 +1063,64 @@ func (rt *Router) AddUserToGroup(w http.ResponseWriter, r *http.Request) {
 
 func (rt *Router) DeleteUserFromGroup(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
 		return
 	}
 
 	suid := r.URL.Query().Get("uid")
 	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	uid, err := uuid.Parse(suid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (uid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	sgid := r.URL.Query().Get("gid")
 	if sgid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	gid, err := uuid.Parse(sgid)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if (gid == uuid.UUID{}) {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
-	user, err := rt.store.User.Read(r.Context(), uid)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		user, err := rt.store.User.Read(ctx, uid)
+		if err != nil {
+			return err
 		}
-		return
-	}
-
-	group, err := rt.store.Group.Read(r.Context(), gid)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading", http.StatusInternalServerError)
+		group, err := rt.store.Group.Read(ctx, gid)
+		if err != nil {
+			return err
 		}
-		return
-	}
-
-	err = rt.store.UserGroup.DeleteUserFromGroup(r.Context(), *user, *group)
+		return rt.store.UserGroup.DeleteUserFromGroup(ctx, *user, *group)
+	})
 	if err != nil {
-		http.Error(w, "error add group", http.StatusInternalServerError)
+		writeError(w, err)
+		return
 	}
 
 	fmt.Fprintln(w, `{"status":"ok"}`)
 }
+
+// /health - проверка, что сервис отвечает, доступна без входа
+func (rt *Router) Health(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
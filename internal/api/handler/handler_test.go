@@ -1,21 +1,42 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"gb-backend2/internal/app/repos/user"
 	"gb-backend2/internal/app/store"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// newTestRouter создаёт хранилище с пользователем admin/admin
+func newTestRouter(t *testing.T, opts ...store.Option) (*store.Store, *Router) {
+	t.Helper()
+	st, err := store.NewStore(append([]store.Option{store.WithBcryptCost(bcrypt.MinCost)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Credentials.Bootstrap(user.AllTenants(context.Background()), st.User, "admin", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	return st, NewRouter(st)
+}
+
 func TestRouter_CreateUser(t *testing.T) {
-	store, _ := store.NewStore()
-	rt := NewRouter(store)
+	_, rt := newTestRouter(t)
 
 	hts := httptest.NewServer(rt)
 
-	r, _ := http.NewRequest("POST", hts.URL+"/create", strings.NewReader(`{"name":"user123"}`))
+	r, _ := http.NewRequest("POST", hts.URL+"/user/create", strings.NewReader(`{"name":"user123"}`))
 	r.SetBasicAuth("admin", "admin")
 
 	cli := hts.Client()
@@ -33,8 +54,7 @@ func TestRouter_CreateUser(t *testing.T) {
 }
 
 func TestRouter_CreateUser2(t *testing.T) {
-	store, _ := store.NewStore()
-	rt := NewRouter(store)
+	_, rt := newTestRouter(t)
 
 	h := rt.AuthMiddleware(http.HandlerFunc(rt.CreateUser)).ServeHTTP
 
@@ -48,3 +68,248 @@ func TestRouter_CreateUser2(t *testing.T) {
 		t.Error("status wrong:", w.Code)
 	}
 }
+
+func TestRouter_UpdateUser(t *testing.T) {
+	store, rt := newTestRouter(t)
+
+	ctx := user.AllTenants(context.Background())
+	for _, name := range []string{"a", "b", "c"} {
+		_, _ = store.Schema.Define(ctx, user.AttrDef{Name: name, Type: user.AttrNumber})
+	}
+	u, err := store.User.Create(ctx, user.User{Name: "user123", Attrs: user.Attributes{"a": 1, "b": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/user/update?uid="+u.ID.String(),
+		strings.NewReader(`{"version":1,"attrs":{"b":null,"c":3}}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	nu := User{}
+	if err := json.NewDecoder(w.Body).Decode(&nu); err != nil {
+		t.Fatal(err)
+	}
+	if nu.Version != 2 || nu.Name != "user123" || len(nu.Attrs) != 2 || nu.Attrs["a"] != 1.0 || nu.Attrs["c"] != 3.0 {
+		t.Errorf("wrong user: %+v", nu)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPut, "/user/update?uid="+u.ID.String(),
+		strings.NewReader(`{"version":1,"name":"user456"}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+
+	if w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+}
+
+func TestRouter_RequirePermissions(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "reader", Permissions: user.PermGroupRead})
+	g, _ := store.Group.Create(ctx, user.Group{Name: "writers", Permissions: user.PermUserWrite})
+
+	h := rt.RequirePermissions(user.PermUserWrite, http.HandlerFunc(rt.CreateUser))
+
+	r := httptest.NewRequest(http.MethodPost, "/user/create", strings.NewReader(`{"name":"user123"}`))
+	r = r.WithContext(user.WithTenant(user.WithPrincipal(r.Context(), *u), u.Tenant))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+
+	_ = store.UserGroup.AddUserToGroup(ctx, *u, *g, user.RoleMember, time.Time{})
+
+	r = httptest.NewRequest(http.MethodPost, "/user/create", strings.NewReader(`{"name":"user123"}`))
+	r = r.WithContext(user.WithTenant(user.WithPrincipal(r.Context(), *u), u.Tenant))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Error("status wrong:", w.Code)
+	}
+}
+
+func TestRouter_PrivilegedGroups(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	hd, _ := store.User.Create(ctx, user.User{Name: "helpdesk", Permissions: user.PermGroupRead | user.PermGroupWrite})
+	if err := store.Credentials.SetPassword(ctx, hd.ID, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	admins, _ := store.Group.Create(ctx, user.Group{Name: "admins", Permissions: user.PermAll})
+	staff, _ := store.Group.Create(ctx, user.Group{Name: "staff"})
+
+	for _, tc := range []struct {
+		method, url string
+		code        int
+	}{
+		{http.MethodGet, "/group/add_user?uid=" + hd.ID.String() + "&gid=" + admins.ID.String(), http.StatusForbidden},
+		{http.MethodGet, "/group/add_user?uid=" + hd.ID.String() + "&gid=" + staff.ID.String(), http.StatusOK},
+		{http.MethodPost, "/group/add_group?gid=" + staff.ID.String() + "&parent=" + admins.ID.String(), http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.SetBasicAuth("helpdesk", "correct horse")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: status wrong: %d", tc.method, tc.url, w.Code)
+		}
+	}
+
+	perms, err := store.UserGroup.EffectivePermissions(ctx, *hd)
+	if err != nil || perms != user.PermGroupRead|user.PermGroupWrite {
+		t.Errorf("wrong permissions: %v %v", perms, err)
+	}
+}
+
+func TestRouter_CreateGroupWithMembers(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "user123"})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/group/create",
+		strings.NewReader(`{"name":"group123","members":["`+u.ID.String()+`","`+uuid.New().String()+`"]}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatal("status wrong:", w.Code)
+	}
+
+	page, _ := store.Group.SearchGroups(ctx, "group123", user.Page{})
+	for _, g := range page.Groups {
+		t.Errorf("group created without members: %+v", g)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/group/create",
+		strings.NewReader(`{"name":"group123","members":["`+u.ID.String()+`"]}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+
+	ms, _ := store.UserGroup.GetUserGroupsTransitive(ctx, *u)
+	if len(ms) != 1 || ms[0].Group.Name != "group123" {
+		t.Errorf("wrong groups: %+v", ms)
+	}
+}
+
+func TestRouter_Tenants(t *testing.T) {
+	store, rt := newTestRouter(t, store.WithQuotas(user.Quotas{
+		Tenants: map[string]user.Quota{"sales": {MaxUsers: 1}},
+	}))
+
+	do := func(method, target, body, tenant, login, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.SetBasicAuth(login, password)
+		if tenant != "" {
+			r.Header.Set(TenantHeader, tenant)
+		}
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/user/create", fmt.Sprintf(`{"name":"bob","perms":%d}`, user.PermUserRead), "sales", "admin", "admin")
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	bob := User{}
+	if err := json.NewDecoder(w.Body).Decode(&bob); err != nil {
+		t.Fatal(err)
+	}
+	if bob.Tenant != "sales" {
+		t.Errorf("tenant wrong: %q", bob.Tenant)
+	}
+	if w := do(http.MethodPost, "/user/create", `{"name":"carol"}`, "sales", "admin", "admin"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	w = do(http.MethodPost, "/user/set_password?uid="+bob.ID.String(), `{"password":"correct horse"}`, "sales", "admin", "admin")
+	if w.Code != http.StatusNoContent {
+		t.Fatal("status wrong:", w.Code)
+	}
+
+	search := func(tenant, login, password string) []User {
+		t.Helper()
+		w := do(http.MethodGet, "/user/search", "", tenant, login, password)
+		if w.Code != http.StatusOK {
+			t.Fatal("status wrong:", w.Code)
+		}
+		page := UserPage{}
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Users
+	}
+	if uu := search("", "admin", "admin"); len(uu) != 1 || uu[0].Name != "admin" {
+		t.Errorf("wrong users: %+v", uu)
+	}
+	if uu := search("", "bob", "correct horse"); len(uu) != 1 || uu[0].Name != "bob" {
+		t.Errorf("wrong users: %+v", uu)
+	}
+	// в чужом арендаторе пользователя нет, оператор видит любой
+	if w := do(http.MethodGet, "/user/search", "", "other", "bob", "correct horse"); w.Code != http.StatusUnauthorized {
+		t.Error("status wrong:", w.Code)
+	}
+	if uu := search("sales", "admin", "admin"); len(uu) != 1 || uu[0].Name != "bob" {
+		t.Errorf("wrong users: %+v", uu)
+	}
+
+	ctx := user.AllTenants(context.Background())
+	admin, err := store.User.FindByLogin(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := store.Group.Create(user.WithTenant(ctx, "sales"), user.Group{Name: "managers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UserGroup.AddUserToGroup(ctx, *admin, *g, user.RoleMember, time.Time{}); !errors.Is(err, user.ErrForbidden) {
+		t.Errorf("cross-tenant membership added: %v", err)
+	}
+
+	// членство показывает арендатора пользователя и группы
+	bu, _ := store.User.Read(ctx, bob.ID)
+	if err := store.UserGroup.AddUserToGroup(ctx, *bu, *g, user.RoleMember, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	w = do(http.MethodGet, "/group/members?gid="+g.ID.String(), "", "sales", "admin", "admin")
+	var members []UserMembership
+	if err := json.NewDecoder(w.Body).Decode(&members); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Tenant != "sales" {
+		t.Errorf("wrong members: %+v", members)
+	}
+	w = do(http.MethodGet, "/user/get_groups?transitive=1&uid="+bob.ID.String(), "", "sales", "admin", "admin")
+	var groups []GroupMembership
+	if err := json.NewDecoder(w.Body).Decode(&groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Tenant != "sales" {
+		t.Errorf("wrong groups: %+v", groups)
+	}
+
+	// без арендатора в контексте хранилище отказывает, а не показывает всех
+	if _, err := store.User.Read(context.Background(), bob.ID); !errors.Is(err, user.ErrNoTenant) {
+		t.Errorf("read without tenant: %v", err)
+	}
+	// вход без заголовка ищет пользователя среди всех арендаторов
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"login":"bob","password":"correct horse"}`)))
+	if w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+}
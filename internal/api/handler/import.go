@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// maxImportBody ограничивает размер тела запроса импорта
+const maxImportBody = 32 << 20
+
+var errImportUnparsed = errors.New("import has unparsed rows")
+
+// ImportUser - строка импорта: пользователь и группы, в которые его добавить
+type ImportUser struct {
+	User
+	Groups []uuid.UUID `json:"groups"`
+}
+
+type ImportRow struct {
+	// Row - номер строки NDJSON или элемента массива, с единицы
+	Row   int        `json:"row"`
+	User  *User      `json:"user,omitempty"`
+	Error *ErrorBody `json:"error,omitempty"`
+}
+
+type ImportResponse struct {
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// /user/import?mode=atomic|best_effort
+// Тело - JSON-массив пользователей или NDJSON, по пользователю в строке.
+// В режиме atomic (по умолчанию) при любой ошибке не создаётся никто,
+// в best_effort создаются все верные строки.
+func (rt *Router) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var atomic bool
+	switch r.URL.Query().Get("mode") {
+	case "", "atomic":
+		atomic = true
+	case "best_effort":
+	default:
+		writeError(w, user.InvalidField("mode", "must be atomic or best_effort"))
+		return
+	}
+
+	defer r.Body.Close()
+
+	rows, err := readImport(io.LimitReader(r.Body, maxImportBody))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	perms, err := rt.principalPermissions(r)
+	if err != nil {
+		httpError(w, "unautorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp := ImportResponse{Rows: make([]ImportRow, len(rows))}
+	var items []user.ImportItem
+	// строки, которые не удалось разобрать, в репозиторий не попадают
+	var idx []int
+	for i, row := range rows {
+		resp.Rows[i].Row = i + 1
+		if row.err != nil {
+			_, body := errorBody(row.err)
+			resp.Rows[i].Error = &body
+			continue
+		}
+		if row.u.Permission != 0 && !perms.Has(user.PermGrant) ||
+			len(row.u.Groups) > 0 && !perms.Has(user.PermGroupWrite) {
+			httpError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		items = append(items, user.ImportItem{
+			User: user.User{
+				Name:          row.u.Name,
+				Email:         row.u.Email,
+				EmailVerified: row.u.EmailVerified,
+				State:         row.u.State,
+				Attrs:         row.u.Attrs,
+				Permissions:   row.u.Permission,
+			},
+			Groups: row.u.Groups,
+		})
+		idx = append(idx, i)
+	}
+
+	// в режиме atomic строки проверяются, даже если часть тела не разобрана,
+	// но созданное тогда откатывается
+	status := http.StatusOK
+	var res []user.ImportResult
+	if len(items) > 0 {
+		grant := perms.Has(user.PermGrant)
+		err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+			if err := rt.checkImportGroups(ctx, items, grant); err != nil {
+				return err
+			}
+			var err error
+			res, err = rt.store.User.CreateMany(ctx, items, atomic)
+			if err == nil && atomic && len(items) < len(rows) {
+				return errImportUnparsed
+			}
+			return err
+		})
+		switch {
+		case errors.Is(err, errImportUnparsed):
+			for i := range res {
+				res[i].User = nil
+			}
+			status = http.StatusBadRequest
+		case errors.Is(err, user.ErrImportRejected):
+			status = http.StatusBadRequest
+		case err != nil:
+			writeError(w, err)
+			return
+		}
+	} else if atomic {
+		status = http.StatusBadRequest
+	}
+
+	for j, ir := range res {
+		row := &resp.Rows[idx[j]]
+		if ir.Err != nil {
+			_, body := errorBody(ir.Err)
+			row.Error = &body
+		}
+		if nbu := ir.User; nbu != nil {
+			row.User = &User{
+				ID:            nbu.ID,
+				Tenant:        nbu.Tenant,
+				Name:          nbu.Name,
+				Email:         nbu.Email,
+				EmailVerified: nbu.EmailVerified,
+				State:         nbu.State,
+				Attrs:         nbu.Attrs,
+				Permission:    nbu.Permissions,
+				Version:       nbu.Version,
+				CreatedAt:     nbu.CreatedAt,
+			}
+		}
+	}
+
+	for _, row := range resp.Rows {
+		if row.User != nil {
+			resp.Created++
+		}
+		if row.Error != nil {
+			resp.Failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+type importRow struct {
+	u   ImportUser
+	err error
+}
+
+// readImport разбирает JSON-массив или NDJSON. Ошибка в строке NDJSON
+// относится только к этой строке, неверный массив отклоняется целиком.
+func readImport(r io.Reader) ([]importRow, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return nil, user.InvalidField("users", "must not be empty")
+	}
+
+	var rows []importRow
+	if first == '[' {
+		var us []ImportUser
+		if err := json.NewDecoder(br).Decode(&us); err != nil {
+			return nil, &user.Error{Code: user.CodeInvalid, Message: "bad json", Err: err}
+		}
+		for _, u := range us {
+			rows = append(rows, importRow{u: u})
+		}
+	} else {
+		sc := bufio.NewScanner(br)
+		sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+		for sc.Scan() {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			row := importRow{}
+			if err := json.Unmarshal(line, &row.u); err != nil {
+				row.err = &user.Error{Code: user.CodeInvalid, Message: "bad json", Err: err}
+			}
+			rows = append(rows, row)
+		}
+		if err := sc.Err(); err != nil {
+			return nil, &user.Error{Code: user.CodeInvalid, Message: "bad ndjson", Err: err}
+		}
+	}
+
+	if len(rows) > user.MaxImportRows {
+		return nil, user.InvalidField("users", "at most %d rows allowed", user.MaxImportRows)
+	}
+	return rows, nil
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = br.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+// checkImportGroups проверяет checkGroupGrant для групп из строк импорта.
+// Несуществующие группы пропускаются: их отклонит CreateMany в своей строке.
+func (rt *Router) checkImportGroups(ctx context.Context, items []user.ImportItem, grant bool) error {
+	if grant {
+		return nil
+	}
+	seen := make(map[uuid.UUID]bool)
+	for _, it := range items {
+		for _, gid := range it.Groups {
+			if seen[gid] {
+				continue
+			}
+			seen[gid] = true
+			g, err := rt.store.Group.Read(ctx, gid)
+			if err != nil {
+				continue
+			}
+			if err := rt.checkGroupGrant(ctx, *g, grant); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func TestRouter_ImportUsers(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	_, _ = store.Schema.Define(ctx, user.AttrDef{Name: "login", Type: user.AttrString, Unique: true})
+	g, _ := store.Group.Create(ctx, user.Group{Name: "sales"})
+
+	body := `{"name":"ann","attrs":{"login":"ann"},"groups":["` + g.ID.String() + `"]}
+{"name":"bob","attrs":{"login":"ann"}}
+not json
+{"name":"eve","groups":["` + uuid.New().String() + `"]}
+{"name":"kim"}
+`
+	for _, tc := range []struct {
+		mode            string
+		code            int
+		created, failed int
+	}{
+		{"atomic", http.StatusBadRequest, 0, 3},
+		{"best_effort", http.StatusOK, 2, 3},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/user/import?mode="+tc.mode, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Fatal(tc.mode, "status wrong:", w.Code, w.Body.String())
+		}
+		resp := ImportResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Created != tc.created || resp.Failed != tc.failed || len(resp.Rows) != 5 {
+			t.Errorf("%s: wrong result: %+v", tc.mode, resp)
+		}
+	}
+
+	ms, err := store.UserGroup.GetGroupUsersTransitive(ctx, *g)
+	if err != nil || len(ms) != 1 || ms[0].User.Name != "ann" {
+		t.Errorf("wrong members: %+v %v", ms, err)
+	}
+	// в группу с правами импортирует только тот, кто может раздавать права
+	admins, _ := store.Group.Create(ctx, user.Group{Name: "admins", Permissions: user.PermAll})
+	clerk, _ := store.User.Create(ctx, user.User{Name: "clerk", Permissions: user.PermUserWrite | user.PermGroupWrite})
+	h := rt.RequirePermissions(user.PermUserWrite, http.HandlerFunc(rt.ImportUsers))
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/user/import", strings.NewReader(`{"name":"mole","groups":["`+admins.ID.String()+`"]}`))
+	r = r.WithContext(user.WithTenant(user.WithPrincipal(r.Context(), *clerk), clerk.Tenant))
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code, w.Body.String())
+	}
+	if ms, _ := store.UserGroup.GetGroupUsersTransitive(ctx, *admins); len(ms) != 0 {
+		t.Errorf("wrong members: %+v", ms)
+	}
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// Invitation - приглашение в группу, Token заполнен только в ответе на создание
+type Invitation struct {
+	user.Invitation
+	Token string `json:"token,omitempty"`
+}
+
+type CreateInvitationRequest struct {
+	UserID    *uuid.UUID `json:"user_id"`
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type InvitationTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// canManageGroup сообщает, может ли пользователь запроса менять состав g
+// и приглашать в неё с ролью role: с правом group:write - в любую группу,
+// владелец группы - с любой ролью, менеджер - только участников
+func (rt *Router) canManageGroup(r *http.Request, g user.Group, role user.Role) bool {
+	if rt.hasPermissions(r, user.PermGroupWrite) {
+		return true
+	}
+	p, ok := user.PrincipalFromContext(r.Context())
+	if !ok {
+		return false
+	}
+	own, ok, err := rt.store.UserGroup.UserRole(r.Context(), *p, g)
+	if err != nil || !ok || !own.Manages() {
+		return false
+	}
+	return own == user.RoleOwner || role == user.RoleMember
+}
+
+// /group/invite?gid=...
+// {"user_id":"..."} или {"email":"jdoe@example.com"}, а также "role" и "expires_at"
+// Приглашать в группу с правами может только тот, кто может раздавать права.
+func (rt *Router) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	gid, err := uuid.Parse(r.URL.Query().Get("gid"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req := CreateInvitationRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	role, err := user.ParseRole(req.Role)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	g, err := rt.store.Group.Read(r.Context(), gid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !rt.canManageGroup(r, *g, role) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	grant := rt.canGrant(r)
+
+	inv := user.Invitation{
+		GroupID: gid,
+		UserID:  req.UserID,
+		Email:   req.Email,
+		Role:    role,
+	}
+	if req.ExpiresAt != nil {
+		inv.ExpiresAt = *req.ExpiresAt
+	}
+	var ninv *user.Invitation
+	var token string
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		if err := rt.checkGroupGrant(ctx, *g, grant); err != nil {
+			return err
+		}
+		var err error
+		ninv, token, err = rt.store.Invitations.Create(ctx, inv)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(Invitation{Invitation: *ninv, Token: token})
+}
+
+// /group/invites?gid=... - ждущие ответа приглашения группы
+func (rt *Router) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gid, err := uuid.Parse(r.URL.Query().Get("gid"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	g, err := rt.store.Group.Read(r.Context(), gid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !rt.canManageGroup(r, *g, user.RoleMember) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	invs, err := rt.store.Invitations.List(r.Context(), gid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := make([]Invitation, 0, len(invs))
+	for _, inv := range invs {
+		res = append(res, Invitation{Invitation: inv})
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// /group/revoke_invite?id=...
+func (rt *Router) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := uuid.Parse(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	inv, err := rt.store.Invitations.Read(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	g, err := rt.store.Group.Read(r.Context(), inv.GroupID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !rt.canManageGroup(r, *g, user.RoleMember) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if _, err := rt.store.Invitations.Revoke(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /invite/accept {"token":"..."}
+// Пользователь запроса становится участником группы с ролью из приглашения.
+func (rt *Router) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	rt.answerInvitation(w, r, true)
+}
+
+// /invite/decline {"token":"..."}
+func (rt *Router) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	rt.answerInvitation(w, r, false)
+}
+
+func (rt *Router) answerInvitation(w http.ResponseWriter, r *http.Request, accept bool) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	req := InvitationTokenRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	answer := rt.store.Invitations.Decline
+	if accept {
+		answer = rt.store.Invitations.Accept
+	}
+	inv, err := answer(r.Context(), req.Token)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(Invitation{Invitation: *inv})
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_Invitations(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	g, _ := store.Group.Create(ctx, user.Group{Name: "eng"})
+	mgr, _ := store.User.Create(ctx, user.User{Name: "mgr"})
+	bob, _ := store.User.Create(ctx, user.User{Name: "bob"})
+	eve, _ := store.User.Create(ctx, user.User{Name: "eve"})
+	for _, u := range []*user.User{mgr, bob, eve} {
+		if err := store.Credentials.SetPassword(ctx, u.ID, "correct horse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.UserGroup.AddUserToGroup(ctx, *mgr, *g, user.RoleManager, time.Time{})
+
+	do := func(method, target, body, login string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.SetBasicAuth(login, "correct horse")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	invite := func(body, login string) Invitation {
+		t.Helper()
+		w := do(http.MethodPost, "/group/invite?gid="+g.ID.String(), body, login)
+		if w.Code != http.StatusCreated {
+			t.Fatal("status wrong:", w.Code)
+		}
+		inv := Invitation{}
+		if err := json.NewDecoder(w.Body).Decode(&inv); err != nil {
+			t.Fatal(err)
+		}
+		return inv
+	}
+
+	// менеджер приглашает участников, но не владельцев; чужие не приглашают
+	if w := do(http.MethodPost, "/group/invite?gid="+g.ID.String(), `{"email":"x@example.com","role":"owner"}`, "mgr"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/group/invite?gid="+g.ID.String(), `{"email":"x@example.com"}`, "bob"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/group/invite?gid="+g.ID.String(), `{}`, "mgr"); w.Code != http.StatusBadRequest {
+		t.Error("status wrong:", w.Code)
+	}
+
+	toBob := invite(`{"user_id":"`+bob.ID.String()+`"}`, "mgr")
+	byEmail := invite(`{"email":"new@example.com"}`, "mgr")
+	if toBob.Token == "" || byEmail.Token == "" {
+		t.Fatal("no token")
+	}
+
+	w := do(http.MethodGet, "/group/invites?gid="+g.ID.String(), "", "mgr")
+	var list []Invitation
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Token != "" {
+		t.Errorf("wrong invitations: %+v", list)
+	}
+
+	// приглашение пользователю принимает только он сам и только один раз
+	if w := do(http.MethodPost, "/invite/accept", `{"token":"`+toBob.Token+`"}`, "eve"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/invite/accept", `{"token":"`+toBob.ID.String()+`.wrong"}`, "bob"); w.Code != http.StatusNotFound {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/invite/accept", `{"token":"`+toBob.Token+`"}`, "bob"); w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/invite/accept", `{"token":"`+toBob.Token+`"}`, "bob"); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+	if role, ok, _ := store.UserGroup.UserRole(ctx, *bob, *g); !ok || role != user.RoleMember {
+		t.Errorf("membership wrong: %v %s", ok, role)
+	}
+
+	// отозванное приглашение больше не действует
+	if w := do(http.MethodDelete, "/group/revoke_invite?id="+byEmail.ID.String(), "", "mgr"); w.Code != http.StatusNoContent {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/invite/decline", `{"token":"`+byEmail.Token+`"}`, "eve"); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+
+	evs, err := store.Outbox.Read(ctx, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[user.EventType]int{}
+	for _, ev := range evs {
+		got[ev.Type]++
+	}
+	if got[user.EventInvitationCreated] != 2 || got[user.EventInvitationAccepted] != 1 || got[user.EventInvitationRevoked] != 1 {
+		t.Errorf("wrong events: %v", got)
+	}
+
+	// владелец группы с правами без perm:grant в неё не приглашает
+	ops, _ := store.Group.Create(ctx, user.Group{Name: "ops", Permissions: user.PermUserWrite})
+	_ = store.UserGroup.AddUserToGroup(ctx, *mgr, *ops, user.RoleOwner, time.Time{})
+	if w := do(http.MethodPost, "/group/invite?gid="+ops.ID.String(), `{"user_id":"`+eve.ID.String()+`"}`, "mgr"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	// приглашение на почту принимает только владелец подтверждённого адреса
+	nora, _ := store.User.Create(ctx, user.User{Name: "nora", Email: "nora@example.com"})
+	if err := store.Credentials.SetPassword(ctx, nora.ID, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	toNora := invite(`{"email":" Nora@Example.com"}`, "mgr")
+	for _, login := range []string{"eve", "nora"} {
+		if w := do(http.MethodPost, "/invite/accept", `{"token":"`+toNora.Token+`"}`, login); w.Code != http.StatusForbidden {
+			t.Error(login, "status wrong:", w.Code)
+		}
+	}
+	nora.EmailVerified = true
+	if _, err := store.User.Update(ctx, *nora); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodPost, "/invite/accept", `{"token":"`+toNora.Token+`"}`, "nora"); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+
+	// если группа стала давать права, приглашение без perm:grant не принять
+	toEve := invite(`{"user_id":"`+eve.ID.String()+`"}`, "mgr")
+	if _, err := store.Group.Grant(ctx, g.ID, user.PermUserWrite); err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodPost, "/invite/accept", `{"token":"`+toEve.Token+`"}`, "eve"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if _, ok, _ := store.UserGroup.UserRole(ctx, *eve, *g); ok {
+		t.Error("eve joined the group")
+	}
+}
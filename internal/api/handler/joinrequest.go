@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+type JoinGroupRequest struct {
+	Justification string `json:"justification"`
+}
+
+// /group/join?gid=... {"justification":"..."}
+// В открытую группу пользователь запроса вступает сразу, но в открытую
+// группу с правами - только если сам может раздавать права.
+func (rt *Router) JoinGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	gid, err := uuid.Parse(r.URL.Query().Get("gid"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req := JoinGroupRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	grant := rt.canGrant(r)
+	var jr *user.JoinRequest
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		g, err := rt.store.Group.Read(ctx, gid)
+		if err != nil {
+			return err
+		}
+		if g.JoinPolicy == user.JoinOpen {
+			if err := rt.checkGroupGrant(ctx, *g, grant); err != nil {
+				return err
+			}
+		}
+		jr, err = rt.store.JoinRequests.Submit(ctx, gid, req.Justification)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(jr)
+}
+
+// /group/requests?gid=... - ждущие решения заявки в группу,
+// без gid - во все группы, которыми управляет пользователь запроса
+func (rt *Router) ListJoinRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var gids []uuid.UUID
+	if sgid := r.URL.Query().Get("gid"); sgid != "" {
+		gid, err := uuid.Parse(sgid)
+		if err != nil {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		g, err := rt.store.Group.Read(r.Context(), gid)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !rt.canManageGroup(r, *g, user.RoleMember) {
+			httpError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		gids = append(gids, gid)
+	} else {
+		p, ok := user.PrincipalFromContext(r.Context())
+		if !ok {
+			httpError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var err error
+		gids, err = rt.store.JoinRequests.Managed(r.Context(), *p)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	jrs := []user.JoinRequest{}
+	if len(gids) > 0 {
+		var err error
+		jrs, err = rt.store.JoinRequests.Pending(r.Context(), gids)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	_ = json.NewEncoder(w).Encode(jrs)
+}
+
+// /group/approve_request?id=...
+// Заявителя добавляет одобрение, которым набирается нужное группе число.
+func (rt *Router) ApproveJoinRequest(w http.ResponseWriter, r *http.Request) {
+	rt.decideJoinRequest(w, r, true)
+}
+
+// /group/reject_request?id=...
+func (rt *Router) RejectJoinRequest(w http.ResponseWriter, r *http.Request) {
+	rt.decideJoinRequest(w, r, false)
+}
+
+// decideJoinRequest: одобрять заявки в группу с правами может только тот,
+// кто может раздавать права, отклонять - любой управляющий группой
+func (rt *Router) decideJoinRequest(w http.ResponseWriter, r *http.Request, approve bool) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := uuid.Parse(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	jr, err := rt.store.JoinRequests.Read(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	g, err := rt.store.Group.Read(r.Context(), jr.GroupID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !rt.canManageGroup(r, *g, user.RoleMember) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	grant := rt.canGrant(r)
+
+	decide := rt.store.JoinRequests.Reject
+	if approve {
+		decide = rt.store.JoinRequests.Approve
+	}
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		if approve {
+			if err := rt.checkGroupGrant(ctx, *g, grant); err != nil {
+				return err
+			}
+		}
+		var err error
+		jr, err = decide(ctx, id)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(jr)
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_JoinRequests(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	closed, _ := store.Group.Create(ctx, user.Group{Name: "closed"})
+	open, _ := store.Group.Create(ctx, user.Group{Name: "open", JoinPolicy: user.JoinOpen})
+	priv, _ := store.Group.Create(ctx, user.Group{Name: "priv", JoinPolicy: user.JoinApproval, Approvals: 2})
+	owner, _ := store.User.Create(ctx, user.User{Name: "owner"})
+	mgr, _ := store.User.Create(ctx, user.User{Name: "mgr"})
+	bob, _ := store.User.Create(ctx, user.User{Name: "bob"})
+	eve, _ := store.User.Create(ctx, user.User{Name: "eve"})
+	for _, u := range []*user.User{owner, mgr, bob, eve} {
+		if err := store.Credentials.SetPassword(ctx, u.ID, "correct horse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.UserGroup.AddUserToGroup(ctx, *owner, *priv, user.RoleOwner, time.Time{})
+	_ = store.UserGroup.AddUserToGroup(ctx, *mgr, *priv, user.RoleManager, time.Time{})
+
+	do := func(method, target, body, login string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.SetBasicAuth(login, "correct horse")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) user.JoinRequest {
+		t.Helper()
+		jr := user.JoinRequest{}
+		if err := json.NewDecoder(w.Body).Decode(&jr); err != nil {
+			t.Fatal(err)
+		}
+		return jr
+	}
+
+	// в закрытую группу не вступить, в открытую - сразу
+	if w := do(http.MethodPost, "/group/join?gid="+closed.ID.String(), `{}`, "bob"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	w := do(http.MethodPost, "/group/join?gid="+open.ID.String(), `{}`, "bob")
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if jr := decode(w); jr.Status != user.RequestApproved {
+		t.Errorf("status wrong: %s", jr.Status)
+	}
+	if _, ok, _ := store.UserGroup.UserRole(ctx, *bob, *open); !ok {
+		t.Error("bob is not a member of open")
+	}
+
+	// группа с двумя одобряющими: первое одобрение заявку не закрывает
+	w = do(http.MethodPost, "/group/join?gid="+priv.ID.String(), `{"justification":"on call"}`, "bob")
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	jr := decode(w)
+	if w := do(http.MethodPost, "/group/join?gid="+priv.ID.String(), `{}`, "bob"); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+	w = do(http.MethodGet, "/group/requests", "", "mgr")
+	var list []user.JoinRequest
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != jr.ID || list[0].Justification != "on call" {
+		t.Errorf("wrong requests: %+v", list)
+	}
+	if w := do(http.MethodPost, "/group/approve_request?id="+jr.ID.String(), "", "eve"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/group/approve_request?id="+jr.ID.String(), "", "mgr"); w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/group/approve_request?id="+jr.ID.String(), "", "mgr"); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+	if _, ok, _ := store.UserGroup.UserRole(ctx, *bob, *priv); ok {
+		t.Error("bob joined priv after one approval")
+	}
+	w = do(http.MethodPost, "/group/approve_request?id="+jr.ID.String(), "", "owner")
+	if w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if jr := decode(w); jr.Status != user.RequestApproved || len(jr.Approvals) != 2 {
+		t.Errorf("wrong request: %+v", jr)
+	}
+	if role, ok, _ := store.UserGroup.UserRole(ctx, *bob, *priv); !ok || role != user.RoleMember {
+		t.Errorf("membership wrong: %v %s", ok, role)
+	}
+
+	// свою заявку не одобрить даже с правом group:write, отклоняет другой
+	w = do(http.MethodPost, "/group/join?gid="+priv.ID.String(), `{}`, "eve")
+	jr = decode(w)
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/group/join?gid="+priv.ID.String(), strings.NewReader(`{}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	own := decode(w)
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/group/approve_request?id="+own.ID.String(), nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/group/reject_request?id="+jr.ID.String(), "", "mgr"); w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/group/approve_request?id="+jr.ID.String(), "", "owner"); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+
+	// в группу с правами не вступить без одобрения, а одобрить заявку
+	// может только тот, кто может раздавать права
+	admins, _ := store.Group.Create(ctx, user.Group{Name: "admins", JoinPolicy: user.JoinOpen, Permissions: user.PermAll})
+	if w := do(http.MethodPost, "/group/join?gid="+admins.ID.String(), `{}`, "eve"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	ops, _ := store.Group.Create(ctx, user.Group{Name: "ops", JoinPolicy: user.JoinApproval, Approvals: 1, Permissions: user.PermUserWrite})
+	_ = store.UserGroup.AddUserToGroup(ctx, *owner, *ops, user.RoleOwner, time.Time{})
+	w = do(http.MethodPost, "/group/join?gid="+ops.ID.String(), `{}`, "eve")
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	req := decode(w)
+	if w := do(http.MethodPost, "/group/approve_request?id="+req.ID.String(), "", "owner"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/group/approve_request?id="+req.ID.String(), nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+}
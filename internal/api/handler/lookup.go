@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// /user/by_name?name=jdoe
+// Имя сравнивается без учёта регистра. Без арендатора запроса имя может
+// быть в нескольких арендаторах, тогда ответ 409.
+func (rt *Router) ReadUserByName(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	nbu, err := rt.store.User.FindByName(r.Context(), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		User{
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
+		},
+	)
+}
+
+// /user/by_email?email=jdoe@example.com
+func (rt *Router) ReadUserByEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	if email == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	nbu, err := rt.store.User.FindByEmail(r.Context(), email)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		User{
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
+		},
+	)
+}
+
+// /group/by_name?name=Sales
+func (rt *Router) ReadGroupByName(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ngu, err := rt.store.Group.FindByName(r.Context(), name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		Group{
+			ID:         ngu.ID,
+			Tenant:     ngu.Tenant,
+			Name:       ngu.Name,
+			JoinPolicy: ngu.JoinPolicy,
+			Approvals:  ngu.Approvals,
+			Rule:       ngu.Rule,
+			Permission: ngu.Permissions,
+			Version:    ngu.Version,
+			CreatedAt:  ngu.CreatedAt,
+		},
+	)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter_UniqueNames(t *testing.T) {
+	_, rt := newTestRouter(t)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/user/create", `{"name":" JDoe ","email":"JDoe@Example.com","email_verified":true}`)
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	jdoe := User{}
+	if err := json.NewDecoder(w.Body).Decode(&jdoe); err != nil {
+		t.Fatal(err)
+	}
+	if jdoe.Name != "jdoe" || jdoe.Email != "jdoe@example.com" || !jdoe.EmailVerified {
+		t.Errorf("wrong user: %+v", jdoe)
+	}
+
+	for _, body := range []string{`{"name":"jdoe"}`, `{"name":"JDOE"}`, `{"name":"other","email":"jdoe@EXAMPLE.com"}`} {
+		if w := do(http.MethodPost, "/user/create", body); w.Code != http.StatusConflict {
+			t.Errorf("%s: status wrong: %d", body, w.Code)
+		}
+	}
+	for _, body := range []string{`{"name":""}`, `{"name":"x","email":"not an address"}`} {
+		if w := do(http.MethodPost, "/user/create", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status wrong: %d", body, w.Code)
+		}
+	}
+
+	for _, target := range []string{"/user/by_name?name=JDOE", "/user/by_email?email=jdoe@example.com"} {
+		w := do(http.MethodGet, target, "")
+		got := User{}
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || got.ID != jdoe.ID {
+			t.Errorf("%s: wrong user: %d %+v", target, w.Code, got)
+		}
+	}
+	if w := do(http.MethodGet, "/user/by_name?name=nobody", ""); w.Code != http.StatusNotFound {
+		t.Error("status wrong:", w.Code)
+	}
+
+	// смена адреса сбрасывает подтверждение, старый адрес освобождается
+	w = do(http.MethodPatch, "/user/update?uid="+jdoe.ID.String(), `{"version":1,"email":"john@example.com"}`)
+	if w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	jdoe = User{}
+	if err := json.NewDecoder(w.Body).Decode(&jdoe); err != nil {
+		t.Fatal(err)
+	}
+	if jdoe.EmailVerified {
+		t.Error("email still verified")
+	}
+	if w := do(http.MethodPost, "/user/create", `{"name":"jane","email":"jdoe@example.com"}`); w.Code != http.StatusCreated {
+		t.Error("status wrong:", w.Code)
+	}
+
+	// имя удалённого пользователя свободно, но восстановить его после этого нельзя
+	if w := do(http.MethodDelete, "/user/delete?uid="+jdoe.ID.String(), ""); w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/user/create", `{"name":"jdoe"}`); w.Code != http.StatusCreated {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/user/restore?uid="+jdoe.ID.String(), ""); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+
+	if w := do(http.MethodPost, "/group/create", `{"name":"Sales"}`); w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/group/create", `{"name":"sales "}`); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+	w = do(http.MethodGet, "/group/by_name?name=SALES", "")
+	g := Group{}
+	if err := json.NewDecoder(w.Body).Decode(&g); err != nil {
+		t.Fatal(err)
+	}
+	if g.Name != "Sales" {
+		t.Errorf("wrong group: %+v", g)
+	}
+	// в другом арендаторе имя свободно
+	r := httptest.NewRequest(http.MethodPost, "/group/create", strings.NewReader(`{"name":"sales"}`))
+	r.SetBasicAuth("admin", "admin")
+	r.Header.Set(TenantHeader, "emea")
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Error("status wrong:", w.Code)
+	}
+}
@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// GroupMembership - группа пользователя и путь через вложенные группы
+type GroupMembership struct {
+	Group
+	Role      user.Role  `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Path      []Group    `json:"path"`
+}
+
+// UserMembership - участник группы и путь через вложенные группы
+type UserMembership struct {
+	User
+	Role      user.Role  `json:"role"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Path      []Group    `json:"path"`
+}
+
+func groupMembership(m user.GroupMembership) GroupMembership {
+	return GroupMembership{
+		Group: Group{
+			ID:         m.Group.ID,
+			Tenant:     m.Group.Tenant,
+			Name:       m.Group.Name,
+			JoinPolicy: m.Group.JoinPolicy,
+			Approvals:  m.Group.Approvals,
+			Rule:       m.Group.Rule,
+			Permission: m.Group.Permissions,
+			Version:    m.Group.Version,
+			CreatedAt:  m.Group.CreatedAt,
+		},
+		Role:      m.Role,
+		ExpiresAt: expiresField(m.ExpiresAt),
+		Path:      groupPath(m.Path),
+	}
+}
+
+func userMembership(m user.UserMembership) UserMembership {
+	return UserMembership{
+		User: User{
+			ID:            m.User.ID,
+			Tenant:        m.User.Tenant,
+			Name:          m.User.Name,
+			Email:         m.User.Email,
+			EmailVerified: m.User.EmailVerified,
+			State:         m.User.State,
+			Attrs:         m.User.Attrs,
+			Permission:    m.User.Permissions,
+			Version:       m.User.Version,
+			CreatedAt:     m.User.CreatedAt,
+		},
+		Role:      m.Role,
+		ExpiresAt: expiresField(m.ExpiresAt),
+		Path:      groupPath(m.Path),
+	}
+}
+
+func expiresField(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// expiresParam разбирает необязательный expires_at в RFC 3339
+func expiresParam(r *http.Request) (time.Time, error) {
+	s := r.URL.Query().Get("expires_at")
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, user.InvalidField("expires_at", "must be RFC 3339 time")
+	}
+	return t, nil
+}
+
+func groupPath(gs []user.Group) []Group {
+	path := make([]Group, 0, len(gs))
+	for _, g := range gs {
+		path = append(path, Group{
+			ID:         g.ID,
+			Tenant:     g.Tenant,
+			Name:       g.Name,
+			JoinPolicy: g.JoinPolicy,
+			Approvals:  g.Approvals,
+			Rule:       g.Rule,
+			Permission: g.Permissions,
+			Version:    g.Version,
+			CreatedAt:  g.CreatedAt,
+		})
+	}
+	return path
+}
+
+// members?gid=...[&transitive=1][&all=1]
+// с transitive=1 возвращает и участников вложенных групп,
+// с all=1 - и приостановленных и выключенных пользователей
+func (rt *Router) GroupMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sgid := r.URL.Query().Get("gid")
+	if sgid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	gid, err := uuid.Parse(sgid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (gid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	group, err := rt.store.Group.Read(r.Context(), gid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var ms []user.UserMembership
+	if r.URL.Query().Get("transitive") == "1" {
+		ms, err = rt.store.UserGroup.GetGroupUsersTransitive(r.Context(), *group)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	} else {
+		ch, err := rt.store.UserGroup.GetGroupUsers(r.Context(), *group)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for m := range ch {
+			ms = append(ms, m)
+		}
+	}
+
+	all := r.URL.Query().Get("all") == "1"
+	res := make([]UserMembership, 0, len(ms))
+	for _, m := range ms {
+		if all || m.User.State.Listed() {
+			res = append(res, userMembership(m))
+		}
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// set_role?gid=...&uid=...&role=owner|manager|member
+func (rt *Router) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids := make([]uuid.UUID, 2)
+	for i, name := range []string{"uid", "gid"} {
+		id, err := uuid.Parse(r.URL.Query().Get(name))
+		if err != nil || (id == uuid.UUID{}) {
+			writeError(w, user.InvalidField(name, "must be a uuid"))
+			return
+		}
+		ids[i] = id
+	}
+	srole := r.URL.Query().Get("role")
+	if srole == "" {
+		writeError(w, user.InvalidField("role", "is required"))
+		return
+	}
+	role, err := user.ParseRole(srole)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	grant := rt.canGrant(r)
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		u, err := rt.store.User.Read(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		g, err := rt.store.Group.Read(ctx, ids[1])
+		if err != nil {
+			return err
+		}
+		if err := rt.checkGroupGrant(ctx, *g, grant); err != nil {
+			return err
+		}
+		return rt.store.UserGroup.SetUserRole(ctx, *u, *g, role)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, `{"status":"ok"}`)
+}
+
+// extend?gid=...&uid=...[&expires_at=...]
+// переносит окончание членства, без expires_at делает его бессрочным
+func (rt *Router) ExtendMembership(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids := make([]uuid.UUID, 2)
+	for i, name := range []string{"uid", "gid"} {
+		id, err := uuid.Parse(r.URL.Query().Get(name))
+		if err != nil || (id == uuid.UUID{}) {
+			writeError(w, user.InvalidField(name, "must be a uuid"))
+			return
+		}
+		ids[i] = id
+	}
+	expiresAt, err := expiresParam(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	grant := rt.canGrant(r)
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		u, err := rt.store.User.Read(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		g, err := rt.store.Group.Read(ctx, ids[1])
+		if err != nil {
+			return err
+		}
+		if err := rt.checkGroupGrant(ctx, *g, grant); err != nil {
+			return err
+		}
+		return rt.store.UserGroup.ExtendMembership(ctx, *u, *g, expiresAt)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, `{"status":"ok"}`)
+}
+
+// add_group?gid=...&parent=...
+// делает группу gid участником группы parent, участники gid получают
+// права parent
+func (rt *Router) AddGroupToGroup(w http.ResponseWriter, r *http.Request) {
+	grant := rt.canGrant(r)
+	rt.changeGroupParent(w, r, func(ctx context.Context, child, parent user.Group) error {
+		return rt.store.InTx(ctx, func(ctx context.Context) error {
+			if err := rt.checkGroupGrant(ctx, parent, grant); err != nil {
+				return err
+			}
+			return rt.store.UserGroup.AddGroupToGroup(ctx, child, parent)
+		})
+	})
+}
+
+// delete_group?gid=...&parent=...
+func (rt *Router) DeleteGroupFromGroup(w http.ResponseWriter, r *http.Request) {
+	rt.changeGroupParent(w, r, rt.store.UserGroup.DeleteGroupFromGroup)
+}
+
+func (rt *Router) changeGroupParent(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, child, parent user.Group) error) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ids := make([]uuid.UUID, 2)
+	for i, name := range []string{"gid", "parent"} {
+		s := r.URL.Query().Get(name)
+		if s == "" {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		id, err := uuid.Parse(s)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if (id == uuid.UUID{}) {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		ids[i] = id
+	}
+
+	groups := make([]*user.Group, 2)
+	for i, id := range ids {
+		g, err := rt.store.Group.Read(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		groups[i] = g
+	}
+
+	err := change(r.Context(), *groups[0], *groups[1])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, `{"status":"ok"}`)
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/audit"
+	"gb-backend2/internal/app/repos/user"
+	"gb-backend2/internal/app/store"
+
+	"github.com/google/uuid"
+)
+
+func TestRouter_NestedGroups(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "user123"})
+	dev, _ := store.Group.Create(ctx, user.Group{Name: "dev"})
+	eng, _ := store.Group.Create(ctx, user.Group{Name: "eng", Permissions: user.PermGroupRead})
+	_ = store.UserGroup.AddUserToGroup(ctx, *u, *dev, user.RoleMember, time.Time{})
+
+	for _, tc := range []struct {
+		child, parent uuid.UUID
+		code          int
+	}{
+		{dev.ID, eng.ID, http.StatusOK},
+		{eng.ID, dev.ID, http.StatusConflict},
+		{eng.ID, eng.ID, http.StatusConflict},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/group/add_group?gid="+tc.child.String()+"&parent="+tc.parent.String(), nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("add %s to %s: status wrong: %d", tc.child, tc.parent, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/group/members?transitive=1&gid="+eng.ID.String(), nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+
+	ms := []UserMembership{}
+	if err := json.NewDecoder(w.Body).Decode(&ms); err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != 1 || ms[0].ID != u.ID || len(ms[0].Path) != 2 || ms[0].Path[0].ID != dev.ID {
+		t.Errorf("wrong members: %+v", ms)
+	}
+
+	perms, err := store.UserGroup.EffectivePermissions(ctx, *u)
+	if err != nil || perms != user.PermGroupRead {
+		t.Errorf("wrong permissions: %v %v", perms, err)
+	}
+}
+
+func TestRouter_MembershipRoles(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "user123"})
+	g, _ := store.Group.Create(ctx, user.Group{Name: "group123"})
+
+	for _, tc := range []struct {
+		method, url string
+		code        int
+	}{
+		{http.MethodGet, "/group/add_user?uid=" + u.ID.String() + "&gid=" + g.ID.String() + "&role=boss", http.StatusBadRequest},
+		{http.MethodGet, "/group/add_user?uid=" + u.ID.String() + "&gid=" + g.ID.String() + "&role=manager", http.StatusOK},
+		{http.MethodPost, "/group/set_role?uid=" + u.ID.String() + "&gid=" + g.ID.String() + "&role=owner", http.StatusOK},
+		{http.MethodPost, "/group/set_role?uid=" + uuid.New().String() + "&gid=" + g.ID.String() + "&role=owner", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: status wrong: %d", tc.method, tc.url, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/user/get_groups?uid="+u.ID.String(), nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	gs := []GroupMembership{}
+	if err := json.NewDecoder(w.Body).Decode(&gs); err != nil {
+		t.Fatal(err)
+	}
+	if len(gs) != 1 || gs[0].ID != g.ID || gs[0].Role != user.RoleOwner {
+		t.Errorf("wrong groups: %+v", gs)
+	}
+}
+
+func TestRouter_ExpiringMembership(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	store, rt := newTestRouter(t, store.WithClock(func() time.Time { return now }))
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "user123"})
+	g, _ := store.Group.Create(ctx, user.Group{Name: "group123"})
+	ids := "uid=" + u.ID.String() + "&gid=" + g.ID.String()
+	soon := now.Add(30 * time.Minute).Format(time.RFC3339)
+
+	for _, tc := range []struct {
+		method, url string
+		code        int
+	}{
+		{http.MethodGet, "/group/add_user?" + ids + "&expires_at=2001-01-01T00:00:00Z", http.StatusBadRequest},
+		{http.MethodGet, "/group/add_user?" + ids + "&expires_at=" + now.Add(time.Hour).Format(time.RFC3339), http.StatusOK},
+		{http.MethodPost, "/group/extend?" + ids + "&expires_at=" + soon, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: status wrong: %d %s", tc.method, tc.url, w.Code, w.Body.String())
+		}
+	}
+
+	ms, _ := store.UserGroup.GetUserGroupsTransitive(ctx, *u)
+	if len(ms) != 1 || ms[0].ExpiresAt.IsZero() {
+		t.Fatalf("wrong groups: %+v", ms)
+	}
+
+	now = now.Add(30 * time.Minute)
+	ms, _ = store.UserGroup.GetUserGroupsTransitive(ctx, *u)
+	if len(ms) != 0 {
+		t.Errorf("expired membership visible: %+v", ms)
+	}
+	if n, err := store.UserGroup.Expire(ctx, store.UserGroup.Now()); n != 1 || err != nil {
+		t.Errorf("expire: %d %v", n, err)
+	}
+	es, _ := store.Audit.Search(ctx, audit.Filter{Action: string(user.EventMembershipRemoved)})
+	if len(es) != 1 || es[0].UserID != u.ID {
+		t.Errorf("no removal event: %+v", es)
+	}
+
+	// членство в группе с правами продлевает и меняет только тот,
+	// кто может раздавать права
+	admins, _ := store.Group.Create(ctx, user.Group{Name: "admins", Permissions: user.PermAll})
+	writer, _ := store.User.Create(ctx, user.User{Name: "writer", Permissions: user.PermGroupWrite})
+	mole, _ := store.User.Create(ctx, user.User{Name: "mole"})
+	_ = store.UserGroup.AddUserToGroup(ctx, *mole, *admins, user.RoleMember, now.Add(time.Hour))
+	ids = "uid=" + mole.ID.String() + "&gid=" + admins.ID.String()
+	for _, tc := range []struct {
+		url string
+		h   http.HandlerFunc
+	}{
+		{"/group/extend?" + ids, rt.ExtendMembership},
+		{"/group/set_role?" + ids + "&role=owner", rt.SetUserRole},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, tc.url, nil)
+		r = r.WithContext(user.WithTenant(user.WithPrincipal(r.Context(), *writer), writer.Tenant))
+		rt.RequirePermissions(user.PermGroupWrite, tc.h).ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status wrong: %d %s", tc.url, w.Code, w.Body.String())
+		}
+	}
+	ms, _ = store.UserGroup.GetUserGroupsTransitive(ctx, *mole)
+	if len(ms) != 1 || ms[0].ExpiresAt.IsZero() || ms[0].Role != user.RoleMember {
+		t.Errorf("wrong groups: %+v", ms)
+	}
+}
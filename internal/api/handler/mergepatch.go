@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+var errPatchVersion = user.InvalidField("version", "is required")
+
+// mergePatch применяет patch к target по правилам RFC 7386
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	// типизированный nil (атрибуты пользователя без атрибутов)
+	// тоже проходит проверку типа, но писать в него нельзя
+	t, ok := target.(map[string]interface{})
+	if !ok || t == nil {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// patchDoc разбирает merge patch и возвращает его вместе с версией,
+// которую клиент считает текущей
+func patchDoc(body []byte) (map[string]interface{}, int, error) {
+	patch := make(map[string]interface{})
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return nil, 0, &user.Error{Code: user.CodeInvalid, Message: "bad json", Err: err}
+	}
+	v, ok := patch["version"].(float64)
+	if !ok {
+		return nil, 0, errPatchVersion
+	}
+	delete(patch, "version")
+	return patch, int(v), nil
+}
+
+// patchUser применяет merge patch к u, патч к attrs сливается
+// с атрибутами пользователя
+func patchUser(u *user.User, body []byte) error {
+	patch, version, err := patchDoc(body)
+	if err != nil {
+		return err
+	}
+
+	doc := mergePatch(map[string]interface{}{
+		"name":           u.Name,
+		"email":          u.Email,
+		"email_verified": u.EmailVerified,
+		"attrs":          map[string]interface{}(u.Attrs.Clone()),
+	}, patch).(map[string]interface{})
+
+	name, ok := doc["name"].(string)
+	if !ok {
+		return user.InvalidField("name", "must be a string")
+	}
+	// удалённая из документа почта - пустой адрес
+	email, ok := doc["email"].(string)
+	if _, set := doc["email"]; set && !ok {
+		return user.InvalidField("email", "must be a string")
+	}
+	verified, ok := doc["email_verified"].(bool)
+	if _, set := doc["email_verified"]; set && !ok {
+		return user.InvalidField("email_verified", "must be a boolean")
+	}
+
+	switch attrs := doc["attrs"].(type) {
+	case nil:
+		u.Attrs = nil
+	case map[string]interface{}:
+		u.Attrs = attrs
+	default:
+		return user.InvalidField("attrs", "must be an object")
+	}
+	u.Name = name
+	u.Email = email
+	u.EmailVerified = verified
+	u.Version = version
+	return nil
+}
+
+func patchGroup(g *user.Group, body []byte) error {
+	patch, version, err := patchDoc(body)
+	if err != nil {
+		return err
+	}
+
+	doc := mergePatch(map[string]interface{}{
+		"name":        g.Name,
+		"join_policy": string(g.JoinPolicy),
+		"approvals":   float64(g.Approvals),
+		"rule":        g.Rule,
+	}, patch).(map[string]interface{})
+
+	name, ok := doc["name"].(string)
+	if !ok {
+		return user.InvalidField("name", "must be a string")
+	}
+	// удалённые из документа настройки возвращаются к значениям по умолчанию
+	policy, ok := doc["join_policy"].(string)
+	if _, set := doc["join_policy"]; set && !ok {
+		return user.InvalidField("join_policy", "must be a string")
+	}
+	approvals, ok := doc["approvals"].(float64)
+	if _, set := doc["approvals"]; set && !ok {
+		return user.InvalidField("approvals", "must be a number")
+	}
+	rule, ok := doc["rule"].(string)
+	if _, set := doc["rule"]; set && !ok {
+		return user.InvalidField("rule", "must be a string")
+	}
+	g.Name = name
+	g.JoinPolicy = user.JoinPolicy(policy)
+	g.Approvals = int(approvals)
+	g.Rule = rule
+	g.Version = version
+	return nil
+}
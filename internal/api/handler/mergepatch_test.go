@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_PatchUserWithoutAttrs(t *testing.T) {
+	store, rt := newTestRouter(t)
+
+	ctx := user.AllTenants(context.Background())
+	_, _ = store.Schema.Define(ctx, user.AttrDef{Name: "department", Type: user.AttrString})
+	u, err := store.User.Create(ctx, user.User{Name: "user123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/user/update?uid="+u.ID.String(),
+		strings.NewReader(`{"version":1,"attrs":{"department":"root"}}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	nu := User{}
+	if err := json.NewDecoder(w.Body).Decode(&nu); err != nil {
+		t.Fatal(err)
+	}
+	if nu.Attrs["department"] != "root" {
+		t.Errorf("wrong user: %+v", nu)
+	}
+}
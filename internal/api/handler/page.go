@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+type UserPage struct {
+	Users   []User `json:"users"`
+	Next    string `json:"next,omitempty"`
+	HasMore bool   `json:"has_more"`
+}
+
+type GroupPage struct {
+	Groups  []Group `json:"groups"`
+	Next    string  `json:"next,omitempty"`
+	HasMore bool    `json:"has_more"`
+}
+
+// pageParams разбирает sort, limit и cursor из строки запроса.
+// sort - name, created или id, с минусом - по убыванию.
+func pageParams(r *http.Request) (user.Page, error) {
+	q := r.URL.Query()
+
+	sort, desc, err := user.ParseSort(q.Get("sort"))
+	if err != nil {
+		return user.Page{}, err
+	}
+	p := user.Page{
+		Sort:   sort,
+		Desc:   desc,
+		Cursor: q.Get("cursor"),
+	}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return p, user.InvalidField("limit", "must be a positive number")
+		}
+		p.Limit = n
+	}
+	return p, nil
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_SearchUserPages(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	for _, name := range []string{"user3", "user1", "user4", "user2", "user5"} {
+		_, _ = store.User.Create(ctx, user.User{Name: name})
+	}
+
+	var names []string
+	cursor := ""
+	for i := 0; ; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/user/search?q=user&sort=-name&limit=2&cursor="+cursor, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatal("status wrong:", w.Code, w.Body.String())
+		}
+		page := UserPage{}
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range page.Users {
+			names = append(names, u.Name)
+		}
+		if !page.HasMore {
+			break
+		}
+		if i > 3 {
+			t.Fatal("too many pages")
+		}
+		cursor = page.Next
+	}
+	if strings.Join(names, ",") != "user5,user4,user3,user2,user1" {
+		t.Errorf("wrong order: %v", names)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/user/search?sort=id&cursor="+cursor, nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Error("cursor of another sort accepted:", w.Code)
+	}
+}
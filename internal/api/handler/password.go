@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// SetPasswordRequest - новый пароль, задаваемый администратором
+type SetPasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// ChangePasswordRequest - смена пароля самим пользователем
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// /user/set_password?uid=...
+// {"password":"..."}
+// Без perm:grant можно сбросить пароль только пользователю, у которого нет
+// прав сверх прав вызывающего. Сеансы пользователя отзываются.
+func (rt *Router) SetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	uid, err := uuid.Parse(r.URL.Query().Get("uid"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req := SetPasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	perms, err := rt.principalPermissions(r)
+	if err != nil {
+		httpError(w, "unautorized", http.StatusUnauthorized)
+		return
+	}
+	err = rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		if !perms.Has(user.PermGrant) {
+			u, err := rt.store.User.Read(ctx, uid)
+			if err != nil {
+				return err
+			}
+			target, err := rt.store.UserGroup.EffectivePermissions(ctx, *u)
+			if err != nil {
+				return err
+			}
+			if !perms.Has(target) {
+				return user.Forbidden("user %s has %s, resetting the password requires %s", uid, target&^perms, user.PermGrant)
+			}
+		}
+		if err := rt.store.Credentials.SetPassword(ctx, uid, req.Password); err != nil {
+			return err
+		}
+		_, err := rt.store.Tokens.RevokeUser(ctx, uid)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// /user/change_password - пароль меняет пользователь из запроса,
+// все его сеансы, включая текущий, отзываются
+// {"old_password":"...","new_password":"..."}
+func (rt *Router) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	p, ok := user.PrincipalFromContext(r.Context())
+	if !ok {
+		httpError(w, "unautorized", http.StatusUnauthorized)
+		return
+	}
+	req := ChangePasswordRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	err := rt.store.InTx(r.Context(), func(ctx context.Context) error {
+		err := rt.store.Credentials.ChangePassword(ctx, p.ID, req.OldPassword, req.NewPassword)
+		if err != nil {
+			return err
+		}
+		_, err = rt.store.Tokens.RevokeUser(ctx, p.ID)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
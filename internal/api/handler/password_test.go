@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_Passwords(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "alice", Permissions: user.PermUserRead})
+	read := "/user/read?uid=" + u.ID.String()
+
+	do := func(method, target, body, login, password string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.SetBasicAuth(login, password)
+		rt.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do(http.MethodPost, "/user/set_password?uid="+u.ID.String(), `{"password":"short"}`, "admin", "admin"); code != http.StatusBadRequest {
+		t.Error("status wrong:", code)
+	}
+	if code := do(http.MethodPost, "/user/set_password?uid="+u.ID.String(), `{"password":"correct horse"}`, "admin", "admin"); code != http.StatusNoContent {
+		t.Fatal("status wrong:", code)
+	}
+	if code := do(http.MethodGet, read, "", "alice", "correct horse"); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+	if code := do(http.MethodGet, read, "", "alice", "wrong horse"); code != http.StatusUnauthorized {
+		t.Error("status wrong:", code)
+	}
+	if code := do(http.MethodGet, read, "", "nobody", "correct horse"); code != http.StatusUnauthorized {
+		t.Error("status wrong:", code)
+	}
+
+	body := `{"old_password":"wrong horse","new_password":"battery staple"}`
+	if code := do(http.MethodPost, "/user/change_password", body, "alice", "correct horse"); code != http.StatusUnauthorized {
+		t.Error("status wrong:", code)
+	}
+	body = `{"old_password":"correct horse","new_password":"battery staple"}`
+	if code := do(http.MethodPost, "/user/change_password", body, u.ID.String(), "correct horse"); code != http.StatusNoContent {
+		t.Fatal("status wrong:", code)
+	}
+	if code := do(http.MethodGet, read, "", "alice", "correct horse"); code != http.StatusUnauthorized {
+		t.Error("status wrong:", code)
+	}
+	if code := do(http.MethodGet, read, "", "alice", "battery staple"); code != http.StatusOK {
+		t.Error("status wrong:", code)
+	}
+
+	// user:write не даёт сбросить пароль пользователю с большими правами
+	helpdesk, _ := store.User.Create(ctx, user.User{Name: "helpdesk", Permissions: user.PermUserRead | user.PermUserWrite})
+	if err := store.Credentials.SetPassword(ctx, helpdesk.ID, "help me please"); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := store.User.FindByName(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := do(http.MethodPost, "/user/set_password?uid="+admin.ID.String(), `{"password":"taken over"}`, "helpdesk", "help me please"); code != http.StatusForbidden {
+		t.Error("status wrong:", code)
+	}
+	if code := do(http.MethodPost, "/user/set_password?uid="+u.ID.String(), `{"password":"correct horse"}`, "helpdesk", "help me please"); code != http.StatusNoContent {
+		t.Error("status wrong:", code)
+	}
+
+	// сброс пароля отзывает сеансы пользователя
+	tr, err := store.Tokens.Login(ctx, "alice", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := do(http.MethodPost, "/user/set_password?uid="+u.ID.String(), `{"password":"battery staple"}`, "admin", "admin"); code != http.StatusNoContent {
+		t.Error("status wrong:", code)
+	}
+	if _, _, err := store.Tokens.Authenticate(ctx, tr.AccessToken); !errors.Is(err, user.ErrUnauthorized) {
+		t.Errorf("session not revoked: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+type Permissions struct {
+	ID    uuid.UUID        `json:"id"`
+	Perms user.Permissions `json:"perms"`
+	Names []string         `json:"names"`
+}
+
+// grant?uid=...&perm=user:read,group:read
+func (rt *Router) GrantUser(w http.ResponseWriter, r *http.Request) {
+	rt.changeUserPermissions(w, r, rt.store.User.Grant)
+}
+
+// revoke?uid=...&perm=user:read,group:read
+func (rt *Router) RevokeUser(w http.ResponseWriter, r *http.Request) {
+	rt.changeUserPermissions(w, r, rt.store.User.Revoke)
+}
+
+func (rt *Router) changeUserPermissions(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, uid uuid.UUID, p user.Permissions) (*user.User, error)) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uid, perms, ok := permissionParams(w, r)
+	if !ok {
+		return
+	}
+
+	nbu, err := change(r.Context(), uid, perms)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		Permissions{
+			ID:    nbu.ID,
+			Perms: nbu.Permissions,
+			Names: nbu.Permissions.Names(),
+		},
+	)
+}
+
+// grant?uid=...&perm=user:read,group:read
+func (rt *Router) GrantGroup(w http.ResponseWriter, r *http.Request) {
+	rt.changeGroupPermissions(w, r, rt.store.Group.Grant)
+}
+
+// revoke?uid=...&perm=user:read,group:read
+func (rt *Router) RevokeGroup(w http.ResponseWriter, r *http.Request) {
+	rt.changeGroupPermissions(w, r, rt.store.Group.Revoke)
+}
+
+func (rt *Router) changeGroupPermissions(w http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, gid uuid.UUID, p user.Permissions) (*user.Group, error)) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gid, perms, ok := permissionParams(w, r)
+	if !ok {
+		return
+	}
+
+	ngu, err := change(r.Context(), gid, perms)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		Permissions{
+			ID:    ngu.ID,
+			Perms: ngu.Permissions,
+			Names: ngu.Permissions.Names(),
+		},
+	)
+}
+
+// permissions?uid=...
+// возвращает права пользователя вместе с правами его групп
+func (rt *Router) UserPermissions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (uid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	nbu, err := rt.store.User.Read(r.Context(), uid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	perms, err := rt.store.UserGroup.EffectivePermissions(r.Context(), *nbu)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		Permissions{
+			ID:    nbu.ID,
+			Perms: perms,
+			Names: perms.Names(),
+		},
+	)
+}
+
+// permissionParams разбирает uid и perm из строки запроса,
+// при ошибке сам отвечает клиенту
+func permissionParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, user.Permissions, bool) {
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return uuid.UUID{}, 0, false
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return uuid.UUID{}, 0, false
+	}
+	if (uid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return uuid.UUID{}, 0, false
+	}
+
+	perms, err := user.ParsePermissions(r.URL.Query().Get("perm"))
+	if err != nil {
+		writeError(w, err)
+		return uuid.UUID{}, 0, false
+	}
+	if perms == 0 {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return uuid.UUID{}, 0, false
+	}
+	return uid, perms, true
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_UserPermissions(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := store.User.Create(ctx, user.User{Name: "user123", Permissions: user.PermUserRead})
+	g, _ := store.Group.Create(ctx, user.Group{Name: "group123"})
+	_ = store.UserGroup.AddUserToGroup(ctx, *u, *g, user.RoleMember, time.Time{})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/group/grant?uid="+g.ID.String()+"&perm=group:read,group:write", nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/user/permissions?uid="+u.ID.String(), nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+
+	p := Permissions{}
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Perms != user.PermUserRead|user.PermGroupRead|user.PermGroupWrite {
+		t.Errorf("wrong permissions: %v", p.Names)
+	}
+}
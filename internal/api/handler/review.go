@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// reviewSignatureHeader - заголовок с подписью отчёта (Ed25519 тела в hex),
+// открытый ключ для её проверки отдаёт /review/public_key
+const reviewSignatureHeader = "X-Report-Signature"
+
+type StartReviewRequest struct {
+	Name       string      `json:"name"`
+	GroupIDs   []uuid.UUID `json:"group_ids"`
+	Deadline   time.Time   `json:"deadline"`
+	OnDeadline string      `json:"on_deadline"`
+}
+
+// ReviewCampaign - кампания и число пунктов в её снимке
+type ReviewCampaign struct {
+	user.Campaign
+	Items int `json:"items"`
+}
+
+type ReviewDecisionRequest struct {
+	Decision string `json:"decision"`
+	Comment  string `json:"comment"`
+}
+
+// canReview сообщает, может ли пользователь запроса решать по пункту it:
+// с правом group:write - по любому, владелец группы - по назначенному ему,
+// пока пункт не передан выше
+func (rt *Router) canReview(r *http.Request, it user.ReviewItem) bool {
+	if rt.hasPermissions(r, user.PermGroupWrite) {
+		return true
+	}
+	p, ok := user.PrincipalFromContext(r.Context())
+	return ok && !it.Escalated && it.Reviewer(p.ID)
+}
+
+// /review/start
+// {"name":"Q3","group_ids":["..."],"deadline":"2021-10-01T00:00:00Z","on_deadline":"revoke"}
+func (rt *Router) StartReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	req := StartReviewRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	c, items, err := rt.store.Reviews.Start(r.Context(), user.Campaign{
+		Name:       req.Name,
+		GroupIDs:   req.GroupIDs,
+		Deadline:   req.Deadline,
+		OnDeadline: user.DeadlineAction(req.OnDeadline),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(ReviewCampaign{Campaign: *c, Items: len(items)})
+}
+
+// /review/list - кампании, новые первыми
+func (rt *Router) ListReviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cs, err := rt.store.Reviews.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(cs)
+}
+
+// /review/items?cid=...
+// Пользователь без права group:write видит только пункты, по которым может решать.
+func (rt *Router) ListReviewItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cid, err := uuid.Parse(r.URL.Query().Get("cid"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	items, err := rt.store.Reviews.Items(r.Context(), cid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res := []user.ReviewItem{}
+	for _, it := range items {
+		if rt.canReview(r, it) {
+			res = append(res, it)
+		}
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// /review/decide?id=... {"decision":"keep"} или {"decision":"revoke","comment":"..."}
+func (rt *Router) DecideReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+
+	id, err := uuid.Parse(r.URL.Query().Get("id"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	req := ReviewDecisionRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	it, err := rt.store.Reviews.ReadItem(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !rt.canReview(r, *it) {
+		httpError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	it, err = rt.store.Reviews.Decide(r.Context(), id, user.ReviewDecision(req.Decision), req.Comment)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(it)
+}
+
+// /review/report?cid=...&format=json|csv
+// Подпись отчёта возвращается в заголовке X-Report-Signature.
+func (rt *Router) ReviewReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cid, err := uuid.Parse(r.URL.Query().Get("cid"))
+	if err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	format := user.ReportFormat(r.URL.Query().Get("format"))
+	b, sig, err := rt.store.Reviews.Report(r.Context(), cid, format)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if format == user.ReportCSV {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="review-`+cid.String()+`.csv"`)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set(reviewSignatureHeader, sig)
+	_, _ = w.Write(b)
+}
+
+// /review/public_key - открытый ключ подписи отчётов (PEM, PKIX)
+func (rt *Router) ReviewPublicKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(rt.store.Reviews.PublicKey())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_ = pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
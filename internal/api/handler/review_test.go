@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func TestRouter_AccessReviews(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	eng, _ := store.Group.Create(ctx, user.Group{Name: "eng"})
+	ops, _ := store.Group.Create(ctx, user.Group{Name: "ops"})
+	own, _ := store.User.Create(ctx, user.User{Name: "own"})
+	bob, _ := store.User.Create(ctx, user.User{Name: "bob"})
+	eve, _ := store.User.Create(ctx, user.User{Name: "eve"})
+	carl, _ := store.User.Create(ctx, user.User{Name: "carl"})
+	for _, u := range []*user.User{own, bob} {
+		if err := store.Credentials.SetPassword(ctx, u.ID, "correct horse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.UserGroup.AddUserToGroup(ctx, *own, *eng, user.RoleOwner, time.Time{})
+	_ = store.UserGroup.AddUserToGroup(ctx, *bob, *eng, user.RoleMember, time.Time{})
+	_ = store.UserGroup.AddUserToGroup(ctx, *eve, *eng, user.RoleMember, time.Time{})
+	_ = store.UserGroup.AddUserToGroup(ctx, *carl, *ops, user.RoleMember, time.Time{})
+
+	do := func(method, target, body, login, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.SetBasicAuth(login, password)
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	items := func(cid uuid.UUID, login, password string) map[uuid.UUID]user.ReviewItem {
+		t.Helper()
+		w := do(http.MethodGet, "/review/items?cid="+cid.String(), "", login, password)
+		var list []user.ReviewItem
+		if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		res := map[uuid.UUID]user.ReviewItem{}
+		for _, it := range list {
+			res[it.UserID] = it
+		}
+		return res
+	}
+
+	if w := do(http.MethodPost, "/review/start", `{"name":"q3","group_ids":["`+eng.ID.String()+`"]}`, "admin", "admin"); w.Code != http.StatusBadRequest {
+		t.Error("status wrong:", w.Code)
+	}
+	deadline := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w := do(http.MethodPost, "/review/start", `{"name":"q3","group_ids":["`+eng.ID.String()+`","`+ops.ID.String()+`"],"deadline":"`+deadline+`","on_deadline":"escalate"}`, "admin", "admin")
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code)
+	}
+	c := ReviewCampaign{}
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Items != 4 || c.Status != user.CampaignOpen {
+		t.Errorf("wrong campaign: %+v", c)
+	}
+
+	// владелец видит и решает пункты своей группы, кроме своего
+	mine := items(c.ID, "own", "correct horse")
+	if len(mine) != 2 {
+		t.Fatalf("wrong items: %+v", mine)
+	}
+	if w := do(http.MethodPost, "/review/decide?id="+mine[bob.ID].ID.String(), `{"decision":"keep"}`, "bob", "correct horse"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/review/decide?id="+mine[bob.ID].ID.String(), `{"decision":"keep"}`, "own", "correct horse"); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/review/decide?id="+mine[eve.ID].ID.String(), `{"decision":"revoke","comment":"left"}`, "own", "correct horse"); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+	if w := do(http.MethodPost, "/review/decide?id="+mine[eve.ID].ID.String(), `{"decision":"keep"}`, "own", "correct horse"); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+	if _, ok, _ := store.UserGroup.UserRole(ctx, *eve, *eng); ok {
+		t.Error("eve is still a member of eng")
+	}
+
+	// по сроку нерешённые пункты передаются выше
+	if n, err := store.Reviews.Expire(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 1 {
+		t.Fatal("expire wrong:", n, err)
+	}
+	all := items(c.ID, "admin", "admin")
+	if !all[own.ID].Escalated || !all[carl.ID].Escalated || all[bob.ID].Escalated {
+		t.Errorf("wrong escalation: %+v", all)
+	}
+	do(http.MethodPost, "/review/decide?id="+all[own.ID].ID.String(), `{"decision":"keep"}`, "admin", "admin")
+	do(http.MethodPost, "/review/decide?id="+all[carl.ID].ID.String(), `{"decision":"revoke","comment":"@SUM(1+1)"}`, "admin", "admin")
+	if c, _ := store.Reviews.Read(ctx, c.ID); c.Status != user.CampaignClosed {
+		t.Errorf("status wrong: %s", c.Status)
+	}
+
+	w = do(http.MethodGet, "/review/report?format=csv&cid="+c.ID.String(), "", "admin", "admin")
+	if w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	report := w.Body.Bytes()
+	sig := w.Header().Get("X-Report-Signature")
+	// подпись проверяется опубликованным открытым ключом
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/review/public_key", nil))
+	block, _ := pem.Decode(w.Body.Bytes())
+	if block == nil {
+		t.Fatalf("no public key: %d %s", w.Code, w.Body.String())
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.VerifyReport(pub.(ed25519.PublicKey), report, sig) {
+		t.Error("report signature is not valid")
+	}
+	if user.VerifyReport(pub.(ed25519.PublicKey), append(report, '\n'), sig) {
+		t.Error("signature of a modified report is valid")
+	}
+	if lines := strings.Count(string(report), "\n"); lines != 5 || !strings.Contains(string(report), ",carl,member,revoke,") {
+		t.Errorf("wrong report:\n%s", report)
+	}
+	// значение, похожее на формулу, экранируется
+	if !strings.Contains(string(report), ",'@SUM(1+1)") {
+		t.Errorf("formula is not escaped:\n%s", report)
+	}
+	if w := do(http.MethodGet, "/review/report?cid="+c.ID.String(), "", "own", "correct horse"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+}
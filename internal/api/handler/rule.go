@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// RulePreview - пользователи, подходящие под правило, и для существующей
+// группы - кто войдёт в неё и кто выйдет при сохранении правила
+type RulePreview struct {
+	Users   []User      `json:"users"`
+	Added   []uuid.UUID `json:"added,omitempty"`
+	Removed []uuid.UUID `json:"removed,omitempty"`
+}
+
+// /group/preview?rule=attr.department+%3D+sales&gid=...
+// Правило проверяется и применяется к пользователям, но не сохраняется.
+func (rt *Router) PreviewRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var g *user.Group
+	if sgid := r.URL.Query().Get("gid"); sgid != "" {
+		gid, err := uuid.Parse(sgid)
+		if err != nil {
+			httpError(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		g, err = rt.store.Group.Read(r.Context(), gid)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	p, err := rt.store.Dynamic.Preview(r.Context(), r.URL.Query().Get("rule"), g)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	resp := RulePreview{
+		Users:   make([]User, 0, len(p.Users)),
+		Added:   p.Added,
+		Removed: p.Removed,
+	}
+	for _, u := range p.Users {
+		resp.Users = append(resp.Users,
+			User{
+				ID:            u.ID,
+				Tenant:        u.Tenant,
+				Name:          u.Name,
+				Email:         u.Email,
+				EmailVerified: u.EmailVerified,
+				State:         u.State,
+				Attrs:         u.Attrs,
+				Permission:    u.Permissions,
+				Version:       u.Version,
+				CreatedAt:     u.CreatedAt,
+			},
+		)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
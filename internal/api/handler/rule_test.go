@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func TestRouter_DynamicGroups(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	_, _ = store.Schema.Define(ctx, user.AttrDef{Name: "department", Type: user.AttrString})
+	_, _ = store.Schema.Define(ctx, user.AttrDef{Name: "level", Type: user.AttrNumber})
+	ann, _ := store.User.Create(ctx, user.User{Name: "ann", Attrs: user.Attributes{"department": "sales", "level": 3.0}})
+	ben, _ := store.User.Create(ctx, user.User{Name: "ben", Attrs: user.Attributes{"department": "sales", "level": 1.0}})
+	_, _ = store.User.Create(ctx, user.User{Name: "cat", Attrs: user.Attributes{"department": "eng"}})
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	members := func(g *user.Group) map[uuid.UUID]bool {
+		t.Helper()
+		ch, err := store.UserGroup.GetGroupUsers(ctx, *g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := map[uuid.UUID]bool{}
+		for m := range ch {
+			res[m.User.ID] = true
+		}
+		return res
+	}
+	rule := `attr.department = sales and (attr.level >= 2 or name in (ben, "zed"))`
+
+	// предпросмотр ничего не сохраняет
+	if w := do(http.MethodGet, "/group/preview?rule="+url.QueryEscape("attr.department = "), ""); w.Code != http.StatusBadRequest {
+		t.Error("status wrong:", w.Code)
+	}
+	w := do(http.MethodGet, "/group/preview?rule="+url.QueryEscape(rule), "")
+	p := RulePreview{}
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Users) != 2 || p.Users[0].Name != "ann" || p.Users[1].Name != "ben" {
+		t.Errorf("wrong preview: %+v", p)
+	}
+
+	w = do(http.MethodPost, "/group/create", `{"name":"sales","rule":`+fmt.Sprintf("%q", rule)+`}`)
+	if w.Code != http.StatusCreated {
+		t.Fatal("status wrong:", w.Code, w.Body.String())
+	}
+	g := Group{}
+	if err := json.NewDecoder(w.Body).Decode(&g); err != nil {
+		t.Fatal(err)
+	}
+	sales, _ := store.Group.Read(ctx, g.ID)
+	if m := members(sales); len(m) != 2 || !m[ann.ID] || !m[ben.ID] {
+		t.Errorf("wrong members: %v", m)
+	}
+	ug, _ := store.UserGroup.GetUserGroups(ctx, *ann)
+	if m := <-ug; m.Group.ID != sales.ID {
+		t.Errorf("wrong groups of ann: %+v", m)
+	}
+
+	// состав меняется вместе с пользователями, но не вручную
+	ben.Attrs["department"] = "eng"
+	if _, err := store.User.Update(ctx, *ben); err != nil {
+		t.Fatal(err)
+	}
+	dan, _ := store.User.Create(ctx, user.User{Name: "dan", Attrs: user.Attributes{"department": "sales", "level": 5.0}})
+	if m := members(sales); len(m) != 2 || !m[ann.ID] || !m[dan.ID] {
+		t.Errorf("wrong members: %v", m)
+	}
+	if w := do(http.MethodGet, "/group/add_user?uid="+ben.ID.String()+"&gid="+sales.ID.String(), ""); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+
+	// предпросмотр нового правила для группы показывает разницу
+	w = do(http.MethodGet, "/group/preview?gid="+sales.ID.String()+"&rule="+url.QueryEscape("attr.level > 4"), "")
+	p = RulePreview{}
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Users) != 1 || len(p.Added) != 0 || len(p.Removed) != 1 || p.Removed[0] != ann.ID {
+		t.Errorf("wrong preview: %+v", p)
+	}
+	if w := do(http.MethodPatch, "/group/update?uid="+sales.ID.String(), `{"rule":"attr.level > 4","version":1}`); w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code, w.Body.String())
+	}
+	if m := members(sales); len(m) != 1 || !m[dan.ID] {
+		t.Errorf("wrong members: %v", m)
+	}
+
+	// правило группы с правами меняет только тот, кто может раздавать права
+	staff, err := store.Group.Create(ctx, user.Group{Name: "staff", Rule: `attr.department = eng`, Permissions: user.PermUserRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, _ := store.User.Create(ctx, user.User{Name: "writer", Permissions: user.PermGroupRead | user.PermGroupWrite})
+	if err := store.Credentials.SetPassword(ctx, writer.ID, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	patch := fmt.Sprintf(`{"version":%d,"rule":"name in (writer)"}`, staff.Version)
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/group/update?uid="+staff.ID.String(), strings.NewReader(patch))
+	r.SetBasicAuth("writer", "correct horse")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPatch, "/group/update?uid="+staff.ID.String(), patch); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code, w.Body.String())
+	}
+
+	// и не подгоняет под него свои атрибуты
+	ops, err := store.Group.Create(ctx, user.Group{Name: "ops", Rule: `attr.department = ops`, Permissions: user.PermUserRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clerk, _ := store.User.Create(ctx, user.User{Name: "clerk", Permissions: user.PermUserWrite})
+	if err := store.Credentials.SetPassword(ctx, clerk.ID, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	patch = `{"version":1,"attrs":{"department":"ops"}}`
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPatch, "/user/update?uid="+clerk.ID.String(), strings.NewReader(patch))
+	r.SetBasicAuth("clerk", "correct horse")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code, w.Body.String())
+	}
+	if m := members(ops); m[clerk.ID] {
+		t.Errorf("wrong members: %v", m)
+	}
+	if w := do(http.MethodPatch, "/user/update?uid="+clerk.ID.String(), patch); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code, w.Body.String())
+	}
+	if m := members(ops); !m[clerk.ID] {
+		t.Errorf("wrong members: %v", m)
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+// /schema/attrs - список атрибутов пользователей
+func (rt *Router) ListAttrs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defs, err := rt.store.Schema.List(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(defs)
+}
+
+// checkSchemaOperator разрешает менять схему только пользователям
+// арендатора по умолчанию: схема одна на всех арендаторов
+func checkSchemaOperator(r *http.Request) error {
+	p, ok := user.PrincipalFromContext(r.Context())
+	if !ok || p.Tenant != user.DefaultTenant {
+		return user.Forbidden("attribute schema is shared by all tenants, only operators can change it")
+	}
+	return nil
+}
+
+// /schema/define
+// {"name":"department","type":"string","required":true,"enum":["sales","it"]}
+func (rt *Router) DefineAttr(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := checkSchemaOperator(r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	defer r.Body.Close()
+
+	def := user.AttrDef{}
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	ndef, err := rt.store.Schema.Define(r.Context(), def)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(ndef)
+}
+
+// /schema/remove?name=...
+func (rt *Router) RemoveAttr(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := checkSchemaOperator(r); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.store.Schema.Remove(r.Context(), name); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_SearchByAttrs(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	for _, def := range []string{
+		`{"name":"department","type":"string","required":true,"enum":["sales","it"]}`,
+		`{"name":"login","type":"string","unique":true}`,
+		`{"name":"hired","type":"time"}`,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/schema/define", strings.NewReader(def))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatal("status wrong:", w.Code, w.Body.String())
+		}
+	}
+
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"name":"bob","attrs":{"department":"sales","login":"bob","hired":"2021-01-02T03:04:05Z"}}`, http.StatusCreated},
+		{`{"name":"bob2","attrs":{"department":"sales","login":"bob"}}`, http.StatusConflict},
+		{`{"name":"eve","attrs":{"department":"hr"}}`, http.StatusBadRequest},
+		{`{"name":"eve","attrs":{"login":"eve"}}`, http.StatusBadRequest},
+		{`{"name":"eve","attrs":{"department":"it","shoe":42}}`, http.StatusBadRequest},
+		{`{"name":"eve","attrs":{"department":"it"}}`, http.StatusCreated},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/user/create", strings.NewReader(tc.body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: status wrong: %d %s", tc.body, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/user/search?attr.department=sales&attr.hired=2021-01-02T03:04:05Z", nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	page := UserPage{}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Users) != 1 || page.Users[0].Name != "bob" {
+		t.Fatalf("wrong users: %+v", page.Users)
+	}
+
+	// значение удалённого пользователя свободно, но восстановить его после этого нельзя
+	bob := page.Users[0].ID.String()
+	for _, tc := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodDelete, "/user/delete?uid=" + bob, "", http.StatusOK},
+		{http.MethodPost, "/user/create", `{"name":"bob2","attrs":{"department":"it","login":"bob"}}`, http.StatusCreated},
+		{http.MethodPost, "/user/restore?uid=" + bob, "", http.StatusConflict},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: status wrong: %d %s", tc.target, w.Code, w.Body.String())
+		}
+	}
+
+	// атрибут занят и для вызова из другого арендатора: схема общая
+	if err := store.Schema.Remove(user.WithTenant(ctx, "emea"), "login"); !errors.Is(err, user.ErrConflict) {
+		t.Errorf("attribute in use removed: %v", err)
+	}
+
+	// менять схему могут только пользователи арендатора по умолчанию
+	emea := user.WithTenant(ctx, "emea")
+	schemer, err := store.User.Create(emea, user.User{
+		Name:        "schemer",
+		Attrs:       user.Attributes{"department": "it"},
+		Permissions: user.PermSchemaWrite,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Credentials.SetPassword(emea, schemer.ID, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/schema/define", strings.NewReader(`{"name":"shoe","type":"int"}`))
+	r.SetBasicAuth("schemer", "correct horse")
+	r.Header.Set(TenantHeader, "emea")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// suspend?uid=...
+// Приостановленный пользователь не может выполнять запросы
+// и не показывается в списках участников групп.
+func (rt *Router) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	rt.setUserState(w, r, rt.store.User.Suspend)
+}
+
+// reactivate?uid=...
+// Возвращает в активное состояние приостановленного, заблокированного,
+// выключенного или приглашённого пользователя.
+func (rt *Router) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	rt.setUserState(w, r, rt.store.User.Reactivate)
+}
+
+func (rt *Router) setUserState(w http.ResponseWriter, r *http.Request,
+	set func(ctx context.Context, uid uuid.UUID) (*user.User, error)) {
+	if r.Method != http.MethodPost {
+		httpError(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suid := r.URL.Query().Get("uid")
+	if suid == "" {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	uid, err := uuid.Parse(suid)
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (uid == uuid.UUID{}) {
+		httpError(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	nbu, err := set(r.Context(), uid)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(
+		User{
+			ID:            nbu.ID,
+			Tenant:        nbu.Tenant,
+			Name:          nbu.Name,
+			Email:         nbu.Email,
+			EmailVerified: nbu.EmailVerified,
+			State:         nbu.State,
+			Attrs:         nbu.Attrs,
+			Permission:    nbu.Permissions,
+			Version:       nbu.Version,
+			CreatedAt:     nbu.CreatedAt,
+		},
+	)
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+func TestRouter_UserStates(t *testing.T) {
+	store, rt := newTestRouter(t)
+	ctx := user.AllTenants(context.Background())
+
+	u, err := store.User.Create(ctx, user.User{Name: "alice", Permissions: user.PermUserRead | user.PermGroupRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.State != user.StateActive {
+		t.Errorf("state wrong: %s", u.State)
+	}
+	if err := store.Credentials.SetPassword(ctx, u.ID, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	g, _ := store.Group.Create(ctx, user.Group{Name: "eng"})
+	_ = store.UserGroup.AddUserToGroup(ctx, *u, *g, user.RoleMember, time.Time{})
+
+	do := func(method, target, login, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, nil)
+		r.SetBasicAuth(login, password)
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	members := func(target string) int {
+		t.Helper()
+		w := do(http.MethodGet, target, "admin", "admin")
+		var ms []UserMembership
+		if err := json.NewDecoder(w.Body).Decode(&ms); err != nil {
+			t.Fatal(err)
+		}
+		return len(ms)
+	}
+
+	if w := do(http.MethodPost, "/user/suspend?uid="+u.ID.String(), "admin", "admin"); w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodGet, "/user/search", "alice", "correct horse"); w.Code != http.StatusForbidden {
+		t.Error("status wrong:", w.Code)
+	}
+	if n := members("/group/members?gid=" + g.ID.String()); n != 0 {
+		t.Errorf("suspended user listed: %d", n)
+	}
+	if n := members("/group/members?all=1&gid=" + g.ID.String()); n != 1 {
+		t.Errorf("wrong members: %d", n)
+	}
+	w := do(http.MethodGet, "/user/search?state=suspended", "admin", "admin")
+	page := UserPage{}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Users) != 1 || page.Users[0].ID != u.ID {
+		t.Errorf("wrong users: %+v", page.Users)
+	}
+	if w := do(http.MethodGet, "/user/search?state=sleeping", "admin", "admin"); w.Code != http.StatusBadRequest {
+		t.Error("status wrong:", w.Code)
+	}
+
+	// вернуться в invited нельзя, приостановить повторно - тоже
+	if _, err := store.User.SetState(ctx, u.ID, user.StateInvited); !errors.Is(err, user.ErrConflict) {
+		t.Errorf("invalid transition allowed: %v", err)
+	}
+	if w := do(http.MethodPost, "/user/suspend?uid="+u.ID.String(), "admin", "admin"); w.Code != http.StatusConflict {
+		t.Error("status wrong:", w.Code)
+	}
+
+	if w := do(http.MethodPost, "/user/reactivate?uid="+u.ID.String(), "admin", "admin"); w.Code != http.StatusOK {
+		t.Fatal("status wrong:", w.Code)
+	}
+	if w := do(http.MethodGet, "/user/search", "alice", "correct horse"); w.Code != http.StatusOK {
+		t.Error("status wrong:", w.Code)
+	}
+
+	evs, err := store.Outbox.Read(ctx, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, ev := range evs {
+		if ev.Type == user.EventUserStateChanged {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("wrong state events: %d", n)
+	}
+}
@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"time"
 
@@ -14,6 +16,9 @@ var _ starter.APIServer = &Server{}
 type Server struct {
 	srv   http.Server
 	store *store.Store
+	// certFile и keyFile заданы, если сервер работает по TLS
+	certFile string
+	keyFile  string
 }
 
 func NewServer(addr string, h http.Handler, store *store.Store) *Server {
@@ -31,6 +36,18 @@ func NewServer(addr string, h http.Handler, store *store.Store) *Server {
 	return s
 }
 
+// UseTLS включает TLS. Если задан clientCAs, сервер проверяет клиентские
+// сертификаты, подписанные этими CA (сертификат необязателен, вход
+// по нему - handler.CertAuth).
+func (s *Server) UseTLS(certFile, keyFile string, clientCAs *x509.CertPool) {
+	s.certFile, s.keyFile = certFile, keyFile
+	s.srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAs != nil {
+		s.srv.TLSConfig.ClientCAs = clientCAs
+		s.srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+}
+
 func (s *Server) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	s.srv.Shutdown(ctx)
@@ -38,5 +55,9 @@ func (s *Server) Stop() {
 }
 
 func (s *Server) Start() {
+	if s.certFile != "" {
+		go s.srv.ListenAndServeTLS(s.certFile, s.keyFile)
+		return
+	}
 	go s.srv.ListenAndServe()
 }
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+// сколько событий читать из outbox за раз
+const batchSize = 100
+
+// Source - outbox, из которого диспетчер читает события
+type Source interface {
+	Read(ctx context.Context, after uint64, limit int) ([]user.Event, error)
+	Trim(ctx context.Context, upTo uint64) error
+}
+
+// Sink - получатель событий. События приходят по возрастанию Seq;
+// если Deliver вернул ошибку, те же события будут доставлены повторно,
+// поэтому получатель должен быть готов к дублям.
+type Sink interface {
+	Deliver(ctx context.Context, evs []user.Event) error
+}
+
+// Dispatcher доставляет события из outbox всем получателям хотя бы один раз.
+// Для каждого получателя хранится номер последнего доставленного события,
+// события, доставленные всем, удаляются из outbox.
+type Dispatcher struct {
+	src      Source
+	sinks    []Sink
+	cursors  []uint64
+	interval time.Duration
+}
+
+func NewDispatcher(src Source, interval time.Duration, sinks ...Sink) *Dispatcher {
+	return &Dispatcher{
+		src:      src,
+		sinks:    sinks,
+		cursors:  make([]uint64, len(sinks)),
+		interval: interval,
+	}
+}
+
+// Run раз в interval доставляет накопившиеся события, пока не отменён ctx
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		d.Dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Dispatch доставляет все накопившиеся события
+func (d *Dispatcher) Dispatch(ctx context.Context) {
+	if len(d.sinks) == 0 {
+		return
+	}
+	for i, s := range d.sinks {
+		for {
+			evs, err := d.src.Read(ctx, d.cursors[i], batchSize)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if len(evs) == 0 {
+				break
+			}
+			if err := s.Deliver(ctx, evs); err != nil {
+				log.Println("deliver events error:", err)
+				break
+			}
+			d.cursors[i] = evs[len(evs)-1].Seq
+		}
+	}
+
+	done := d.cursors[0]
+	for _, c := range d.cursors[1:] {
+		if c < done {
+			done = c
+		}
+	}
+	if done > 0 {
+		if err := d.src.Trim(ctx, done); err != nil {
+			log.Println(err)
+		}
+	}
+}
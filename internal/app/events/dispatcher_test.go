@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+	"gb-backend2/internal/app/store"
+)
+
+type failingSink struct {
+	fail bool
+	got  []user.Event
+}
+
+func (s *failingSink) Deliver(ctx context.Context, evs []user.Event) error {
+	if s.fail {
+		return errors.New("unavailable")
+	}
+	s.got = append(s.got, evs...)
+	return nil
+}
+
+func TestDispatcher_AtLeastOnce(t *testing.T) {
+	st, _ := store.NewStore()
+	ctx := user.AllTenants(context.Background())
+
+	u, _ := st.User.Create(ctx, user.User{Name: "user123"})
+	g, _ := st.Group.Create(ctx, user.Group{Name: "group123"})
+
+	// откаченная транзакция не должна оставить событий
+	_ = st.InTx(ctx, func(ctx context.Context) error {
+		_ = st.UserGroup.AddUserToGroup(ctx, *u, *g, user.RoleMember, time.Time{})
+		return errors.New("rollback")
+	})
+	_ = st.UserGroup.AddUserToGroup(ctx, *u, *g, user.RoleMember, time.Time{})
+
+	sub := NewSubscribers()
+	var seen []user.EventType
+	sub.Subscribe(func(ev user.Event) { seen = append(seen, ev.Type) })
+	fs := &failingSink{fail: true}
+	d := NewDispatcher(st.Outbox, time.Second, sub, fs)
+
+	d.Dispatch(ctx)
+	want := []user.EventType{user.EventUserCreated, user.EventGroupCreated, user.EventMembershipAdded}
+	if len(seen) != len(want) {
+		t.Fatalf("wrong events: %v", seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Errorf("event %d: %s, want %s", i, seen[i], want[i])
+		}
+	}
+
+	fs.fail = false
+	d.Dispatch(ctx)
+	if len(fs.got) != 3 || fs.got[0].Seq != 1 || fs.got[2].Seq != 3 {
+		t.Errorf("events not redelivered: %+v", fs.got)
+	}
+
+	evs, _ := st.Outbox.Read(ctx, 0, 100)
+	if len(evs) != 0 {
+		t.Errorf("outbox not trimmed: %+v", evs)
+	}
+}
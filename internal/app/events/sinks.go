@@ -0,0 +1,143 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// Record - событие в виде строки NDJSON
+type Record struct {
+	Seq      uint64     `json:"seq"`
+	Type     string     `json:"type"`
+	At       time.Time  `json:"at"`
+	Actor    *uuid.UUID `json:"actor,omitempty"`
+	Tenant   string     `json:"tenant,omitempty"`
+	UserID   *uuid.UUID `json:"user_id,omitempty"`
+	GroupID  *uuid.UUID `json:"group_id,omitempty"`
+	ParentID *uuid.UUID `json:"parent_id,omitempty"`
+}
+
+func NewRecord(ev user.Event) Record {
+	return Record{
+		Seq:      ev.Seq,
+		Type:     string(ev.Type),
+		At:       ev.At,
+		Actor:    optionalID(ev.Actor),
+		Tenant:   ev.Tenant,
+		UserID:   optionalID(ev.UserID),
+		GroupID:  optionalID(ev.GroupID),
+		ParentID: optionalID(ev.ParentID),
+	}
+}
+
+func optionalID(id uuid.UUID) *uuid.UUID {
+	if (id == uuid.UUID{}) {
+		return nil
+	}
+	return &id
+}
+
+var _ Sink = &Subscribers{}
+
+// Subscribers передаёт события подписчикам внутри процесса
+type Subscribers struct {
+	sync.Mutex
+	next int
+	subs map[int]func(user.Event)
+}
+
+func NewSubscribers() *Subscribers {
+	return &Subscribers{
+		subs: make(map[int]func(user.Event)),
+	}
+}
+
+// Subscribe добавляет подписчика, возвращает функцию отписки
+func (s *Subscribers) Subscribe(fn func(user.Event)) func() {
+	s.Lock()
+	defer s.Unlock()
+	id := s.next
+	s.next++
+	s.subs[id] = fn
+	return func() {
+		s.Lock()
+		defer s.Unlock()
+		delete(s.subs, id)
+	}
+}
+
+func (s *Subscribers) Deliver(ctx context.Context, evs []user.Event) error {
+	s.Lock()
+	subs := make([]func(user.Event), 0, len(s.subs))
+	for _, fn := range s.subs {
+		subs = append(subs, fn)
+	}
+	s.Unlock()
+
+	for _, ev := range evs {
+		for _, fn := range subs {
+			fn(ev)
+		}
+	}
+	return nil
+}
+
+var _ Sink = &WriterSink{}
+
+// WriterSink пишет события в NDJSON, например в stdout
+type WriterSink struct {
+	w io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{
+		w: w,
+	}
+}
+
+func (s *WriterSink) Deliver(ctx context.Context, evs []user.Event) error {
+	enc := json.NewEncoder(s.w)
+	for _, ev := range evs {
+		if err := enc.Encode(NewRecord(ev)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ Sink = &FileSink{}
+
+// FileSink дописывает события в NDJSON-файл и сбрасывает его на диск
+// после каждой пачки, только после этого события считаются доставленными
+type FileSink struct {
+	f *os.File
+}
+
+func OpenFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		f: f,
+	}, nil
+}
+
+func (s *FileSink) Deliver(ctx context.Context, evs []user.Event) error {
+	if err := NewWriterSink(s.f).Deliver(ctx, evs); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
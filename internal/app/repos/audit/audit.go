@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Entry - запись журнала аудита об одном изменении.
+// Hash считается по записи и Hash предыдущей, поэтому подмена или удаление
+// записи в середине журнала обнаруживается проверкой цепочки.
+type Entry struct {
+	Seq       uint64    `json:"seq"`
+	At        time.Time `json:"at"`
+	Actor     uuid.UUID `json:"actor"`
+	ActorName string    `json:"actor_name"`
+	RequestID string    `json:"request_id"`
+	// Tenant пуст для арендатора по умолчанию и тогда не попадает в хеш,
+	// поэтому записи, сделанные до появления арендаторов, остаются верными
+	Tenant   string          `json:"tenant,omitempty"`
+	Action   string          `json:"action"`
+	UserID   uuid.UUID       `json:"user_id"`
+	GroupID  uuid.UUID       `json:"group_id"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+}
+
+// Filter - условия поиска, нулевые поля не ограничивают выборку.
+// After и Limit задают страницу: записи с Seq > After, не больше Limit.
+type Filter struct {
+	// Tenant, если задан, оставляет записи только этого арендатора
+	Tenant  *string
+	Actor   uuid.UUID
+	UserID  uuid.UUID
+	GroupID uuid.UUID
+	Action  string
+	From    time.Time
+	To      time.Time
+	After   uint64
+	Limit   int
+}
+
+// Match сообщает, подходит ли запись под фильтр (без учёта страницы)
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.Tenant != nil && e.Tenant != *f.Tenant:
+		return false
+	case f.Actor != uuid.UUID{} && e.Actor != f.Actor:
+		return false
+	case f.UserID != uuid.UUID{} && e.UserID != f.UserID:
+		return false
+	case f.GroupID != uuid.UUID{} && e.GroupID != f.GroupID:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case !f.From.IsZero() && e.At.Before(f.From):
+		return false
+	case !f.To.IsZero() && !e.At.Before(f.To):
+		return false
+	}
+	return true
+}
+
+type AuditStore interface {
+	// LastEntry возвращает последнюю запись журнала или nil, если он пуст
+	LastEntry(ctx context.Context) (*Entry, error)
+	AppendEntry(ctx context.Context, e Entry) error
+	SearchEntries(ctx context.Context, f Filter) ([]Entry, error)
+}
+
+// Committer откладывает действие до фиксации транзакции основного хранилища
+type Committer interface {
+	// OnCommit выполняет commit при фиксации транзакции ctx, пока её
+	// изменения ещё не видны, и rollback при откате. Ошибка commit
+	// откатывает транзакцию. Возвращает false, если ctx вне транзакции.
+	OnCommit(ctx context.Context, commit func() error, rollback func()) bool
+}
+
+// TxStore - хранилище журнала отдельно от основного хранилища: записи,
+// сделанные в транзакции, оно держит у себя до её фиксации через c
+type TxStore interface {
+	AuditStore
+	UseCommitter(c Committer)
+}
+
+type Log struct {
+	store AuditStore
+}
+
+func NewLog(store AuditStore) *Log {
+	return &Log{
+		store: store,
+	}
+}
+
+// Record дописывает e в конец журнала. Вызывается в транзакции изменения,
+// чтобы запись попала в журнал вместе с ним.
+func (l *Log) Record(ctx context.Context, e Entry) error {
+	last, err := l.store.LastEntry(ctx)
+	if err != nil {
+		return fmt.Errorf("read audit error: %w", err)
+	}
+	if last != nil {
+		e.Seq = last.Seq + 1
+		e.PrevHash = last.Hash
+	} else {
+		e.Seq = 1
+		e.PrevHash = ""
+	}
+	e.At = e.At.UTC()
+	if e.RequestID == "" {
+		e.RequestID = RequestIDFromContext(ctx)
+	}
+	e.Hash = Hash(e)
+	if err := l.store.AppendEntry(ctx, e); err != nil {
+		return fmt.Errorf("write audit error: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) Search(ctx context.Context, f Filter) ([]Entry, error) {
+	es, err := l.store.SearchEntries(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("search audit error: %w", err)
+	}
+	return es, nil
+}
+
+// Verify проверяет цепочку хешей всего журнала. Возвращает номер первой
+// испорченной записи или 0, если журнал цел.
+func (l *Log) Verify(ctx context.Context) (uint64, error) {
+	const page = 1000
+	prev := ""
+	var after uint64
+	for {
+		es, err := l.store.SearchEntries(ctx, Filter{After: after, Limit: page})
+		if err != nil {
+			return 0, fmt.Errorf("search audit error: %w", err)
+		}
+		for _, e := range es {
+			if e.Seq != after+1 || e.PrevHash != prev || e.Hash != Hash(e) {
+				return after + 1, nil
+			}
+			prev = e.Hash
+			after = e.Seq
+		}
+		if len(es) < page {
+			return 0, nil
+		}
+	}
+}
+
+// Hash считает хеш записи, поле Hash при этом не учитывается
+func Hash(e Entry) string {
+	e.Hash = ""
+	b, _ := json.Marshal(e)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,171 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// apiKeyTouchInterval - как часто обновлять время последнего использования ключа,
+// чтобы не писать в хранилище на каждый запрос
+const apiKeyTouchInterval = time.Minute
+
+// APIKey - ключ доступа для вызовов без входа. Сам ключ показывается только
+// при создании, хранится SHA-256 его секретной части. Права запроса по ключу -
+// пересечение прав пользователя и Scopes.
+type APIKey struct {
+	ID         uuid.UUID   `json:"id"`
+	UserID     uuid.UUID   `json:"user_id"`
+	Name       string      `json:"name"`
+	Hash       []byte      `json:"-"`
+	Scopes     Permissions `json:"scopes"`
+	CreatedAt  time.Time   `json:"created_at"`
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time  `json:"last_used_at,omitempty"`
+}
+
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, k APIKey) error
+	ReadAPIKey(ctx context.Context, id uuid.UUID) (*APIKey, error)
+	UserAPIKeys(ctx context.Context, uid uuid.UUID) ([]APIKey, error)
+	DeleteAPIKey(ctx context.Context, id uuid.UUID) error
+	// TouchAPIKey записывает время последнего использования ключа
+	TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error
+}
+
+type APIKeys struct {
+	store  APIKeyStore
+	users  UserStore
+	outbox *Outbox
+}
+
+func NewAPIKeys(store APIKeyStore, users UserStore, outbox *Outbox) *APIKeys {
+	return &APIKeys{
+		store:  store,
+		users:  users,
+		outbox: outbox,
+	}
+}
+
+// Create создаёт ключ пользователю k.UserID и возвращает его вместе
+// с самим ключом, который больше получить нельзя
+func (ks *APIKeys) Create(ctx context.Context, k APIKey) (*APIKey, string, error) {
+	k.Name = strings.TrimSpace(k.Name)
+	fields := map[string]string{}
+	if k.Name == "" {
+		fields["name"] = "must not be empty"
+	}
+	if k.Scopes == 0 {
+		fields["scopes"] = "must not be empty"
+	}
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		fields["expires_at"] = "must be in the future"
+	}
+	if len(fields) > 0 {
+		return nil, "", Invalid(fields)
+	}
+
+	secret := newSecret()
+	k.ID = uuid.New()
+	k.Hash = hashSecret(secret)
+	k.CreatedAt = time.Now().UTC()
+	k.LastUsedAt = nil
+	err := ks.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if _, err := ks.users.ReadUser(ctx, k.UserID); err != nil {
+			return nil, err
+		}
+		if err := ks.store.CreateAPIKey(ctx, k); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventAPIKeyCreated, UserID: k.UserID, After: k}}, nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("create api key error: %w", err)
+	}
+	return &k, k.ID.String() + "." + secret, nil
+}
+
+func (ks *APIKeys) Read(ctx context.Context, id uuid.UUID) (*APIKey, error) {
+	k, err := ks.store.ReadAPIKey(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("read api key error: %w", err)
+	}
+	// ключи пользователей другого арендатора не видны
+	if _, err := ks.users.ReadUser(ctx, k.UserID); err != nil {
+		return nil, NotFound("api key %s not found", id)
+	}
+	return k, nil
+}
+
+// List возвращает ключи пользователя в порядке создания
+func (ks *APIKeys) List(ctx context.Context, uid uuid.UUID) ([]APIKey, error) {
+	if _, err := ks.users.ReadUser(ctx, uid); err != nil {
+		return nil, fmt.Errorf("list api keys error: %w", err)
+	}
+	kk, err := ks.store.UserAPIKeys(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys error: %w", err)
+	}
+	sort.Slice(kk, func(i, j int) bool {
+		return kk[i].CreatedAt.Before(kk[j].CreatedAt)
+	})
+	return kk, nil
+}
+
+// Revoke удаляет ключ, запросы с ним сразу перестают приниматься
+func (ks *APIKeys) Revoke(ctx context.Context, id uuid.UUID) error {
+	err := ks.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		k, err := ks.store.ReadAPIKey(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := ks.store.DeleteAPIKey(ctx, id); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventAPIKeyRevoked, UserID: k.UserID, Before: *k}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("revoke api key error: %w", err)
+	}
+	return nil
+}
+
+// Authenticate проверяет ключ и возвращает его владельца
+func (ks *APIKeys) Authenticate(ctx context.Context, key string) (*User, *APIKey, error) {
+	id, secret, err := splitToken(key)
+	if err != nil {
+		return nil, nil, ErrUnauthorized
+	}
+	k, err := ks.store.ReadAPIKey(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read api key error: %w", err)
+	}
+	now := time.Now().UTC()
+	if !hmac.Equal(k.Hash, hashSecret(secret)) || (k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)) {
+		return nil, nil, ErrUnauthorized
+	}
+	u, err := ks.users.ReadUser(ctx, k.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read user error: %w", err)
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchInterval {
+		if err := ks.store.TouchAPIKey(ctx, k.ID, now); err != nil {
+			return nil, nil, fmt.Errorf("touch api key error: %w", err)
+		}
+		k.LastUsedAt = &now
+	}
+	return u, k, nil
+}
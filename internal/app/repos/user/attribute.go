@@ -0,0 +1,475 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AttrType - тип значения атрибута пользователя
+type AttrType string
+
+const (
+	AttrString AttrType = "string"
+	AttrNumber AttrType = "number"
+	AttrBool   AttrType = "bool"
+	// AttrList - список строк
+	AttrList AttrType = "list"
+	// AttrTime - момент времени, в JSON передаётся строкой RFC 3339
+	AttrTime AttrType = "time"
+)
+
+// Attributes - атрибуты пользователя. Значения приводятся по схеме
+// к string, float64, bool, []string или time.Time.
+type Attributes map[string]interface{}
+
+// Clone копирует атрибуты вместе со списками, хранилища отдают
+// и сохраняют копии, чтобы вызывающий не менял их данные
+func (a Attributes) Clone() Attributes {
+	if a == nil {
+		return nil
+	}
+	na := make(Attributes, len(a))
+	for k, v := range a {
+		if l, ok := v.([]string); ok {
+			v = append([]string(nil), l...)
+		}
+		na[k] = v
+	}
+	return na
+}
+
+// AttrDef - описание атрибута в схеме
+type AttrDef struct {
+	Name     string   `json:"name"`
+	Type     AttrType `json:"type"`
+	Required bool     `json:"required,omitempty"`
+	// Unique - значение не повторяется у разных пользователей
+	Unique bool `json:"unique,omitempty"`
+	// Enum - допустимые значения для string и элементов list
+	Enum []string `json:"enum,omitempty"`
+}
+
+var attrNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+func (def AttrDef) validate() error {
+	fields := map[string]string{}
+	if !attrNameRe.MatchString(def.Name) {
+		fields["name"] = "must match " + attrNameRe.String()
+	}
+	switch def.Type {
+	case AttrString, AttrNumber, AttrTime:
+	case AttrBool, AttrList:
+		if def.Unique {
+			fields["unique"] = fmt.Sprintf("not supported for %s", def.Type)
+		}
+	default:
+		fields["type"] = "must be one of string, number, bool, list, time"
+	}
+	if len(def.Enum) > 0 && def.Type != AttrString && def.Type != AttrList {
+		fields["enum"] = fmt.Sprintf("not supported for %s", def.Type)
+	}
+	seen := make(map[string]bool, len(def.Enum))
+	for _, v := range def.Enum {
+		if v == "" || seen[v] {
+			fields["enum"] = "values must be non-empty and distinct"
+		}
+		seen[v] = true
+	}
+	if len(fields) > 0 {
+		return Invalid(fields)
+	}
+	return nil
+}
+
+func (def AttrDef) allowed(s string) bool {
+	if len(def.Enum) == 0 {
+		return true
+	}
+	for _, v := range def.Enum {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize приводит значение, пришедшее из JSON, к типу атрибута
+func (def AttrDef) normalize(v interface{}) (interface{}, error) {
+	switch def.Type {
+	case AttrString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a string")
+		}
+		if !def.allowed(s) {
+			return nil, fmt.Errorf("must be one of %v", def.Enum)
+		}
+		return s, nil
+	case AttrNumber:
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case int:
+			return float64(n), nil
+		}
+		return nil, fmt.Errorf("must be a number")
+	case AttrBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("must be a bool")
+		}
+		return b, nil
+	case AttrList:
+		var l []string
+		switch vs := v.(type) {
+		case []string:
+			l = append(l, vs...)
+		case []interface{}:
+			for _, e := range vs {
+				s, ok := e.(string)
+				if !ok {
+					return nil, fmt.Errorf("must be a list of strings")
+				}
+				l = append(l, s)
+			}
+		default:
+			return nil, fmt.Errorf("must be a list of strings")
+		}
+		for _, s := range l {
+			if !def.allowed(s) {
+				return nil, fmt.Errorf("values must be one of %v", def.Enum)
+			}
+		}
+		return l, nil
+	case AttrTime:
+		switch t := v.(type) {
+		case time.Time:
+			return t.UTC(), nil
+		case string:
+			pt, err := time.Parse(time.RFC3339, t)
+			if err != nil {
+				return nil, fmt.Errorf("must be RFC 3339 time")
+			}
+			return pt.UTC(), nil
+		}
+		return nil, fmt.Errorf("must be RFC 3339 time")
+	}
+	return nil, fmt.Errorf("unknown type %s", def.Type)
+}
+
+// MatchAttr сравнивает значение атрибута со значением из фильтра поиска.
+// Для списка достаточно, чтобы в нём был элемент want.
+func MatchAttr(v interface{}, want string) bool {
+	switch x := v.(type) {
+	case string:
+		return x == want
+	case float64:
+		n, err := strconv.ParseFloat(want, 64)
+		return err == nil && n == x
+	case bool:
+		b, err := strconv.ParseBool(want)
+		return err == nil && b == x
+	case []string:
+		for _, s := range x {
+			if s == want {
+				return true
+			}
+		}
+	case time.Time:
+		t, err := time.Parse(time.RFC3339, want)
+		return err == nil && t.Equal(x)
+	}
+	return false
+}
+
+// FormatAttr записывает значение атрибута так, как его понимает MatchAttr
+func FormatAttr(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// UserQuery - условия поиска пользователей, пустые условия не проверяются
+type UserQuery struct {
+	// Name - подстрока имени без учёта регистра
+	Name string
+	// State - состояние пользователя
+	State State
+	// Attrs - значения атрибутов, должны совпасть все
+	Attrs map[string]string
+}
+
+// Match проверяет условия на u, хранилища без своего поиска
+// могут отбирать пользователей им
+func (q UserQuery) Match(u User) bool {
+	if q.Name != "" && !strings.Contains(u.Name, NormalizeName(q.Name)) {
+		return false
+	}
+	if q.State != "" && u.State != q.State {
+		return false
+	}
+	for name, want := range q.Attrs {
+		v, ok := u.Attrs[name]
+		if !ok || !MatchAttr(v, want) {
+			return false
+		}
+	}
+	return true
+}
+
+type AttrStore interface {
+	// PutAttr создаёт или заменяет описание атрибута
+	PutAttr(ctx context.Context, def AttrDef) error
+	DeleteAttr(ctx context.Context, name string) error
+	ListAttrs(ctx context.Context) ([]AttrDef, error)
+}
+
+// Schema - реестр атрибутов пользователей, который ведёт администратор.
+// Users проверяет по нему атрибуты при создании и изменении.
+type Schema struct {
+	store  AttrStore
+	users  UserStore
+	outbox *Outbox
+}
+
+func NewSchema(store AttrStore, users UserStore, outbox *Outbox) *Schema {
+	return &Schema{
+		store:  store,
+		users:  users,
+		outbox: outbox,
+	}
+}
+
+func (s *Schema) List(ctx context.Context) ([]AttrDef, error) {
+	defs, err := s.store.ListAttrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list attributes error: %w", err)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs, nil
+}
+
+// Define добавляет атрибут или меняет его ограничения. Тип существующего
+// атрибута не меняется. Новые ограничения проверяются при следующем
+// изменении пользователя, кроме уникальности: хранилище строит по атрибуту
+// уникальный индекс и отвечает Conflict, если значения уже повторяются.
+func (s *Schema) Define(ctx context.Context, def AttrDef) (*AttrDef, error) {
+	if err := def.validate(); err != nil {
+		return nil, err
+	}
+	err := s.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		defs, err := s.defs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ev := Event{Type: EventAttrDefined, After: def}
+		if old, ok := defs[def.Name]; ok {
+			if old.Type != def.Type {
+				return nil, Conflict("type of attribute %s cannot be changed", def.Name)
+			}
+			ev.Before = old
+		}
+		if err := s.store.PutAttr(ctx, def); err != nil {
+			return nil, err
+		}
+		return []Event{ev}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("define attribute error: %w", err)
+	}
+	return &def, nil
+}
+
+// Remove удаляет атрибут, если он не задан ни у одного пользователя.
+// Схема общая, поэтому пользователи проверяются во всех арендаторах.
+func (s *Schema) Remove(ctx context.Context, name string) error {
+	err := s.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		defs, err := s.defs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		def, ok := defs[name]
+		if !ok {
+			return nil, NotFound("attribute %s not found", name)
+		}
+		us, err := allUsers(AllTenants(ctx), s.users, UserQuery{})
+		if err != nil {
+			return nil, err
+		}
+		used := false
+		for _, u := range us {
+			if _, ok := u.Attrs[name]; ok {
+				used = true
+			}
+		}
+		if used {
+			return nil, Conflict("attribute %s is in use", name)
+		}
+		if err := s.store.DeleteAttr(ctx, name); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventAttrRemoved, Before: def}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("remove attribute error: %w", err)
+	}
+	return nil
+}
+
+func (s *Schema) defs(ctx context.Context) (map[string]AttrDef, error) {
+	list, err := s.store.ListAttrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defs := make(map[string]AttrDef, len(list))
+	for _, def := range list {
+		defs[def.Name] = def
+	}
+	return defs, nil
+}
+
+// check приводит атрибуты u к типам схемы и проверяет ограничения.
+// Уникальность проверяет хранилище своим индексом при записи
+// (AttrTaken), в том числе при восстановлении пользователя.
+func (s *Schema) check(ctx context.Context, u *User) error {
+	defs, err := s.defs(ctx)
+	if err != nil {
+		return err
+	}
+	attrs, err := normalizeAttrs(defs, u.Attrs)
+	if err != nil {
+		return err
+	}
+	u.Attrs = attrs
+	return nil
+}
+
+// checkMany проверяет атрибуты многих пользователей разом: существующие
+// уникальные значения читаются один раз, а не для каждого пользователя.
+// Возвращает ошибку проверки для каждого пользователя, уникальность
+// проверяется и между самими us.
+func (s *Schema) checkMany(ctx context.Context, us []User) ([]error, error) {
+	defs, err := s.defs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// имя атрибута -> значение -> пользователь
+	taken := make(map[string]map[string]uuid.UUID)
+	for name, def := range defs {
+		if def.Unique {
+			taken[name] = make(map[string]uuid.UUID)
+		}
+	}
+	if len(taken) > 0 {
+		existing, err := allUsers(ctx, s.users, UserQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range existing {
+			for name, vals := range taken {
+				if v, ok := u.Attrs[name]; ok {
+					vals[FormatAttr(v)] = u.ID
+				}
+			}
+		}
+	}
+
+	errs := make([]error, len(us))
+	for i := range us {
+		attrs, err := normalizeAttrs(defs, us[i].Attrs)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		for name, vals := range taken {
+			v, ok := attrs[name]
+			if !ok {
+				continue
+			}
+			if id, ok := vals[FormatAttr(v)]; ok && id != us[i].ID {
+				errs[i] = AttrTaken(name)
+				break
+			}
+		}
+		if errs[i] != nil {
+			continue
+		}
+		for name, vals := range taken {
+			if v, ok := attrs[name]; ok {
+				vals[FormatAttr(v)] = us[i].ID
+			}
+		}
+		us[i].Attrs = attrs
+	}
+	return errs, nil
+}
+
+// AttrTaken - значение уникального атрибута name уже занято
+// другим пользователем арендатора
+func AttrTaken(name string) error {
+	return &Error{
+		Code:    CodeAlreadyExists,
+		Message: "attribute value already taken",
+		Fields:  map[string]string{"attrs." + name: "must be unique"},
+	}
+}
+
+// normalizeAttrs приводит атрибуты к типам схемы и проверяет
+// все ограничения, кроме уникальности
+func normalizeAttrs(defs map[string]AttrDef, in Attributes) (Attributes, error) {
+	fields := map[string]string{}
+	attrs := make(Attributes, len(in))
+	for name, v := range in {
+		def, ok := defs[name]
+		if !ok {
+			fields["attrs."+name] = "unknown attribute"
+			continue
+		}
+		nv, err := def.normalize(v)
+		if err != nil {
+			fields["attrs."+name] = err.Error()
+			continue
+		}
+		attrs[name] = nv
+	}
+	for name, def := range defs {
+		if !def.Required {
+			continue
+		}
+		switch v := attrs[name].(type) {
+		case nil:
+			fields["attrs."+name] = "is required"
+		case string:
+			if v == "" {
+				fields["attrs."+name] = "is required"
+			}
+		case []string:
+			if len(v) == 0 {
+				fields["attrs."+name] = "is required"
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return nil, Invalid(fields)
+	}
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+	return attrs, nil
+}
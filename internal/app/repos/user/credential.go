@@ -0,0 +1,249 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credential - пароль пользователя, хранится только хеш bcrypt
+type Credential struct {
+	UserID    uuid.UUID
+	Hash      []byte
+	UpdatedAt time.Time
+}
+
+type CredentialStore interface {
+	// PutCredential создаёт или заменяет пароль пользователя
+	PutCredential(ctx context.Context, c Credential) error
+	ReadCredential(ctx context.Context, uid uuid.UUID) (*Credential, error)
+}
+
+// PasswordPolicy - требования к новым паролям
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength не больше 72: bcrypt не учитывает байты дальше
+	MaxLength int
+	// breached - SHA-1 утёкших паролей в hex
+	breached map[string]struct{}
+}
+
+// DefaultPasswordPolicy - политика, если другая не задана
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 10, MaxLength: 72}
+
+// LoadBreached читает список утёкших паролей: по одному в строке, открытым
+// текстом или SHA-1 в hex, как в выгрузке Have I Been Pwned (HASH:count)
+func (p *PasswordPolicy) LoadBreached(r io.Reader) error {
+	if p.breached == nil {
+		p.breached = make(map[string]struct{})
+	}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if h := strings.SplitN(line, ":", 2)[0]; isSHA1(h) {
+			p.breached[strings.ToLower(h)] = struct{}{}
+			continue
+		}
+		p.breached[sha1Hex(line)] = struct{}{}
+	}
+	return sc.Err()
+}
+
+func isSHA1(s string) bool {
+	if len(s) != 2*sha1.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check проверяет новый пароль пользователя u
+func (p PasswordPolicy) Check(u User, password string) error {
+	n := utf8.RuneCountInString(password)
+	switch {
+	case n < p.MinLength:
+		return InvalidField("password", "must be at least %d characters", p.MinLength)
+	case p.MaxLength > 0 && len(password) > p.MaxLength:
+		return InvalidField("password", "must be at most %d bytes", p.MaxLength)
+	case strings.EqualFold(password, u.Name):
+		return InvalidField("password", "must differ from the user name")
+	}
+	if _, ok := p.breached[sha1Hex(password)]; ok {
+		return InvalidField("password", "is known to be breached")
+	}
+	return nil
+}
+
+// Credentials управляет паролями пользователей и проверяет их при входе
+type Credentials struct {
+	store  CredentialStore
+	users  UserStore
+	outbox *Outbox
+	policy PasswordPolicy
+	cost   int
+	// dummy сравнивается с паролем, когда пользователя нет,
+	// чтобы время ответа не выдавало, существует ли он
+	dummy []byte
+}
+
+// NewCredentials создаёт хранилище паролей, cost - сложность bcrypt,
+// 0 - bcrypt.DefaultCost
+func NewCredentials(store CredentialStore, users UserStore, outbox *Outbox, policy PasswordPolicy, cost int) *Credentials {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	dummy, _ := bcrypt.GenerateFromPassword([]byte(uuid.NewString()), cost)
+	return &Credentials{
+		store:  store,
+		users:  users,
+		outbox: outbox,
+		policy: policy,
+		cost:   cost,
+		dummy:  dummy,
+	}
+}
+
+// SetPassword задаёт пароль пользователю uid, старый пароль не нужен
+func (cs *Credentials) SetPassword(ctx context.Context, uid uuid.UUID, password string) error {
+	u, err := cs.users.ReadUser(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("set password error: %w", err)
+	}
+	if err := cs.policy.Check(*u, password); err != nil {
+		return err
+	}
+	if err := cs.put(ctx, *u, password); err != nil {
+		return fmt.Errorf("set password error: %w", err)
+	}
+	return nil
+}
+
+// ChangePassword меняет пароль, если old совпадает с текущим
+func (cs *Credentials) ChangePassword(ctx context.Context, uid uuid.UUID, old, password string) error {
+	u, err := cs.users.ReadUser(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("change password error: %w", err)
+	}
+	if err := cs.verify(ctx, uid, old); err != nil {
+		return err
+	}
+	if err := cs.policy.Check(*u, password); err != nil {
+		return err
+	}
+	if err := cs.put(ctx, *u, password); err != nil {
+		return fmt.Errorf("change password error: %w", err)
+	}
+	return nil
+}
+
+func (cs *Credentials) put(ctx context.Context, u User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cs.cost)
+	if err != nil {
+		return err
+	}
+	return cs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		// пользователь мог быть удалён, пока считался хеш
+		if _, err := cs.users.ReadUser(ctx, u.ID); err != nil {
+			return nil, err
+		}
+		err := cs.store.PutCredential(ctx, Credential{
+			UserID:    u.ID,
+			Hash:      hash,
+			UpdatedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventPasswordChanged, UserID: u.ID}}, nil
+	})
+}
+
+// verify сравнивает пароль с сохранённым, ErrUnauthorized при несовпадении,
+// ErrNoPassword, если пароля нет
+func (cs *Credentials) verify(ctx context.Context, uid uuid.UUID, password string) error {
+	hash := cs.dummy
+	c, err := cs.store.ReadCredential(ctx, uid)
+	switch {
+	case err == nil:
+		hash = c.Hash
+	case !errors.Is(err, ErrNotFound):
+		return fmt.Errorf("read credential error: %w", err)
+	}
+	// хеш сравнивается и без пароля, чтобы время ответа было тем же
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || c == nil {
+		if c == nil {
+			return ErrNoPassword
+		}
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// Authenticate находит пользователя по ID или имени входа и проверяет
+// пароль. Если без арендатора в ctx имя есть в нескольких арендаторах,
+// вход по имени невозможен, нужно указать арендатора или войти по ID.
+func (cs *Credentials) Authenticate(ctx context.Context, login, password string) (*User, error) {
+	var u *User
+	var err error
+	if id, perr := uuid.Parse(login); perr == nil {
+		u, err = cs.users.ReadUser(ctx, id)
+	} else {
+		u, err = cs.users.FindUserByName(ctx, NormalizeName(login))
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrConflict) {
+		return nil, fmt.Errorf("authenticate error: %w", err)
+	}
+
+	var uid uuid.UUID
+	if u != nil {
+		uid = u.ID
+	}
+	if err := cs.verify(ctx, uid, password); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Bootstrap создаёт в арендаторе по умолчанию пользователя name со всеми
+// правами, если пользователя с таким именем нет, и задаёт ему пароль.
+// Пароль задаёт оператор при запуске, поэтому политика к нему не применяется.
+func (cs *Credentials) Bootstrap(ctx context.Context, us *Users, name, password string) (*User, error) {
+	ctx = WithTenant(ctx, DefaultTenant)
+	var u *User
+	err := cs.outbox.tx.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		u, err = cs.users.FindUserByName(ctx, NormalizeName(name))
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if u == nil {
+			u, err = us.Create(ctx, User{Name: name, Permissions: PermAll})
+			if err != nil {
+				return err
+			}
+		}
+		return cs.put(ctx, *u, password)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bootstrap error: %w", err)
+	}
+	return u, nil
+}
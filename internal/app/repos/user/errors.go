@@ -0,0 +1,108 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrorCode - машиночитаемый вид ошибки предметной области
+type ErrorCode string
+
+const (
+	CodeNotFound      ErrorCode = "not_found"
+	CodeAlreadyExists ErrorCode = "already_exists"
+	CodeConflict      ErrorCode = "conflict"
+	CodeInvalid       ErrorCode = "invalid"
+	CodeForbidden     ErrorCode = "forbidden"
+	// CodeUnauthorized - неверные или отсутствующие учётные данные
+	CodeUnauthorized ErrorCode = "unauthorized"
+)
+
+// Error - ошибка предметной области. Хранилища переводят в неё свои ошибки,
+// чтобы верхние слои не зависели от конкретной базы.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	// Fields - ошибки по полям, заполняется для CodeInvalid
+	Fields map[string]string
+	Err    error
+}
+
+// Общие ошибки, с ними сравнивают через errors.Is:
+// errors.Is(err, ErrNotFound) верно для любой ошибки с CodeNotFound
+var (
+	ErrNotFound      = &Error{Code: CodeNotFound}
+	ErrAlreadyExists = &Error{Code: CodeAlreadyExists}
+	ErrConflict      = &Error{Code: CodeConflict}
+	ErrInvalid       = &Error{Code: CodeInvalid}
+	ErrForbidden     = &Error{Code: CodeForbidden}
+	// ErrUnauthorized не уточняет причину, чтобы по ответу нельзя было
+	// узнать, есть ли такой пользователь
+	ErrUnauthorized = &Error{Code: CodeUnauthorized, Message: "invalid credentials"}
+	// ErrNoPassword - такого пользователя нет или у него нет пароля
+	// в хранилище. Клиенту отвечают так же, как на ErrUnauthorized,
+	// а другие способы входа ещё могут опознать пользователя.
+	ErrNoPassword = fmt.Errorf("%w: no stored password", ErrUnauthorized)
+)
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = strings.ReplaceAll(string(e.Code), "_", " ")
+	}
+	if len(e.Fields) > 0 {
+		names := make([]string, 0, len(e.Fields))
+		for name := range e.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = name + ": " + e.Fields[name]
+		}
+		msg += " (" + strings.Join(names, ", ") + ")"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is сравнивает код ошибки, а если у target задано сообщение - и его
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+func NotFound(format string, args ...interface{}) error {
+	return &Error{Code: CodeNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+func AlreadyExists(format string, args ...interface{}) error {
+	return &Error{Code: CodeAlreadyExists, Message: fmt.Sprintf(format, args...)}
+}
+
+func Conflict(format string, args ...interface{}) error {
+	return &Error{Code: CodeConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+func Forbidden(format string, args ...interface{}) error {
+	return &Error{Code: CodeForbidden, Message: fmt.Sprintf(format, args...)}
+}
+
+// Invalid - ошибка валидации, fields - описание ошибки для каждого поля
+func Invalid(fields map[string]string) error {
+	return &Error{Code: CodeInvalid, Message: "validation failed", Fields: fields}
+}
+
+// InvalidField - ошибка валидации одного поля
+func InvalidField(field, format string, args ...interface{}) error {
+	return Invalid(map[string]string{field: fmt.Sprintf(format, args...)})
+}
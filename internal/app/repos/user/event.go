@@ -0,0 +1,216 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gb-backend2/internal/app/repos/audit"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	EventUserCreated            EventType = "user.created"
+	EventUserUpdated            EventType = "user.updated"
+	EventUserDeleted            EventType = "user.deleted"
+	EventUserRestored           EventType = "user.restored"
+	EventUserPermissionsChanged EventType = "user.permissions_changed"
+	// EventUserStateChanged - переход в другое состояние жизненного цикла
+	EventUserStateChanged EventType = "user.state_changed"
+	// EventPasswordChanged - пароль задан или изменён, сам пароль и хеш
+	// в событие не попадают
+	EventPasswordChanged EventType = "user.password_changed"
+	// EventUsersPurged - окончательное удаление пользователей по сроку хранения
+	EventUsersPurged EventType = "users.purged"
+
+	EventGroupCreated            EventType = "group.created"
+	EventGroupUpdated            EventType = "group.updated"
+	EventGroupDeleted            EventType = "group.deleted"
+	EventGroupRestored           EventType = "group.restored"
+	EventGroupPermissionsChanged EventType = "group.permissions_changed"
+	EventGroupsPurged            EventType = "groups.purged"
+
+	EventMembershipAdded   EventType = "membership.added"
+	EventMembershipRemoved EventType = "membership.removed"
+	// EventMembershipRoleChanged - смена роли пользователя в группе
+	EventMembershipRoleChanged EventType = "membership.role_changed"
+	// EventMembershipExtended - изменение срока членства
+	EventMembershipExtended EventType = "membership.extended"
+	EventGroupNested        EventType = "group.nested"
+	EventGroupUnnested      EventType = "group.unnested"
+
+	// EventSessionStarted и EventSessionRevoked - вход и отзыв сеанса
+	EventSessionStarted EventType = "session.started"
+	EventSessionRevoked EventType = "session.revoked"
+	// EventAPIKeyCreated и EventAPIKeyRevoked - выпуск и отзыв API-ключа
+	EventAPIKeyCreated EventType = "apikey.created"
+	EventAPIKeyRevoked EventType = "apikey.revoked"
+	// EventInvitationCreated и следующие - приглашения в группы, по ним
+	// рассылаются уведомления. Токен приглашения в события не попадает.
+	EventInvitationCreated  EventType = "invitation.created"
+	EventInvitationAccepted EventType = "invitation.accepted"
+	EventInvitationDeclined EventType = "invitation.declined"
+	EventInvitationRevoked  EventType = "invitation.revoked"
+	// EventJoinRequestSubmitted и следующие - заявки на вступление в группы.
+	// EventJoinRequestApprovalAdded - одобрение, которого ещё не достаточно.
+	EventJoinRequestSubmitted     EventType = "join_request.submitted"
+	EventJoinRequestApprovalAdded EventType = "join_request.approval_added"
+	EventJoinRequestApproved      EventType = "join_request.approved"
+	EventJoinRequestRejected      EventType = "join_request.rejected"
+	// EventReviewStarted и следующие - кампании пересмотра доступа.
+	// EventReviewEscalated - срок прошёл, нерешённые пункты переданы выше.
+	EventReviewStarted   EventType = "review.started"
+	EventReviewDecided   EventType = "review.decided"
+	EventReviewEscalated EventType = "review.escalated"
+	EventReviewClosed    EventType = "review.closed"
+
+	// EventAttrDefined и EventAttrRemoved - изменения схемы атрибутов
+	EventAttrDefined EventType = "schema.attr_defined"
+	EventAttrRemoved EventType = "schema.attr_removed"
+)
+
+// Event - доменное событие. Seq назначает хранилище при записи в outbox,
+// номера растут монотонно, по ним потребители продолжают чтение.
+type Event struct {
+	Seq   uint64
+	Type  EventType
+	At    time.Time
+	Actor uuid.UUID
+	// Tenant - арендатор, в котором произошло изменение
+	Tenant  string
+	UserID  uuid.UUID
+	GroupID uuid.UUID
+	// ParentID - родительская группа для EventGroupNested и EventGroupUnnested
+	ParentID uuid.UUID
+	// Before и After - состояние до и после изменения для журнала аудита
+	Before interface{}
+	After  interface{}
+}
+
+// Purge - снимок окончательного удаления по сроку хранения для журнала аудита
+type Purge struct {
+	DeletedBefore time.Time `json:"deleted_before"`
+	Count         int       `json:"count"`
+}
+
+// Membership - снимок связи пользователя или дочерней группы с группой
+// для журнала аудита
+type Membership struct {
+	UserID   uuid.UUID `json:"user_id"`
+	GroupID  uuid.UUID `json:"group_id"`
+	ParentID uuid.UUID `json:"parent_id"`
+	Role     Role      `json:"role,omitempty"`
+	// ExpiresAt - когда членство закончится, nil - бессрочно
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type EventStore interface {
+	// AppendEvents назначает событиям номера и сохраняет их в outbox
+	AppendEvents(ctx context.Context, evs ...Event) error
+	// ReadEvents возвращает до limit событий с номером больше after
+	ReadEvents(ctx context.Context, after uint64, limit int) ([]Event, error)
+	// DeleteEvents удаляет из outbox события с номером не больше upTo
+	DeleteEvents(ctx context.Context, upTo uint64) error
+}
+
+// Transactor выполняет fn в одной транзакции хранилища
+type Transactor interface {
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Outbox записывает события в хранилище, а для каждого события - запись
+// в журнал аудита, в той же транзакции, что и изменение, которое их породило
+type Outbox struct {
+	store EventStore
+	tx    Transactor
+	audit *audit.Log
+}
+
+// NewOutbox создаёт outbox, al может быть nil, тогда аудит не ведётся
+func NewOutbox(store EventStore, tx Transactor, al *audit.Log) *Outbox {
+	return &Outbox{
+		store: store,
+		tx:    tx,
+		audit: al,
+	}
+}
+
+// Do выполняет fn и записывает возвращённые ей события атомарно с её изменениями
+func (o *Outbox) Do(ctx context.Context, fn func(ctx context.Context) ([]Event, error)) error {
+	return o.tx.RunInTx(ctx, func(ctx context.Context) error {
+		evs, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		if len(evs) == 0 {
+			return nil
+		}
+		var actor User
+		if p, ok := PrincipalFromContext(ctx); ok {
+			actor = *p
+		}
+		now := time.Now()
+		tenant, _ := TenantFromContext(ctx)
+		for i := range evs {
+			evs[i].At = now
+			evs[i].Actor = actor.ID
+			if evs[i].Tenant == "" {
+				evs[i].Tenant = tenant
+			}
+		}
+		if err := o.store.AppendEvents(ctx, evs...); err != nil {
+			return fmt.Errorf("append events error: %w", err)
+		}
+		if o.audit == nil {
+			return nil
+		}
+		for _, ev := range evs {
+			e := audit.Entry{
+				At:        ev.At,
+				Actor:     actor.ID,
+				ActorName: actor.Name,
+				Tenant:    ev.Tenant,
+				Action:    string(ev.Type),
+				UserID:    ev.UserID,
+				GroupID:   ev.GroupID,
+				Before:    snapshot(ev.Before),
+				After:     snapshot(ev.After),
+			}
+			if err := o.audit.Record(ctx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func snapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (o *Outbox) Read(ctx context.Context, after uint64, limit int) ([]Event, error) {
+	evs, err := o.store.ReadEvents(ctx, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("read events error: %w", err)
+	}
+	return evs, nil
+}
+
+// Trim удаляет из outbox события, уже доставленные всем получателям
+func (o *Outbox) Trim(ctx context.Context, upTo uint64) error {
+	if err := o.store.DeleteEvents(ctx, upTo); err != nil {
+		return fmt.Errorf("delete events error: %w", err)
+	}
+	return nil
+}
@@ -3,39 +3,102 @@ package user
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Group struct {
-	ID   uuid.UUID
+	ID uuid.UUID
+	// Tenant - арендатор, задаётся при создании и не меняется
+	Tenant string
+	// Name уникально в арендаторе без учёта регистра
 	Name string
+	// Permissions получают все участники группы
+	Permissions Permissions
+	// JoinPolicy и Approvals - как пользователи вступают в группу сами
+	// и сколько одобрений нужно их заявке
+	JoinPolicy JoinPolicy
+	Approvals  int
+	// Rule - правило динамической группы (см. ParseRule), пустое у обычных
+	Rule      string
+	CreatedAt time.Time
+	// Version растёт при каждом изменении записи и проверяется при записи
+	Version int
+	// DeletedAt не нулевой у удалённых групп, такие записи
+	// не видны через Read и Search до восстановления или очистки
+	DeletedAt time.Time
+	DeletedBy uuid.UUID
 }
 
 type GroupStore interface {
 	CreateGroup(ctx context.Context, g Group) (*uuid.UUID, error)
 	ReadGroup(ctx context.Context, gid uuid.UUID) (*Group, error)
-	DeleteGroup(ctx context.Context, gid uuid.UUID) error
-	SearchGroups(ctx context.Context, s string) (chan Group, error)
+	UpdateGroup(ctx context.Context, g Group) (*Group, error)
+	// DeleteGroup помечает группу удалённой, её участники сохраняются
+	DeleteGroup(ctx context.Context, gid uuid.UUID, by uuid.UUID, at time.Time) error
+	// SearchGroups возвращает страницу выдачи, p уже проверена репозиторием
+	SearchGroups(ctx context.Context, s string, p Page) (*GroupPage, error)
+	RestoreGroup(ctx context.Context, gid uuid.UUID) (*Group, error)
+	SearchDeletedGroups(ctx context.Context) (chan Group, error)
+	// PurgeGroups окончательно удаляет группы, удалённые раньше before
+	PurgeGroups(ctx context.Context, before time.Time) (int, error)
+	// CountGroups возвращает число неудалённых групп арендатора
+	CountGroups(ctx context.Context, tenant string) (int, error)
+	// FindGroupByName ищет неудалённую группу арендатора из ctx
+	// по уникальному индексу, name уже нормализовано
+	FindGroupByName(ctx context.Context, name string) (*Group, error)
+	// ListDynamicGroups возвращает неудалённые группы арендатора tenant
+	// с правилом
+	ListDynamicGroups(ctx context.Context, tenant string) ([]Group, error)
 }
 
 type Groups struct {
-	store GroupStore
+	store   GroupStore
+	outbox  *Outbox
+	quotas  Quotas
+	dynamic *DynamicGroups
 }
 
-func NewGroups(store GroupStore) *Groups {
+func NewGroups(store GroupStore, outbox *Outbox, quotas Quotas, dynamic *DynamicGroups) *Groups {
 	return &Groups{
-		store: store,
+		store:   store,
+		outbox:  outbox,
+		quotas:  quotas,
+		dynamic: dynamic,
 	}
 }
 
+// Create создаёт группу в арендаторе из ctx, а если его там нет -
+// в арендаторе g.Tenant
 func (gs *Groups) Create(ctx context.Context, g Group) (*Group, error) {
 	g.ID = uuid.New()
-	id, err := gs.store.CreateGroup(ctx, g)
+	g.Version = 1
+	g.CreatedAt = time.Now().UTC()
+	if t, ok := TenantFromContext(ctx); ok {
+		g.Tenant = t
+	}
+	if err := checkGroup(&g); err != nil {
+		return nil, fmt.Errorf("create group error: %w", err)
+	}
+	err := gs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if err := gs.checkGroupQuota(ctx, g.Tenant, 1); err != nil {
+			return nil, err
+		}
+		id, err := gs.store.CreateGroup(ctx, g)
+		if err != nil {
+			return nil, err
+		}
+		g.ID = *id
+		evs, err := gs.dynamic.Recompute(ctx, g)
+		if err != nil {
+			return nil, err
+		}
+		return append([]Event{{Type: EventGroupCreated, GroupID: g.ID, After: g}}, evs...), nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("create group error: %w", err)
 	}
-	g.ID = *id
 	return &g, nil
 }
 
@@ -47,35 +110,116 @@ func (gs *Groups) Read(ctx context.Context, gid uuid.UUID) (*Group, error) {
 	return g, nil
 }
 
+// Update сохраняет g, если g.Version совпадает с версией в хранилище
+func (gs *Groups) Update(ctx context.Context, g Group) (*Group, error) {
+	if err := checkGroup(&g); err != nil {
+		return nil, fmt.Errorf("update group error: %w", err)
+	}
+	var ng *Group
+	err := gs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, err := gs.store.ReadGroup(ctx, g.ID)
+		if err != nil {
+			return nil, err
+		}
+		ng, err = gs.store.UpdateGroup(ctx, g)
+		if err != nil {
+			return nil, err
+		}
+		evs := []Event{{Type: EventGroupUpdated, GroupID: g.ID, Before: *old, After: *ng}}
+		if ng.Rule != old.Rule {
+			devs, err := gs.dynamic.Recompute(ctx, *ng)
+			if err != nil {
+				return nil, err
+			}
+			evs = append(evs, devs...)
+		}
+		return evs, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update group error: %w", err)
+	}
+	return ng, nil
+}
+
 func (gs *Groups) Delete(ctx context.Context, gid uuid.UUID) (*Group, error) {
-	g, err := gs.store.ReadGroup(ctx, gid)
+	var g *Group
+	err := gs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		g, err = gs.store.ReadGroup(ctx, gid)
+		if err != nil {
+			return nil, fmt.Errorf("search group error: %w", err)
+		}
+		var by uuid.UUID
+		if p, ok := PrincipalFromContext(ctx); ok {
+			by = p.ID
+		}
+		if err := gs.store.DeleteGroup(ctx, gid, by, time.Now()); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventGroupDeleted, GroupID: gid, Before: *g}}, nil
+	})
+	return g, err
+}
+
+// Restore возвращает удалённую группу вместе с её участниками
+func (gs *Groups) Restore(ctx context.Context, gid uuid.UUID) (*Group, error) {
+	var g *Group
+	err := gs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		g, err = gs.store.RestoreGroup(ctx, gid)
+		if err != nil {
+			return nil, err
+		}
+		if err := gs.checkGroupQuota(ctx, g.Tenant, 0); err != nil {
+			return nil, err
+		}
+		// пока группа была удалена, пользователи могли измениться
+		evs, err := gs.dynamic.Recompute(ctx, *g)
+		if err != nil {
+			return nil, err
+		}
+		return append([]Event{{Type: EventGroupRestored, GroupID: gid, After: *g}}, evs...), nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("search user error: %w", err)
+		return nil, fmt.Errorf("restore group error: %w", err)
 	}
-	return g, gs.store.DeleteGroup(ctx, gid)
+	return g, nil
 }
 
-func (gs *Groups) SearchGroups(ctx context.Context, s string) (chan Group, error) {
-	// FIXME: здесь нужно использвоать паттерн Unit of Work
-	// бизнес-транзакция
-	chin, err := gs.store.SearchGroups(ctx, s)
+func (gs *Groups) SearchDeleted(ctx context.Context) (chan Group, error) {
+	ch, err := gs.store.SearchDeletedGroups(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("search deleted groups error: %w", err)
 	}
-	chout := make(chan Group, 100)
-	go func() {
-		defer close(chout)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case u, ok := <-chin:
-				if !ok {
-					return
-				}
-				chout <- u
-			}
+	return ch, nil
+}
+
+// Purge окончательно удаляет группы, удалённые раньше before
+func (gs *Groups) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := gs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		n, err = gs.store.PurgeGroups(ctx, before)
+		if err != nil || n == 0 {
+			return nil, err
 		}
-	}()
-	return chout, nil
+		return []Event{{Type: EventGroupsPurged, After: Purge{DeletedBefore: before, Count: n}}}, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge groups error: %w", err)
+	}
+	return n, nil
+}
+
+// SearchGroups возвращает страницу выдачи поиска, упорядоченную по p.Sort
+func (gs *Groups) SearchGroups(ctx context.Context, s string, p Page) (*GroupPage, error) {
+	p, err := p.check()
+	if err != nil {
+		return nil, err
+	}
+	page, err := gs.store.SearchGroups(ctx, s, p)
+	if err != nil {
+		return nil, fmt.Errorf("search groups error: %w", err)
+	}
+	return page, nil
 }
@@ -0,0 +1,166 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxImportRows - наибольшее число пользователей в одном вызове CreateMany
+const MaxImportRows = 10000
+
+// ErrImportRejected возвращается CreateMany в режиме "всё или ничего",
+// если хотя бы одна строка не прошла проверку. Причины - в результатах строк.
+var ErrImportRejected = &Error{Code: CodeInvalid, Message: "import rejected"}
+
+// ImportItem - пользователь для массового создания и группы,
+// в которые его нужно добавить
+type ImportItem struct {
+	User   User
+	Groups []uuid.UUID
+}
+
+// ImportResult - результат одной строки: созданный пользователь или ошибка.
+// В режиме "всё или ничего" у верных строк отклонённого импорта
+// нет ни пользователя, ни ошибки.
+type ImportResult struct {
+	User *User
+	Err  error
+}
+
+// CreateMany создаёт пользователей одной записью в хранилище. Сначала
+// проверяются все строки: атрибуты по схеме, уникальность имён, почты
+// и атрибутов (в том числе между строками) и группы. С atomic при любой ошибке ничего не создаётся
+// и возвращается ErrImportRejected, иначе создаются только верные строки.
+func (us *Users) CreateMany(ctx context.Context, items []ImportItem, atomic bool) ([]ImportResult, error) {
+	if len(items) == 0 {
+		return nil, InvalidField("users", "must not be empty")
+	}
+	if len(items) > MaxImportRows {
+		return nil, InvalidField("users", "at most %d rows allowed", MaxImportRows)
+	}
+
+	res := make([]ImportResult, len(items))
+	rejected := false
+	tenant, scoped := TenantFromContext(ctx)
+	err := us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		now := time.Now().UTC()
+		nus := make([]User, len(items))
+		for i, it := range items {
+			nus[i] = it.User
+			if scoped {
+				nus[i].Tenant = tenant
+			}
+			nus[i].ID = uuid.New()
+			nus[i].Version = 1
+			nus[i].CreatedAt = now
+		}
+
+		errs, err := us.schema.checkMany(ctx, nus)
+		if err != nil {
+			return nil, err
+		}
+		if err := us.checkNames(ctx, nus, errs); err != nil {
+			return nil, err
+		}
+		for i := range nus {
+			if errs[i] == nil {
+				errs[i] = initState(&nus[i])
+			}
+		}
+		type groupRead struct {
+			g   *Group
+			err error
+		}
+		groups := make(map[uuid.UUID]groupRead)
+		for i, it := range items {
+			if errs[i] != nil {
+				continue
+			}
+			for _, gid := range it.Groups {
+				gr, ok := groups[gid]
+				if !ok {
+					gr.g, gr.err = us.groups.ReadGroup(ctx, gid)
+					groups[gid] = gr
+				}
+				gerr := gr.err
+				if gerr == nil {
+					gerr = checkTenants(nus[i].Tenant, gr.g.Tenant)
+				}
+				if gerr == nil && gr.g.Dynamic() {
+					gerr = ErrDynamicGroup
+				}
+				if gerr != nil {
+					errs[i] = InvalidField("groups", "%s", gerr.Error())
+					break
+				}
+			}
+		}
+
+		var ok []User
+		var ms []Membership
+		for i, it := range items {
+			if errs[i] != nil {
+				res[i].Err = errs[i]
+				continue
+			}
+			ok = append(ok, nus[i])
+			seen := make(map[uuid.UUID]bool, len(it.Groups))
+			for _, gid := range it.Groups {
+				if !seen[gid] {
+					seen[gid] = true
+					ms = append(ms, Membership{UserID: nus[i].ID, GroupID: gid, Role: RoleMember})
+				}
+			}
+		}
+		if atomic && len(ok) < len(items) {
+			rejected = true
+			return nil, nil
+		}
+		if len(ok) == 0 {
+			return nil, nil
+		}
+		perTenant := make(map[string]int)
+		for _, u := range ok {
+			perTenant[u.Tenant]++
+		}
+		for t, n := range perTenant {
+			if err := us.checkUserQuota(ctx, t, n); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := us.store.CreateUsers(ctx, ok, ms); err != nil {
+			return nil, err
+		}
+
+		evs := make([]Event, 0, len(ok)+len(ms))
+		for i := range nus {
+			if errs[i] == nil {
+				u := nus[i]
+				res[i].User = &u
+				evs = append(evs, Event{Type: EventUserCreated, UserID: u.ID, After: u})
+			}
+		}
+		for _, m := range ms {
+			evs = append(evs, Event{Type: EventMembershipAdded, UserID: m.UserID, GroupID: m.GroupID, After: m})
+		}
+		for _, u := range ok {
+			devs, err := us.dynamic.Sync(ctx, u)
+			if err != nil {
+				return nil, err
+			}
+			evs = append(evs, devs...)
+		}
+		return evs, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create users error: %w", err)
+	}
+	if rejected {
+		return res, ErrImportRejected
+	}
+	return res, nil
+}
@@ -0,0 +1,365 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultInviteTTL - срок действия приглашения по умолчанию
+const DefaultInviteTTL = 7 * 24 * time.Hour
+
+type InviteStatus string
+
+const (
+	InvitePending  InviteStatus = "pending"
+	InviteAccepted InviteStatus = "accepted"
+	InviteDeclined InviteStatus = "declined"
+	InviteRevoked  InviteStatus = "revoked"
+)
+
+// Invitation - приглашение в группу существующего пользователя (UserID)
+// или адреса почты (Email). Токен приглашения показывается только при
+// создании и действует один раз, хранится SHA-256 его секретной части.
+type Invitation struct {
+	ID        uuid.UUID    `json:"id"`
+	GroupID   uuid.UUID    `json:"group_id"`
+	UserID    *uuid.UUID   `json:"user_id,omitempty"`
+	Email     string       `json:"email,omitempty"`
+	Role      Role         `json:"role"`
+	Hash      []byte       `json:"-"`
+	InvitedBy uuid.UUID    `json:"invited_by"`
+	Status    InviteStatus `json:"status"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	// ResolvedAt и ResolvedBy - когда и кем приглашение принято,
+	// отклонено или отозвано
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy *uuid.UUID `json:"resolved_by,omitempty"`
+}
+
+// Pending сообщает, ждёт ли приглашение ответа к моменту now
+func (inv Invitation) Pending(now time.Time) bool {
+	return inv.Status == InvitePending && now.Before(inv.ExpiresAt)
+}
+
+type InvitationStore interface {
+	CreateInvitation(ctx context.Context, inv Invitation) error
+	ReadInvitation(ctx context.Context, id uuid.UUID) (*Invitation, error)
+	UpdateInvitation(ctx context.Context, inv Invitation) error
+	GroupInvitations(ctx context.Context, gid uuid.UUID) ([]Invitation, error)
+	// DeleteInvitations удаляет приглашения, срок которых истёк раньше before
+	DeleteInvitations(ctx context.Context, before time.Time) (int, error)
+}
+
+// Invitations - приглашения в группы. Принятое приглашение добавляет
+// пользователя в группу через UserGroupMapper.
+type Invitations struct {
+	store  InvitationStore
+	users  UserStore
+	groups GroupStore
+	mapper *UserGroupMapper
+	outbox *Outbox
+	ttl    time.Duration
+}
+
+func NewInvitations(store InvitationStore, users UserStore, groups GroupStore,
+	mapper *UserGroupMapper, outbox *Outbox, ttl time.Duration) *Invitations {
+	if ttl == 0 {
+		ttl = DefaultInviteTTL
+	}
+	return &Invitations{
+		store:  store,
+		users:  users,
+		groups: groups,
+		mapper: mapper,
+		outbox: outbox,
+		ttl:    ttl,
+	}
+}
+
+// Create приглашает в группу inv.GroupID пользователя inv.UserID или адрес
+// inv.Email и возвращает приглашение вместе с токеном, который больше
+// получить нельзя. Нулевой inv.ExpiresAt - срок по умолчанию.
+func (is *Invitations) Create(ctx context.Context, inv Invitation) (*Invitation, string, error) {
+	now := time.Now().UTC()
+	inv.Email = NormalizeEmail(inv.Email)
+	fields := map[string]string{}
+	if (inv.UserID == nil) == (inv.Email == "") {
+		fields["user_id"] = "exactly one of user_id and email is required"
+	}
+	if inv.Email != "" {
+		if a, err := mail.ParseAddress(inv.Email); err != nil || a.Address != inv.Email {
+			fields["email"] = "must be a valid address"
+		}
+	}
+	role, err := ParseRole(string(inv.Role))
+	if err != nil {
+		fields["role"] = "must be one of owner, manager, member"
+	}
+	if inv.ExpiresAt.IsZero() {
+		inv.ExpiresAt = now.Add(is.ttl)
+	} else if !inv.ExpiresAt.After(now) {
+		fields["expires_at"] = "must be in the future"
+	}
+	if len(fields) > 0 {
+		return nil, "", Invalid(fields)
+	}
+
+	secret := newSecret()
+	inv.ID = uuid.New()
+	inv.Role = role
+	inv.Hash = hashSecret(secret)
+	inv.Status = InvitePending
+	inv.CreatedAt = now
+	inv.ResolvedAt, inv.ResolvedBy = nil, nil
+	if p, ok := PrincipalFromContext(ctx); ok {
+		inv.InvitedBy = p.ID
+	}
+	err = is.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		g, err := is.groups.ReadGroup(ctx, inv.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		if g.Dynamic() {
+			return nil, ErrDynamicGroup
+		}
+		ev := Event{Type: EventInvitationCreated, GroupID: g.ID, After: inv}
+		if inv.UserID != nil {
+			u, err := is.users.ReadUser(ctx, *inv.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkTenants(u.Tenant, g.Tenant); err != nil {
+				return nil, err
+			}
+			if _, ok, err := is.mapper.UserRole(ctx, *u, *g); err != nil {
+				return nil, err
+			} else if ok {
+				return nil, Conflict("user is already a member of the group")
+			}
+			ev.UserID = u.ID
+		}
+		if err := is.store.CreateInvitation(ctx, inv); err != nil {
+			return nil, err
+		}
+		return []Event{ev}, nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("create invitation error: %w", err)
+	}
+	return &inv, inv.ID.String() + "." + secret, nil
+}
+
+// Read возвращает приглашение, если его группа видна из ctx
+func (is *Invitations) Read(ctx context.Context, id uuid.UUID) (*Invitation, error) {
+	inv, err := is.store.ReadInvitation(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("read invitation error: %w", err)
+	}
+	if _, err := is.groups.ReadGroup(ctx, inv.GroupID); err != nil {
+		return nil, NotFound("invitation %s not found", id)
+	}
+	return inv, nil
+}
+
+// List возвращает ждущие ответа приглашения группы в порядке создания
+func (is *Invitations) List(ctx context.Context, gid uuid.UUID) ([]Invitation, error) {
+	if _, err := is.groups.ReadGroup(ctx, gid); err != nil {
+		return nil, fmt.Errorf("list invitations error: %w", err)
+	}
+	all, err := is.store.GroupInvitations(ctx, gid)
+	if err != nil {
+		return nil, fmt.Errorf("list invitations error: %w", err)
+	}
+	now := time.Now()
+	var invs []Invitation
+	for _, inv := range all {
+		if inv.Pending(now) {
+			invs = append(invs, inv)
+		}
+	}
+	sort.Slice(invs, func(i, j int) bool {
+		return invs[i].CreatedAt.Before(invs[j].CreatedAt)
+	})
+	return invs, nil
+}
+
+// Accept принимает приглашение от имени пользователя из ctx и добавляет
+// его в группу. Приглашение на адрес почты принимает пользователь
+// с этим подтверждённым адресом.
+func (is *Invitations) Accept(ctx context.Context, token string) (*Invitation, error) {
+	var inv *Invitation
+	err := is.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var u *User
+		var err error
+		inv, u, err = is.redeem(ctx, token, InviteAccepted)
+		if err != nil {
+			return nil, err
+		}
+		g, err := is.groups.ReadGroup(ctx, inv.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		if err := is.checkInviter(ctx, *inv, *g); err != nil {
+			return nil, err
+		}
+		if _, ok, err := is.mapper.UserRole(ctx, *u, *g); err != nil {
+			return nil, err
+		} else if ok {
+			return nil, Conflict("user is already a member of the group")
+		}
+		if err := is.mapper.AddUserToGroup(ctx, *u, *g, inv.Role, time.Time{}); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventInvitationAccepted, UserID: u.ID, GroupID: g.ID, After: *inv}}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation error: %w", err)
+	}
+	return inv, nil
+}
+
+// Decline отклоняет приглашение от имени пользователя из ctx
+func (is *Invitations) Decline(ctx context.Context, token string) (*Invitation, error) {
+	var inv *Invitation
+	err := is.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var u *User
+		var err error
+		inv, u, err = is.redeem(ctx, token, InviteDeclined)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventInvitationDeclined, UserID: u.ID, GroupID: inv.GroupID, After: *inv}}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decline invitation error: %w", err)
+	}
+	return inv, nil
+}
+
+// Revoke отзывает ждущее ответа приглашение, его токен больше не действует
+func (is *Invitations) Revoke(ctx context.Context, id uuid.UUID) (*Invitation, error) {
+	var inv *Invitation
+	err := is.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, err := is.store.ReadInvitation(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !old.Pending(time.Now()) {
+			return nil, Conflict("invitation is not pending")
+		}
+		inv, err = is.resolve(ctx, *old, InviteRevoked)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventInvitationRevoked, GroupID: inv.GroupID, Before: *old, After: *inv}}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("revoke invitation error: %w", err)
+	}
+	return inv, nil
+}
+
+// Purge удаляет приглашения, срок которых истёк раньше before
+func (is *Invitations) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := is.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		n, err = is.store.DeleteInvitations(ctx, before)
+		return nil, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge invitations error: %w", err)
+	}
+	return n, nil
+}
+
+// redeem проверяет токен и того, кто на него отвечает, и закрывает
+// приглашение со статусом status
+func (is *Invitations) redeem(ctx context.Context, token string, status InviteStatus) (*Invitation, *User, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, nil, ErrUnauthorized
+	}
+	id, secret, err := splitToken(token)
+	if err != nil {
+		return nil, nil, InvalidField("token", "malformed token")
+	}
+	inv, err := is.store.ReadInvitation(ctx, id)
+	if errors.Is(err, ErrNotFound) || err == nil && !hmac.Equal(inv.Hash, hashSecret(secret)) {
+		return nil, nil, NotFound("invitation not found")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if inv.Status != InvitePending {
+		return nil, nil, Conflict("invitation is already %s", inv.Status)
+	}
+	if !inv.Pending(time.Now()) {
+		return nil, nil, Conflict("invitation has expired")
+	}
+	if inv.UserID != nil && *inv.UserID != p.ID {
+		return nil, nil, Forbidden("invitation is for another user")
+	}
+	u, err := is.users.ReadUser(ctx, p.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inv.Email != "" && (NormalizeEmail(u.Email) != inv.Email || !u.EmailVerified) {
+		return nil, nil, Forbidden("invitation is for another email address")
+	}
+	inv, err = is.resolve(ctx, *inv, status)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inv, u, nil
+}
+
+// checkInviter проверяет при принятии приглашения, что группа g не даёт
+// прав, которые пригласивший не может раздавать: права группы или самого
+// пригласившего могли измениться после приглашения
+func (is *Invitations) checkInviter(ctx context.Context, inv Invitation, g Group) error {
+	if inv.InvitedBy == uuid.Nil {
+		return nil
+	}
+	perms, err := is.mapper.GroupPermissions(ctx, g)
+	if err != nil || perms == 0 {
+		return err
+	}
+	by, err := is.users.ReadUser(AllTenants(ctx), inv.InvitedBy)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err == nil {
+		// права пригласившего считаются по его арендатору, как у оператора
+		own, err := is.mapper.EffectivePermissions(WithTenant(ctx, by.Tenant), *by)
+		if err != nil {
+			return err
+		}
+		if own.Has(PermGrant) {
+			return nil
+		}
+	}
+	return Forbidden("group %s grants %s, which the inviter can no longer grant", g.ID, perms)
+}
+
+func (is *Invitations) resolve(ctx context.Context, inv Invitation, status InviteStatus) (*Invitation, error) {
+	now := time.Now().UTC()
+	inv.Status = status
+	inv.ResolvedAt = &now
+	if p, ok := PrincipalFromContext(ctx); ok {
+		by := p.ID
+		inv.ResolvedBy = &by
+	}
+	if err := is.store.UpdateInvitation(ctx, inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
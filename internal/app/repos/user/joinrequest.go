@@ -0,0 +1,316 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// JoinPolicy - как пользователи сами вступают в группу
+type JoinPolicy string
+
+const (
+	// JoinClosed - вступить можно только через администратора или приглашение
+	JoinClosed JoinPolicy = "closed"
+	// JoinOpen - заявка сразу делает пользователя участником
+	JoinOpen JoinPolicy = "open"
+	// JoinApproval - заявку одобряют владельцы и менеджеры группы
+	JoinApproval JoinPolicy = "approval"
+)
+
+// MaxApprovals - наибольшее число одобрений, которое может требовать группа
+const MaxApprovals = 2
+
+// maxJustification - наибольшая длина обоснования заявки в символах
+const maxJustification = 1000
+
+type RequestStatus string
+
+const (
+	RequestPending  RequestStatus = "pending"
+	RequestApproved RequestStatus = "approved"
+	RequestRejected RequestStatus = "rejected"
+)
+
+// JoinRequest - заявка пользователя на вступление в группу
+type JoinRequest struct {
+	ID            uuid.UUID     `json:"id"`
+	GroupID       uuid.UUID     `json:"group_id"`
+	UserID        uuid.UUID     `json:"user_id"`
+	Justification string        `json:"justification,omitempty"`
+	Status        RequestStatus `json:"status"`
+	// Approvals - кто уже одобрил заявку, для групп с несколькими одобряющими
+	Approvals []uuid.UUID `json:"approvals,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	// ResolvedAt и ResolvedBy - когда и кем заявка окончательно одобрена
+	// или отклонена
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy *uuid.UUID `json:"resolved_by,omitempty"`
+}
+
+type JoinRequestStore interface {
+	CreateJoinRequest(ctx context.Context, jr JoinRequest) error
+	ReadJoinRequest(ctx context.Context, id uuid.UUID) (*JoinRequest, error)
+	UpdateJoinRequest(ctx context.Context, jr JoinRequest) error
+	// GroupJoinRequests возвращает заявки в группы gids со статусом status
+	GroupJoinRequests(ctx context.Context, gids []uuid.UUID, status RequestStatus) ([]JoinRequest, error)
+}
+
+// checkJoinPolicy проверяет настройки вступления группы,
+// пустые значения - закрытая группа с одним одобряющим
+func checkJoinPolicy(g *Group, fields map[string]string) {
+	switch g.JoinPolicy {
+	case "":
+		g.JoinPolicy = JoinClosed
+	case JoinClosed, JoinOpen, JoinApproval:
+	default:
+		fields["join_policy"] = "must be one of closed, open, approval"
+	}
+	if g.Approvals == 0 {
+		g.Approvals = 1
+	}
+	if g.Approvals < 1 || g.Approvals > MaxApprovals {
+		fields["approvals"] = fmt.Sprintf("must be between 1 and %d", MaxApprovals)
+	}
+}
+
+// JoinRequests - заявки на вступление в группы. Одобренная заявка
+// добавляет пользователя в группу через UserGroupMapper в той же транзакции.
+type JoinRequests struct {
+	store  JoinRequestStore
+	users  UserStore
+	groups GroupStore
+	mapper *UserGroupMapper
+	outbox *Outbox
+}
+
+func NewJoinRequests(store JoinRequestStore, users UserStore, groups GroupStore,
+	mapper *UserGroupMapper, outbox *Outbox) *JoinRequests {
+	return &JoinRequests{
+		store:  store,
+		users:  users,
+		groups: groups,
+		mapper: mapper,
+		outbox: outbox,
+	}
+}
+
+// Submit подаёт заявку пользователя из ctx в группу gid. В открытую группу
+// пользователь вступает сразу, заявка возвращается уже одобренной.
+func (js *JoinRequests) Submit(ctx context.Context, gid uuid.UUID, justification string) (*JoinRequest, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	justification = strings.TrimSpace(justification)
+	if utf8.RuneCountInString(justification) > maxJustification {
+		return nil, InvalidField("justification", "at most %d characters allowed", maxJustification)
+	}
+
+	jr := JoinRequest{
+		ID:            uuid.New(),
+		GroupID:       gid,
+		UserID:        p.ID,
+		Justification: justification,
+		Status:        RequestPending,
+		CreatedAt:     time.Now().UTC(),
+	}
+	err := js.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		g, err := js.groups.ReadGroup(ctx, gid)
+		if err != nil {
+			return nil, err
+		}
+		u, err := js.users.ReadUser(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkTenants(u.Tenant, g.Tenant); err != nil {
+			return nil, err
+		}
+		if _, ok, err := js.mapper.UserRole(ctx, *u, *g); err != nil {
+			return nil, err
+		} else if ok {
+			return nil, Conflict("user is already a member of the group")
+		}
+
+		evs := []Event{{Type: EventJoinRequestSubmitted, UserID: u.ID, GroupID: g.ID, After: jr}}
+		switch g.JoinPolicy {
+		case JoinOpen:
+			if err := js.approve(ctx, &jr, *u, *g, u.ID); err != nil {
+				return nil, err
+			}
+			evs[0].After = jr
+		case JoinApproval:
+			pending, err := js.store.GroupJoinRequests(ctx, []uuid.UUID{g.ID}, RequestPending)
+			if err != nil {
+				return nil, err
+			}
+			for _, other := range pending {
+				if other.UserID == u.ID {
+					return nil, Conflict("join request %s is already pending", other.ID)
+				}
+			}
+		default:
+			return nil, Forbidden("group is closed")
+		}
+		if err := js.store.CreateJoinRequest(ctx, jr); err != nil {
+			return nil, err
+		}
+		return evs, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("submit join request error: %w", err)
+	}
+	return &jr, nil
+}
+
+// Read возвращает заявку, если её группа видна из ctx
+func (js *JoinRequests) Read(ctx context.Context, id uuid.UUID) (*JoinRequest, error) {
+	jr, err := js.store.ReadJoinRequest(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("read join request error: %w", err)
+	}
+	if _, err := js.groups.ReadGroup(ctx, jr.GroupID); err != nil {
+		return nil, NotFound("join request %s not found", id)
+	}
+	return jr, nil
+}
+
+// Pending возвращает ждущие решения заявки в группы gids в порядке подачи
+func (js *JoinRequests) Pending(ctx context.Context, gids []uuid.UUID) ([]JoinRequest, error) {
+	jrs, err := js.store.GroupJoinRequests(ctx, gids, RequestPending)
+	if err != nil {
+		return nil, fmt.Errorf("list join requests error: %w", err)
+	}
+	sort.Slice(jrs, func(i, j int) bool {
+		return jrs[i].CreatedAt.Before(jrs[j].CreatedAt)
+	})
+	return jrs, nil
+}
+
+// Managed возвращает группы, которыми u управляет как владелец или менеджер
+func (js *JoinRequests) Managed(ctx context.Context, u User) ([]uuid.UUID, error) {
+	ch, err := js.mapper.GetUserGroups(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	var gids []uuid.UUID
+	for m := range ch {
+		if m.Role.Manages() {
+			gids = append(gids, m.Group.ID)
+		}
+	}
+	return gids, nil
+}
+
+// Approve записывает одобрение пользователя из ctx. Когда одобрений
+// набирается столько, сколько требует группа, заявитель становится
+// участником группы. Свою заявку одобрить нельзя, как и одобрить дважды.
+func (js *JoinRequests) Approve(ctx context.Context, id uuid.UUID) (*JoinRequest, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	var jr *JoinRequest
+	err := js.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, g, err := js.pending(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if old.UserID == p.ID {
+			return nil, Forbidden("cannot approve own join request")
+		}
+		for _, a := range old.Approvals {
+			if a == p.ID {
+				return nil, Conflict("join request is already approved by this user")
+			}
+		}
+		u, err := js.users.ReadUser(ctx, old.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		nj := *old
+		nj.Approvals = append(append([]uuid.UUID(nil), old.Approvals...), p.ID)
+		ev := Event{Type: EventJoinRequestApprovalAdded, UserID: u.ID, GroupID: g.ID, Before: *old}
+		if len(nj.Approvals) >= g.Approvals {
+			if err := js.approve(ctx, &nj, *u, *g, p.ID); err != nil {
+				return nil, err
+			}
+			ev.Type = EventJoinRequestApproved
+		}
+		if err := js.store.UpdateJoinRequest(ctx, nj); err != nil {
+			return nil, err
+		}
+		ev.After = nj
+		jr = &nj
+		return []Event{ev}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("approve join request error: %w", err)
+	}
+	return jr, nil
+}
+
+// Reject отклоняет заявку, для этого достаточно одного решения
+func (js *JoinRequests) Reject(ctx context.Context, id uuid.UUID) (*JoinRequest, error) {
+	var jr *JoinRequest
+	err := js.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, _, err := js.pending(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		nj := *old
+		resolveRequest(ctx, &nj, RequestRejected)
+		if err := js.store.UpdateJoinRequest(ctx, nj); err != nil {
+			return nil, err
+		}
+		jr = &nj
+		return []Event{{Type: EventJoinRequestRejected, UserID: nj.UserID, GroupID: nj.GroupID, Before: *old, After: nj}}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reject join request error: %w", err)
+	}
+	return jr, nil
+}
+
+// pending читает ждущую решения заявку и её группу
+func (js *JoinRequests) pending(ctx context.Context, id uuid.UUID) (*JoinRequest, *Group, error) {
+	jr, err := js.store.ReadJoinRequest(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	g, err := js.groups.ReadGroup(ctx, jr.GroupID)
+	if err != nil {
+		return nil, nil, NotFound("join request %s not found", id)
+	}
+	if jr.Status != RequestPending {
+		return nil, nil, Conflict("join request is already %s", jr.Status)
+	}
+	return jr, g, nil
+}
+
+// approve одобряет заявку и добавляет заявителя в группу
+func (js *JoinRequests) approve(ctx context.Context, jr *JoinRequest, u User, g Group, by uuid.UUID) error {
+	if err := js.mapper.AddUserToGroup(ctx, u, g, RoleMember, time.Time{}); err != nil {
+		return err
+	}
+	resolveRequest(ctx, jr, RequestApproved)
+	jr.ResolvedBy = &by
+	return nil
+}
+
+func resolveRequest(ctx context.Context, jr *JoinRequest, status RequestStatus) {
+	now := time.Now().UTC()
+	jr.Status = status
+	jr.ResolvedAt = &now
+	if p, ok := PrincipalFromContext(ctx); ok {
+		by := p.ID
+		jr.ResolvedBy = &by
+	}
+}
@@ -0,0 +1,159 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+// Имена пользователей и групп, как и адреса почты, уникальны в арендаторе
+// без учёта регистра. Хранилища держат для них уникальные индексы
+// и отвечают ErrNameTaken / ErrEmailTaken при повторе.
+var (
+	ErrNameTaken = &Error{
+		Code:    CodeAlreadyExists,
+		Message: "name already taken",
+		Fields:  map[string]string{"name": "must be unique"},
+	}
+	ErrEmailTaken = &Error{
+		Code:    CodeAlreadyExists,
+		Message: "email already taken",
+		Fields:  map[string]string{"email": "must be unique"},
+	}
+)
+
+// NormalizeName приводит имя входа к виду, в котором оно хранится
+// и сравнивается: без пробелов по краям, в нижнем регистре
+func NormalizeName(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// NormalizeEmail приводит адрес почты к виду, в котором он хранится
+func NormalizeEmail(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// normalizeUser приводит имя и почту u к хранимому виду и проверяет их
+func normalizeUser(u *User) map[string]string {
+	fields := map[string]string{}
+	u.Name = NormalizeName(u.Name)
+	if u.Name == "" {
+		fields["name"] = "must not be empty"
+	}
+	u.Email = NormalizeEmail(u.Email)
+	if u.Email != "" {
+		if a, err := mail.ParseAddress(u.Email); err != nil || a.Address != u.Email {
+			fields["email"] = "must be a valid address"
+		}
+	}
+	if u.Email == "" {
+		u.EmailVerified = false
+	}
+	return fields
+}
+
+func checkUser(u *User) error {
+	if fields := normalizeUser(u); len(fields) > 0 {
+		return Invalid(fields)
+	}
+	return nil
+}
+
+// checkGroup проверяет имя, настройки вступления и правило группы.
+// Имя хранится как задано, а уникально в виде NormalizeName.
+func checkGroup(g *Group) error {
+	fields := map[string]string{}
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" {
+		fields["name"] = "must not be empty"
+	}
+	checkJoinPolicy(g, fields)
+	checkRule(g, fields)
+	if len(fields) > 0 {
+		return Invalid(fields)
+	}
+	return nil
+}
+
+// FindByName находит пользователя по имени входа. Без арендатора в ctx
+// имя ищется во всех арендаторах и может оказаться неоднозначным (Conflict).
+func (us *Users) FindByName(ctx context.Context, name string) (*User, error) {
+	u, err := us.store.FindUserByName(ctx, NormalizeName(name))
+	if err != nil {
+		return nil, fmt.Errorf("find user error: %w", err)
+	}
+	return u, nil
+}
+
+// FindByEmail находит пользователя по адресу почты
+func (us *Users) FindByEmail(ctx context.Context, email string) (*User, error) {
+	u, err := us.store.FindUserByEmail(ctx, NormalizeEmail(email))
+	if err != nil {
+		return nil, fmt.Errorf("find user error: %w", err)
+	}
+	return u, nil
+}
+
+// FindByName находит группу арендатора из ctx по имени без учёта регистра
+func (gs *Groups) FindByName(ctx context.Context, name string) (*Group, error) {
+	g, err := gs.store.FindGroupByName(ctx, NormalizeName(name))
+	if err != nil {
+		return nil, fmt.Errorf("find group error: %w", err)
+	}
+	return g, nil
+}
+
+// checkNames проверяет имена и почту пользователей массового создания:
+// формат, занятость в хранилище и повторы между самими us. Ошибки
+// записываются в errs строк, которые ещё не отклонены.
+func (us *Users) checkNames(ctx context.Context, nus []User, errs []error) error {
+	type key struct {
+		tenant, value string
+	}
+	names := make(map[key]bool)
+	emails := make(map[key]bool)
+	for i := range nus {
+		if errs[i] != nil {
+			continue
+		}
+		u := &nus[i]
+		if fields := normalizeUser(u); len(fields) > 0 {
+			errs[i] = Invalid(fields)
+			continue
+		}
+		tctx := WithTenant(ctx, u.Tenant)
+		nk := key{u.Tenant, u.Name}
+		if !names[nk] {
+			_, err := us.store.FindUserByName(tctx, u.Name)
+			if err == nil {
+				names[nk] = true
+			} else if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		if names[nk] {
+			errs[i] = ErrNameTaken
+			continue
+		}
+		ek := key{u.Tenant, u.Email}
+		if u.Email != "" && !emails[ek] {
+			_, err := us.store.FindUserByEmail(tctx, u.Email)
+			if err == nil {
+				emails[ek] = true
+			} else if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		if u.Email != "" && emails[ek] {
+			errs[i] = ErrEmailTaken
+			continue
+		}
+		names[nk] = true
+		if u.Email != "" {
+			emails[ek] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SortField - поле, по которому упорядочена выдача поиска.
+// При равных значениях записи упорядочены по ID, поэтому порядок стабилен.
+type SortField string
+
+const (
+	SortByName    SortField = "name"
+	SortByCreated SortField = "created"
+	SortByID      SortField = "id"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// Page - параметры страницы выдачи. Cursor - непрозрачная строка из Next
+// предыдущей страницы, пустой Cursor означает первую страницу.
+type Page struct {
+	Sort   SortField
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+// SortKey - значения записи, по которым сортируется выдача
+type SortKey struct {
+	Name      string    `json:"n,omitempty"`
+	CreatedAt time.Time `json:"c,omitempty"`
+	ID        uuid.UUID `json:"i"`
+}
+
+type cursor struct {
+	Sort SortField `json:"s"`
+	Desc bool      `json:"d,omitempty"`
+	Key  SortKey   `json:"k"`
+}
+
+// ParseSort разбирает поле сортировки вида name или -name (по убыванию)
+func ParseSort(s string) (SortField, bool, error) {
+	desc := strings.HasPrefix(s, "-")
+	f := SortField(strings.TrimPrefix(s, "-"))
+	switch f {
+	case "":
+		return SortByName, desc, nil
+	case SortByName, SortByCreated, SortByID:
+		return f, desc, nil
+	}
+	return "", false, InvalidField("sort", "must be one of name, created, id")
+}
+
+// check заполняет значения по умолчанию и проверяет курсор,
+// хранилища получают уже проверенную страницу
+func (p Page) check() (Page, error) {
+	if p.Sort == "" {
+		p.Sort = SortByName
+	}
+	switch {
+	case p.Limit == 0:
+		p.Limit = DefaultPageLimit
+	case p.Limit < 0 || p.Limit > MaxPageLimit:
+		return p, InvalidField("limit", "must be between 1 and %d", MaxPageLimit)
+	}
+	if _, err := p.Start(); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// Start возвращает ключ последней записи предыдущей страницы,
+// nil для первой страницы
+func (p Page) Start() (*SortKey, error) {
+	if p.Cursor == "" {
+		return nil, nil
+	}
+	errCursor := InvalidField("cursor", "is malformed")
+	b, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, errCursor
+	}
+	c := cursor{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, errCursor
+	}
+	if c.Sort != p.Sort || c.Desc != p.Desc {
+		return nil, InvalidField("cursor", "does not match sort")
+	}
+	return &c.Key, nil
+}
+
+// Less сообщает, идёт ли a раньше b в выдаче
+func (p Page) Less(a, b SortKey) bool {
+	if p.Desc {
+		a, b = b, a
+	}
+	switch p.Sort {
+	case SortByName:
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+	case SortByCreated:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+// CursorAfter возвращает курсор страницы, которая начнётся после k
+func (p Page) CursorAfter(k SortKey) string {
+	switch p.Sort {
+	case SortByName:
+		k.CreatedAt = time.Time{}
+	case SortByCreated:
+		k.Name = ""
+	default:
+		k.Name, k.CreatedAt = "", time.Time{}
+	}
+	b, _ := json.Marshal(cursor{Sort: p.Sort, Desc: p.Desc, Key: k})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// UserPage - страница выдачи поиска. Next задан, только если HasMore.
+type UserPage struct {
+	Users   []User
+	Next    string
+	HasMore bool
+}
+
+type GroupPage struct {
+	Groups  []Group
+	Next    string
+	HasMore bool
+}
+
+func (u User) SortKey() SortKey {
+	return SortKey{Name: u.Name, CreatedAt: u.CreatedAt, ID: u.ID}
+}
+
+func (g Group) SortKey() SortKey {
+	return SortKey{Name: g.Name, CreatedAt: g.CreatedAt, ID: g.ID}
+}
+
+// allUsers читает все страницы выдачи, нужна проверкам внутри репозиториев
+func allUsers(ctx context.Context, store UserStore, q UserQuery) ([]User, error) {
+	p := Page{Sort: SortByID, Limit: MaxPageLimit}
+	var us []User
+	for {
+		page, err := store.SearchUsers(ctx, q, p)
+		if err != nil {
+			return nil, fmt.Errorf("search users error: %w", err)
+		}
+		us = append(us, page.Users...)
+		if !page.HasMore {
+			return us, nil
+		}
+		p.Cursor = page.Next
+	}
+}
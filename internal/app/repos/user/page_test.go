@@ -0,0 +1,86 @@
+package user
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPage_Cursor(t *testing.T) {
+	k := SortKey{Name: "ann", CreatedAt: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), ID: uuid.New()}
+
+	for _, tc := range []struct {
+		p    Page
+		want SortKey
+	}{
+		// курсор хранит только поле сортировки и ID
+		{Page{Sort: SortByName}, SortKey{Name: k.Name, ID: k.ID}},
+		{Page{Sort: SortByCreated, Desc: true}, SortKey{CreatedAt: k.CreatedAt, ID: k.ID}},
+		{Page{Sort: SortByID}, SortKey{ID: k.ID}},
+	} {
+		p := tc.p
+		p.Cursor = p.CursorAfter(k)
+		start, err := p.Start()
+		if err != nil {
+			t.Fatalf("%s: %v", p.Sort, err)
+		}
+		if start.Name != tc.want.Name || !start.CreatedAt.Equal(tc.want.CreatedAt) || start.ID != tc.want.ID {
+			t.Errorf("%s: wrong key %+v", p.Sort, start)
+		}
+	}
+
+	// курсор действует только с той же сортировкой
+	c := Page{Sort: SortByName}.CursorAfter(k)
+	if _, err := (Page{Sort: SortByName, Desc: true, Cursor: c}).Start(); !errors.Is(err, ErrInvalid) {
+		t.Errorf("cursor of another sort: %v", err)
+	}
+	for _, c := range []string{"???", "bm90IGpzb24"} {
+		if _, err := (Page{Sort: SortByName, Cursor: c}).Start(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: %v", c, err)
+		}
+	}
+	if start, err := (Page{}).Start(); start != nil || err != nil {
+		t.Errorf("first page: %+v %v", start, err)
+	}
+}
+
+func TestPage_Less(t *testing.T) {
+	t0 := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	a := SortKey{Name: "ann", CreatedAt: t0.Add(time.Hour), ID: uuid.MustParse("00000000-0000-0000-0000-000000000002")}
+	b := SortKey{Name: "bob", CreatedAt: t0, ID: uuid.MustParse("00000000-0000-0000-0000-000000000001")}
+
+	for _, tc := range []struct {
+		p    Page
+		less bool
+	}{
+		{Page{Sort: SortByName}, true},
+		{Page{Sort: SortByName, Desc: true}, false},
+		{Page{Sort: SortByCreated}, false},
+		{Page{Sort: SortByID}, false},
+		{Page{Sort: SortByID, Desc: true}, true},
+	} {
+		if got := tc.p.Less(a, b); got != tc.less {
+			t.Errorf("%s desc=%v: less %v, want %v", tc.p.Sort, tc.p.Desc, got, tc.less)
+		}
+	}
+
+	// при равных значениях порядок задаёт ID
+	b.Name = a.Name
+	if !(Page{Sort: SortByName}).Less(b, a) {
+		t.Error("equal names are not ordered by ID")
+	}
+}
+
+func TestPage_Check(t *testing.T) {
+	p, err := Page{}.check()
+	if err != nil || p.Sort != SortByName || p.Limit != DefaultPageLimit {
+		t.Errorf("defaults: %+v %v", p, err)
+	}
+	for _, limit := range []int{-1, MaxPageLimit + 1} {
+		if _, err := (Page{Limit: limit}).check(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("limit %d: %v", limit, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Permissions - набор прав, каждое право - отдельный бит
+type Permissions int
+
+const (
+	PermUserRead Permissions = 1 << iota
+	PermUserWrite
+	PermGroupRead
+	PermGroupWrite
+	// PermGrant позволяет выдавать и отзывать права
+	PermGrant
+	PermAuditRead
+	// PermSchemaWrite позволяет менять схему атрибутов пользователей
+	PermSchemaWrite
+
+	PermAll = PermUserRead | PermUserWrite | PermGroupRead | PermGroupWrite | PermGrant | PermAuditRead |
+		PermSchemaWrite
+)
+
+var permNames = []struct {
+	perm Permissions
+	name string
+}{
+	{PermUserRead, "user:read"},
+	{PermUserWrite, "user:write"},
+	{PermGroupRead, "group:read"},
+	{PermGroupWrite, "group:write"},
+	{PermGrant, "perm:grant"},
+	{PermAuditRead, "audit:read"},
+	{PermSchemaWrite, "schema:write"},
+}
+
+var ErrUnknownPermission = &Error{Code: CodeInvalid, Message: "unknown permission"}
+
+// ParsePermissions разбирает список имён прав через запятую
+func ParsePermissions(s string) (Permissions, error) {
+	var p Permissions
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, pn := range permNames {
+			if pn.name == name {
+				p |= pn.perm
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, &Error{
+				Code:    CodeInvalid,
+				Message: ErrUnknownPermission.Message,
+				Fields:  map[string]string{"perm": name},
+			}
+		}
+	}
+	return p, nil
+}
+
+// Has сообщает, что в p есть все права из q
+func (p Permissions) Has(q Permissions) bool {
+	return p&q == q
+}
+
+func (p Permissions) Names() []string {
+	names := []string{}
+	for _, pn := range permNames {
+		if p.Has(pn.perm) {
+			names = append(names, pn.name)
+		}
+	}
+	return names
+}
+
+func (p Permissions) String() string {
+	return strings.Join(p.Names(), ",")
+}
+
+// сколько раз повторять чтение-изменение-запись при конфликте версий
+const grantRetries = 3
+
+// Grant добавляет пользователю права p
+func (us *Users) Grant(ctx context.Context, uid uuid.UUID, p Permissions) (*User, error) {
+	return us.setPermissions(ctx, uid, func(old Permissions) Permissions { return old | p })
+}
+
+// Revoke отзывает у пользователя права p
+func (us *Users) Revoke(ctx context.Context, uid uuid.UUID, p Permissions) (*User, error) {
+	return us.setPermissions(ctx, uid, func(old Permissions) Permissions { return old &^ p })
+}
+
+func (us *Users) setPermissions(ctx context.Context, uid uuid.UUID, f func(Permissions) Permissions) (*User, error) {
+	for i := 0; ; i++ {
+		u, err := us.store.ReadUser(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("read user error: %w", err)
+		}
+		old := *u
+		u.Permissions = f(u.Permissions)
+		var nu *User
+		err = us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+			var err error
+			nu, err = us.store.UpdateUser(ctx, *u)
+			if err != nil {
+				return nil, err
+			}
+			return []Event{{Type: EventUserPermissionsChanged, UserID: uid, Before: old, After: *nu}}, nil
+		})
+		if errors.Is(err, ErrVersionConflict) && i < grantRetries {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("update user error: %w", err)
+		}
+		return nu, nil
+	}
+}
+
+// Grant добавляет группе права p, их получают все участники группы
+func (gs *Groups) Grant(ctx context.Context, gid uuid.UUID, p Permissions) (*Group, error) {
+	return gs.setPermissions(ctx, gid, func(old Permissions) Permissions { return old | p })
+}
+
+// Revoke отзывает у группы права p
+func (gs *Groups) Revoke(ctx context.Context, gid uuid.UUID, p Permissions) (*Group, error) {
+	return gs.setPermissions(ctx, gid, func(old Permissions) Permissions { return old &^ p })
+}
+
+func (gs *Groups) setPermissions(ctx context.Context, gid uuid.UUID, f func(Permissions) Permissions) (*Group, error) {
+	for i := 0; ; i++ {
+		g, err := gs.store.ReadGroup(ctx, gid)
+		if err != nil {
+			return nil, fmt.Errorf("read group error: %w", err)
+		}
+		old := *g
+		g.Permissions = f(g.Permissions)
+		var ng *Group
+		err = gs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+			var err error
+			ng, err = gs.store.UpdateGroup(ctx, *g)
+			if err != nil {
+				return nil, err
+			}
+			return []Event{{Type: EventGroupPermissionsChanged, GroupID: gid, Before: old, After: *ng}}, nil
+		})
+		if errors.Is(err, ErrVersionConflict) && i < grantRetries {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("update group error: %w", err)
+		}
+		return ng, nil
+	}
+}
+
+// EffectivePermissions объединяет права пользователя с правами всех его групп,
+// включая группы, в которые он входит через вложенные группы
+func (ugm *UserGroupMapper) EffectivePermissions(ctx context.Context, u User) (Permissions, error) {
+	p := u.Permissions
+	gs, err := ugm.GetUserGroupsTransitive(ctx, u)
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range gs {
+		p |= m.Group.Permissions
+	}
+	return p, nil
+}
+
+// GroupPermissions объединяет права группы с правами всех групп, в которые
+// она входит: их получает каждый её участник
+func (ugm *UserGroupMapper) GroupPermissions(ctx context.Context, g Group) (Permissions, error) {
+	return groupPermissions(ctx, ugm.store, g)
+}
+
+func groupPermissions(ctx context.Context, store UserGroupsStore, g Group) (Permissions, error) {
+	p := g.Permissions
+	groups := []Group{g}
+	seen := map[uuid.UUID]struct{}{g.ID: {}}
+	for i := 0; i < len(groups); i++ {
+		ch, err := store.GetGroupParents(ctx, groups[i])
+		if err != nil {
+			return 0, fmt.Errorf("error: %w", err)
+		}
+		for pg := range ch {
+			if _, ok := seen[pg.ID]; ok {
+				continue
+			}
+			seen[pg.ID] = struct{}{}
+			p |= pg.Permissions
+			groups = append(groups, pg)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return p, nil
+}
@@ -0,0 +1,36 @@
+package user
+
+import "context"
+
+type principalKey struct{}
+
+// WithPrincipal кладёт в контекст пользователя, от имени которого выполняется запрос
+func WithPrincipal(ctx context.Context, u User) context.Context {
+	return context.WithValue(ctx, principalKey{}, u)
+}
+
+// PrincipalFromContext возвращает пользователя, положенного WithPrincipal
+func PrincipalFromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(principalKey{}).(User)
+	if !ok {
+		return nil, false
+	}
+	return &u, true
+}
+
+type grantKey struct{}
+
+// WithGrant кладёт в контекст, может ли пользователь запроса раздавать права
+func WithGrant(ctx context.Context, grant bool) context.Context {
+	return context.WithValue(ctx, grantKey{}, grant)
+}
+
+// canGrant сообщает, можно ли в ctx выдавать права: внутренним вызовам без
+// пользователя запроса можно, запросам - только после WithGrant(ctx, true)
+func canGrant(ctx context.Context) bool {
+	if _, ok := PrincipalFromContext(ctx); !ok {
+		return true
+	}
+	grant, _ := ctx.Value(grantKey{}).(bool)
+	return grant
+}
@@ -0,0 +1,591 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CampaignStatus string
+
+const (
+	CampaignOpen CampaignStatus = "open"
+	// CampaignEscalated - срок прошёл, нерешённые пункты переданы
+	// пользователям с правом group:write
+	CampaignEscalated CampaignStatus = "escalated"
+	CampaignClosed    CampaignStatus = "closed"
+)
+
+// DeadlineAction - что делать с нерешёнными пунктами по истечении срока
+type DeadlineAction string
+
+const (
+	DeadlineRevoke   DeadlineAction = "revoke"
+	DeadlineEscalate DeadlineAction = "escalate"
+)
+
+type ReviewDecision string
+
+const (
+	DecisionKeep   ReviewDecision = "keep"
+	DecisionRevoke ReviewDecision = "revoke"
+)
+
+type ReportFormat string
+
+const (
+	ReportJSON ReportFormat = "json"
+	ReportCSV  ReportFormat = "csv"
+)
+
+// Campaign - кампания пересмотра доступа: снимок членства в группах
+// GroupIDs, каждый пункт которого владельцы группы подтверждают или отзывают
+// до Deadline
+type Campaign struct {
+	ID         uuid.UUID      `json:"id"`
+	Tenant     string         `json:"tenant,omitempty"`
+	Name       string         `json:"name"`
+	GroupIDs   []uuid.UUID    `json:"group_ids"`
+	Deadline   time.Time      `json:"deadline"`
+	OnDeadline DeadlineAction `json:"on_deadline"`
+	Status     CampaignStatus `json:"status"`
+	CreatedBy  uuid.UUID      `json:"created_by"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ClosedAt   *time.Time     `json:"closed_at,omitempty"`
+}
+
+// ReviewItem - членство пользователя в группе на момент начала кампании.
+// Reviewers - владельцы группы, кроме самого пользователя; пункт без них
+// сразу передаётся пользователям с правом group:write (Escalated).
+type ReviewItem struct {
+	ID         uuid.UUID      `json:"id"`
+	CampaignID uuid.UUID      `json:"campaign_id"`
+	GroupID    uuid.UUID      `json:"group_id"`
+	GroupName  string         `json:"group_name"`
+	UserID     uuid.UUID      `json:"user_id"`
+	UserName   string         `json:"user_name"`
+	Role       Role           `json:"role"`
+	ExpiresAt  *time.Time     `json:"expires_at,omitempty"`
+	Reviewers  []uuid.UUID    `json:"reviewers,omitempty"`
+	Escalated  bool           `json:"escalated,omitempty"`
+	Decision   ReviewDecision `json:"decision,omitempty"`
+	Comment    string         `json:"comment,omitempty"`
+	// Auto - решение принято по истечении срока, DecidedBy тогда пуст
+	Auto      bool       `json:"auto,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	DecidedBy *uuid.UUID `json:"decided_by,omitempty"`
+}
+
+// Reviewer сообщает, назначен ли uid проверять пункт
+func (it ReviewItem) Reviewer(uid uuid.UUID) bool {
+	for _, id := range it.Reviewers {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+type ReviewStore interface {
+	// CreateCampaign сохраняет кампанию вместе с пунктами снимка
+	CreateCampaign(ctx context.Context, c Campaign, items []ReviewItem) error
+	ReadCampaign(ctx context.Context, id uuid.UUID) (*Campaign, error)
+	UpdateCampaign(ctx context.Context, c Campaign) error
+	// ListCampaigns возвращает кампании арендатора из ctx
+	ListCampaigns(ctx context.Context) ([]Campaign, error)
+	ReadReviewItem(ctx context.Context, id uuid.UUID) (*ReviewItem, error)
+	UpdateReviewItem(ctx context.Context, it ReviewItem) error
+	CampaignItems(ctx context.Context, cid uuid.UUID) ([]ReviewItem, error)
+}
+
+// Reviews - кампании пересмотра доступа. Отзыв доступа удаляет членство
+// через UserGroupMapper в той же транзакции, что и решение.
+type Reviews struct {
+	store  ReviewStore
+	users  UserStore
+	groups GroupStore
+	mapper *UserGroupMapper
+	outbox *Outbox
+	// key - закрытый ключ Ed25519 подписи отчётов
+	key ed25519.PrivateKey
+}
+
+// NewReviews создаёт кампании с ключом подписи отчётов key. Без key
+// создаётся случайный: проверить подпись можно по PublicKey, пока сервис
+// не перезапущен.
+func NewReviews(store ReviewStore, users UserStore, groups GroupStore,
+	mapper *UserGroupMapper, outbox *Outbox, key ed25519.PrivateKey) *Reviews {
+	if len(key) == 0 {
+		_, key, _ = ed25519.GenerateKey(rand.Reader)
+	}
+	return &Reviews{
+		store:  store,
+		users:  users,
+		groups: groups,
+		mapper: mapper,
+		outbox: outbox,
+		key:    key,
+	}
+}
+
+// Start начинает кампанию: снимает членство в группах c.GroupIDs и назначает
+// каждый пункт владельцам группы. Пустой c.OnDeadline - DeadlineRevoke.
+func (rs *Reviews) Start(ctx context.Context, c Campaign) (*Campaign, []ReviewItem, error) {
+	now := time.Now().UTC()
+	fields := map[string]string{}
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		fields["name"] = "must not be empty"
+	}
+	if len(c.GroupIDs) == 0 {
+		fields["group_ids"] = "must not be empty"
+	}
+	if !c.Deadline.After(now) {
+		fields["deadline"] = "must be in the future"
+	}
+	switch c.OnDeadline {
+	case "":
+		c.OnDeadline = DeadlineRevoke
+	case DeadlineRevoke, DeadlineEscalate:
+	default:
+		fields["on_deadline"] = "must be revoke or escalate"
+	}
+	if len(fields) > 0 {
+		return nil, nil, Invalid(fields)
+	}
+
+	c.ID = uuid.New()
+	c.GroupIDs = uniqueIDs(c.GroupIDs)
+	c.Deadline = c.Deadline.UTC()
+	c.Status = CampaignOpen
+	c.CreatedAt = now
+	c.ClosedAt = nil
+	if p, ok := PrincipalFromContext(ctx); ok {
+		c.CreatedBy = p.ID
+	}
+	var items []ReviewItem
+	err := rs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		items = nil
+		for i, gid := range c.GroupIDs {
+			g, err := rs.groups.ReadGroup(ctx, gid)
+			if err != nil {
+				return nil, err
+			}
+			if g.Dynamic() {
+				return nil, InvalidField("group_ids", "group %s is dynamic, review its rule instead", g.ID)
+			}
+			if i == 0 {
+				c.Tenant = g.Tenant
+			} else if err := checkTenants(c.Tenant, g.Tenant); err != nil {
+				return nil, err
+			}
+			gitems, err := rs.snapshot(ctx, c.ID, *g)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, gitems...)
+		}
+		if err := rs.store.CreateCampaign(ctx, c, items); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventReviewStarted, Tenant: c.Tenant, After: c}}, nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("start review error: %w", err)
+	}
+	return &c, items, nil
+}
+
+// snapshot снимает прямое членство в g
+func (rs *Reviews) snapshot(ctx context.Context, cid uuid.UUID, g Group) ([]ReviewItem, error) {
+	ch, err := rs.mapper.GetGroupUsers(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	var ms []UserMembership
+	var owners []uuid.UUID
+	for m := range ch {
+		ms = append(ms, m)
+		if m.Role == RoleOwner {
+			owners = append(owners, m.User.ID)
+		}
+	}
+	items := make([]ReviewItem, 0, len(ms))
+	for _, m := range ms {
+		it := ReviewItem{
+			ID:         uuid.New(),
+			CampaignID: cid,
+			GroupID:    g.ID,
+			GroupName:  g.Name,
+			UserID:     m.User.ID,
+			UserName:   m.User.Name,
+			Role:       m.Role,
+			ExpiresAt:  expiry(m.ExpiresAt),
+		}
+		for _, id := range owners {
+			if id != m.User.ID {
+				it.Reviewers = append(it.Reviewers, id)
+			}
+		}
+		it.Escalated = len(it.Reviewers) == 0
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+func (rs *Reviews) Read(ctx context.Context, id uuid.UUID) (*Campaign, error) {
+	c, err := rs.store.ReadCampaign(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("read review error: %w", err)
+	}
+	return c, nil
+}
+
+// List возвращает кампании арендатора из ctx, новые первыми
+func (rs *Reviews) List(ctx context.Context) ([]Campaign, error) {
+	cs, err := rs.store.ListCampaigns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list reviews error: %w", err)
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].CreatedAt.After(cs[j].CreatedAt)
+	})
+	return cs, nil
+}
+
+// Items возвращает пункты кампании по группам и именам пользователей
+func (rs *Reviews) Items(ctx context.Context, cid uuid.UUID) ([]ReviewItem, error) {
+	if _, err := rs.store.ReadCampaign(ctx, cid); err != nil {
+		return nil, fmt.Errorf("list review items error: %w", err)
+	}
+	items, err := rs.store.CampaignItems(ctx, cid)
+	if err != nil {
+		return nil, fmt.Errorf("list review items error: %w", err)
+	}
+	sortItems(items)
+	return items, nil
+}
+
+// ReadItem возвращает пункт, если его кампания видна из ctx
+func (rs *Reviews) ReadItem(ctx context.Context, id uuid.UUID) (*ReviewItem, error) {
+	it, err := rs.store.ReadReviewItem(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("read review item error: %w", err)
+	}
+	if _, err := rs.store.ReadCampaign(ctx, it.CampaignID); err != nil {
+		return nil, NotFound("review item %s not found", id)
+	}
+	return it, nil
+}
+
+// Decide записывает решение пользователя из ctx по пункту. Отзыв сразу
+// удаляет членство. Свой доступ подтвердить или отозвать нельзя. Когда
+// решены все пункты, кампания закрывается.
+func (rs *Reviews) Decide(ctx context.Context, id uuid.UUID, decision ReviewDecision, comment string) (*ReviewItem, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	if decision != DecisionKeep && decision != DecisionRevoke {
+		return nil, InvalidField("decision", "must be keep or revoke")
+	}
+	var it *ReviewItem
+	err := rs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, err := rs.store.ReadReviewItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		c, err := rs.store.ReadCampaign(ctx, old.CampaignID)
+		if err != nil {
+			return nil, NotFound("review item %s not found", id)
+		}
+		if c.Status == CampaignClosed {
+			return nil, Conflict("review is closed")
+		}
+		if old.Decision != "" {
+			return nil, Conflict("review item is already decided")
+		}
+		if old.UserID == p.ID {
+			return nil, Forbidden("cannot review own access")
+		}
+
+		ni := *old
+		ni.Comment = strings.TrimSpace(comment)
+		by := p.ID
+		ni.DecidedBy = &by
+		evs, err := rs.decide(ctx, *c, &ni, decision)
+		if err != nil {
+			return nil, err
+		}
+		it = &ni
+		cevs, err := rs.closeIfDone(ctx, *c)
+		if err != nil {
+			return nil, err
+		}
+		return append(evs, cevs...), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decide review item error: %w", err)
+	}
+	return it, nil
+}
+
+// decide записывает решение по пункту it и при отзыве удаляет членство
+func (rs *Reviews) decide(ctx context.Context, c Campaign, it *ReviewItem, decision ReviewDecision) ([]Event, error) {
+	old := *it
+	now := time.Now().UTC()
+	it.Decision = decision
+	it.DecidedAt = &now
+	if decision == DecisionRevoke {
+		if err := rs.revoke(ctx, *it); err != nil {
+			return nil, err
+		}
+	}
+	if err := rs.store.UpdateReviewItem(ctx, *it); err != nil {
+		return nil, err
+	}
+	return []Event{{
+		Type:    EventReviewDecided,
+		Tenant:  c.Tenant,
+		UserID:  it.UserID,
+		GroupID: it.GroupID,
+		Before:  old,
+		After:   *it,
+	}}, nil
+}
+
+// revoke удаляет членство пункта, если оно ещё есть
+func (rs *Reviews) revoke(ctx context.Context, it ReviewItem) error {
+	u, err := rs.users.ReadUser(ctx, it.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	g, err := rs.groups.ReadGroup(ctx, it.GroupID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, ok, err := rs.mapper.UserRole(ctx, *u, *g); err != nil || !ok {
+		return err
+	}
+	return rs.mapper.DeleteUserFromGroup(ctx, *u, *g)
+}
+
+// closeIfDone закрывает кампанию c, если по всем её пунктам есть решение
+func (rs *Reviews) closeIfDone(ctx context.Context, c Campaign) ([]Event, error) {
+	items, err := rs.store.CampaignItems(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range items {
+		if it.Decision == "" {
+			return nil, nil
+		}
+	}
+	old := c
+	now := time.Now().UTC()
+	c.Status = CampaignClosed
+	c.ClosedAt = &now
+	if err := rs.store.UpdateCampaign(ctx, c); err != nil {
+		return nil, err
+	}
+	return []Event{{Type: EventReviewClosed, Tenant: c.Tenant, Before: old, After: c}}, nil
+}
+
+// Expire обрабатывает открытые кампании, срок которых истёк к now:
+// нерешённые пункты отзываются или передаются пользователям с правом
+// group:write, в зависимости от OnDeadline. Возвращает число кампаний.
+func (rs *Reviews) Expire(ctx context.Context, now time.Time) (int, error) {
+	var n int
+	err := rs.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		cs, err := rs.store.ListCampaigns(ctx)
+		if err != nil {
+			return nil, err
+		}
+		n = 0
+		var evs []Event
+		for _, c := range cs {
+			if c.Status != CampaignOpen || now.Before(c.Deadline) {
+				continue
+			}
+			n++
+			cevs, err := rs.expire(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			evs = append(evs, cevs...)
+		}
+		return evs, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("expire reviews error: %w", err)
+	}
+	return n, nil
+}
+
+func (rs *Reviews) expire(ctx context.Context, c Campaign) ([]Event, error) {
+	items, err := rs.store.CampaignItems(ctx, c.ID)
+	if err != nil {
+		return nil, err
+	}
+	var evs []Event
+	for _, it := range items {
+		if it.Decision != "" {
+			continue
+		}
+		if c.OnDeadline == DeadlineRevoke {
+			it.Auto = true
+			ievs, err := rs.decide(ctx, c, &it, DecisionRevoke)
+			if err != nil {
+				return nil, err
+			}
+			evs = append(evs, ievs...)
+			continue
+		}
+		it.Escalated = true
+		if err := rs.store.UpdateReviewItem(ctx, it); err != nil {
+			return nil, err
+		}
+	}
+	if c.OnDeadline == DeadlineEscalate {
+		old := c
+		c.Status = CampaignEscalated
+		if err := rs.store.UpdateCampaign(ctx, c); err != nil {
+			return nil, err
+		}
+		evs = append(evs, Event{Type: EventReviewEscalated, Tenant: c.Tenant, Before: old, After: c})
+	}
+	cevs, err := rs.closeIfDone(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return append(evs, cevs...), nil
+}
+
+// ReviewReport - отчёт о кампании в формате JSON
+type ReviewReport struct {
+	Campaign    Campaign     `json:"campaign"`
+	Items       []ReviewItem `json:"items"`
+	GeneratedAt time.Time    `json:"generated_at"`
+}
+
+var reportHeader = []string{
+	"campaign_id", "campaign", "group_id", "group", "user_id", "user", "role",
+	"decision", "auto", "escalated", "decided_by", "decided_at", "comment",
+}
+
+// Report выгружает кампанию в формате format и возвращает отчёт вместе
+// с подписью: Ed25519 его байтов в hex. Проверить её может любой,
+// у кого есть PublicKey.
+func (rs *Reviews) Report(ctx context.Context, cid uuid.UUID, format ReportFormat) ([]byte, string, error) {
+	c, err := rs.store.ReadCampaign(ctx, cid)
+	if err != nil {
+		return nil, "", fmt.Errorf("review report error: %w", err)
+	}
+	items, err := rs.store.CampaignItems(ctx, cid)
+	if err != nil {
+		return nil, "", fmt.Errorf("review report error: %w", err)
+	}
+	sortItems(items)
+
+	var b []byte
+	switch format {
+	case ReportJSON, "":
+		b, err = json.Marshal(ReviewReport{Campaign: *c, Items: items, GeneratedAt: time.Now().UTC()})
+	case ReportCSV:
+		b, err = reportCSV(*c, items)
+	default:
+		return nil, "", InvalidField("format", "must be json or csv")
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("review report error: %w", err)
+	}
+	return b, rs.sign(b), nil
+}
+
+// PublicKey - открытый ключ, которым проверяются подписи отчётов
+func (rs *Reviews) PublicKey() ed25519.PublicKey {
+	return rs.key.Public().(ed25519.PublicKey)
+}
+
+// VerifyReport проверяет подпись отчёта, выданного Report, открытым ключом pub
+func VerifyReport(pub ed25519.PublicKey, report []byte, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(pub, report, sig)
+}
+
+func (rs *Reviews) sign(b []byte) string {
+	return hex.EncodeToString(ed25519.Sign(rs.key, b))
+}
+
+func reportCSV(c Campaign, items []ReviewItem) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write(reportHeader)
+	for _, it := range items {
+		var by, at string
+		if it.DecidedBy != nil {
+			by = it.DecidedBy.String()
+		}
+		if it.DecidedAt != nil {
+			at = it.DecidedAt.Format(time.RFC3339)
+		}
+		row := []string{
+			c.ID.String(), c.Name, it.GroupID.String(), it.GroupName, it.UserID.String(), it.UserName,
+			string(it.Role), string(it.Decision), fmt.Sprint(it.Auto), fmt.Sprint(it.Escalated), by, at, it.Comment,
+		}
+		for i := range row {
+			row[i] = csvCell(row[i])
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvCell экранирует значение, которое табличный редактор принял бы
+// за формулу: перед ним ставится апостроф
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func sortItems(items []ReviewItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].GroupName != items[j].GroupName {
+			return items[i].GroupName < items[j].GroupName
+		}
+		return items[i].UserName < items[j].UserName
+	})
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]struct{}, len(ids))
+	res := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			res = append(res, id)
+		}
+	}
+	return res
+}
@@ -0,0 +1,28 @@
+package user
+
+// Role - роль пользователя в группе
+type Role string
+
+const (
+	// RoleOwner - владелец группы
+	RoleOwner Role = "owner"
+	// RoleManager управляет составом группы
+	RoleManager Role = "manager"
+	RoleMember  Role = "member"
+)
+
+// ParseRole разбирает имя роли, пустое имя означает RoleMember
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case "":
+		return RoleMember, nil
+	case RoleOwner, RoleManager, RoleMember:
+		return r, nil
+	}
+	return "", InvalidField("role", "must be one of owner, manager, member")
+}
+
+// Manages сообщает, может ли участник с этой ролью управлять составом группы
+func (r Role) Manages() bool {
+	return r == RoleOwner || r == RoleManager
+}
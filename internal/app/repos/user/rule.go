@@ -0,0 +1,583 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDynamicGroup возвращается при попытке изменить состав динамической
+// группы вручную: её участников определяет правило
+var ErrDynamicGroup = &Error{Code: CodeConflict, Message: "membership of a dynamic group is defined by its rule"}
+
+// maxRuleLength - наибольшая длина правила динамической группы
+const maxRuleLength = 1000
+
+// Dynamic сообщает, определяется ли состав группы правилом
+func (g Group) Dynamic() bool {
+	return g.Rule != ""
+}
+
+// Rule - разобранное правило динамической группы. Синтаксис:
+//
+//	attr.department = sales and (state = active or attr.level >= 3)
+//	not attr.region in (eu, "us east")
+//
+// Поля: name, email, email_verified, state и attr.<имя атрибута>.
+// Операции: = != < <= > >= in, связки and, or, not и скобки. Для
+// атрибута-списка = проверяет наличие значения в списке, < и > сравнивают
+// числа, время в RFC 3339 и строки. Отсутствующий атрибут не равен ничему.
+type Rule struct {
+	src  string
+	root ruleNode
+}
+
+type ruleNode interface {
+	match(u User) bool
+}
+
+type ruleAnd struct{ l, r ruleNode }
+type ruleOr struct{ l, r ruleNode }
+type ruleNot struct{ n ruleNode }
+
+// ruleCmp - сравнение поля со значениями, для in их несколько
+type ruleCmp struct {
+	field  string
+	op     string
+	values []string
+}
+
+func (n ruleAnd) match(u User) bool { return n.l.match(u) && n.r.match(u) }
+func (n ruleOr) match(u User) bool  { return n.l.match(u) || n.r.match(u) }
+func (n ruleNot) match(u User) bool { return !n.n.match(u) }
+
+func (n ruleCmp) match(u User) bool {
+	v, ok := ruleField(u, n.field)
+	if !ok {
+		return n.op == "!="
+	}
+	switch n.op {
+	case "=", "in":
+		for _, want := range n.values {
+			if MatchAttr(v, want) {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		return !MatchAttr(v, n.values[0])
+	}
+	c, ok := compareAttr(v, n.values[0])
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// ruleField возвращает значение поля пользователя в том виде,
+// в котором его понимает MatchAttr
+func ruleField(u User, field string) (interface{}, bool) {
+	switch field {
+	case "name":
+		return u.Name, true
+	case "email":
+		return u.Email, u.Email != ""
+	case "email_verified":
+		return u.EmailVerified, true
+	case "state":
+		return string(u.State), true
+	}
+	v, ok := u.Attrs[strings.TrimPrefix(field, "attr.")]
+	return v, ok
+}
+
+// compareAttr сравнивает значение атрибута со значением из правила
+func compareAttr(v interface{}, want string) (int, bool) {
+	switch x := v.(type) {
+	case string:
+		return strings.Compare(x, want), true
+	case float64:
+		n, err := strconv.ParseFloat(want, 64)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case x < n:
+			return -1, true
+		case x > n:
+			return 1, true
+		}
+		return 0, true
+	case time.Time:
+		t, err := time.Parse(time.RFC3339, want)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case x.Before(t):
+			return -1, true
+		case x.After(t):
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// ParseRule разбирает правило динамической группы
+func ParseRule(s string) (*Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, InvalidField("rule", "must not be empty")
+	}
+	if len(s) > maxRuleLength {
+		return nil, InvalidField("rule", "at most %d characters allowed", maxRuleLength)
+	}
+	toks, err := lexRule(s)
+	if err != nil {
+		return nil, InvalidField("rule", "%s", err.Error())
+	}
+	p := ruleParser{toks: toks}
+	root, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	if err != nil {
+		return nil, InvalidField("rule", "%s", err.Error())
+	}
+	return &Rule{src: s, root: root}, nil
+}
+
+func (r *Rule) Match(u User) bool {
+	return r.root.match(u)
+}
+
+func (r *Rule) String() string {
+	return r.src
+}
+
+type ruleToken struct {
+	text string
+	// quoted - строка в кавычках, всегда значение, а не слово языка
+	quoted bool
+}
+
+func lexRule(s string) ([]ruleToken, error) {
+	var toks []ruleToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == ',' || c == '=':
+			toks = append(toks, ruleToken{text: s[i : i+1]})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(s) && s[i+1] == '=' {
+				toks = append(toks, ruleToken{text: s[i : i+2]})
+				i += 2
+			} else if c == '!' {
+				return nil, fmt.Errorf("unexpected %q", "!")
+			} else {
+				toks = append(toks, ruleToken{text: s[i : i+1]})
+				i++
+			}
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			v, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("bad string %s", s[i:j+1])
+			}
+			toks = append(toks, ruleToken{text: v, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()=,!<>\"", rune(s[j])) {
+				j++
+			}
+			toks = append(toks, ruleToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// ruleParser - разбор рекурсивным спуском:
+// or = and {"or" and}; and = unary {"and" unary};
+// unary = "not" unary | "(" or ")" | field op value | field "in" "(" value {"," value} ")"
+type ruleParser struct {
+	toks []ruleToken
+	pos  int
+}
+
+// keyword сообщает, является ли следующий токен словом языка kw
+func (p *ruleParser) keyword(kw string) bool {
+	if p.pos < len(p.toks) && !p.toks[p.pos].quoted && strings.EqualFold(p.toks[p.pos].text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) next() (ruleToken, error) {
+	if p.pos >= len(p.toks) {
+		return ruleToken{}, fmt.Errorf("unexpected end of rule")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	return t, nil
+}
+
+func (p *ruleParser) or() (ruleNode, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = ruleOr{l, r}
+	}
+	return l, nil
+}
+
+func (p *ruleParser) and() (ruleNode, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = ruleAnd{l, r}
+	}
+	return l, nil
+}
+
+func (p *ruleParser) unary() (ruleNode, error) {
+	if p.keyword("not") {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return ruleNot{n}, nil
+	}
+	if p.keyword("(") {
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return n, nil
+	}
+	return p.cmp()
+}
+
+func (p *ruleParser) cmp() (ruleNode, error) {
+	f, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if f.quoted || !ruleFieldName(f.text) {
+		return nil, fmt.Errorf("unknown field %q", f.text)
+	}
+	n := ruleCmp{field: f.text}
+	if p.keyword("in") {
+		n.op = "in"
+		if !p.keyword("(") {
+			return nil, fmt.Errorf("expected ( after in")
+		}
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			n.values = append(n.values, v)
+			if p.keyword(")") {
+				return n, nil
+			}
+			if !p.keyword(",") {
+				return nil, fmt.Errorf("expected , or )")
+			}
+		}
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch op.text {
+	case "=", "!=", "<", "<=", ">", ">=":
+		if op.quoted {
+			return nil, fmt.Errorf("expected operator after %s", f.text)
+		}
+	default:
+		return nil, fmt.Errorf("expected operator after %s", f.text)
+	}
+	n.op = op.text
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	n.values = []string{v}
+	return n, nil
+}
+
+func (p *ruleParser) value() (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if !t.quoted && strings.ContainsAny(t.text, "(),=!<>") {
+		return "", fmt.Errorf("expected value, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+func ruleFieldName(s string) bool {
+	switch s {
+	case "name", "email", "email_verified", "state":
+		return true
+	}
+	name := strings.TrimPrefix(s, "attr.")
+	return name != s && attrNameRe.MatchString(name)
+}
+
+// checkRule проверяет правило группы: динамическая группа закрыта
+// для заявок, её состав меняет только правило
+func checkRule(g *Group, fields map[string]string) {
+	g.Rule = strings.TrimSpace(g.Rule)
+	if !g.Dynamic() {
+		return
+	}
+	if _, err := ParseRule(g.Rule); err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			fields["rule"] = e.Fields["rule"]
+		}
+	}
+	if g.JoinPolicy != JoinClosed {
+		fields["join_policy"] = "dynamic groups are closed"
+	}
+}
+
+// DynamicGroups поддерживает членство в динамических группах: пишет его
+// в UserGroupsStore как обычное, поэтому GetGroupUsers и GetUserGroups
+// его видят. Users пересчитывает одного пользователя при каждом его
+// изменении, Groups - всю группу при смене правила.
+type DynamicGroups struct {
+	users  UserStore
+	groups GroupStore
+	ugs    UserGroupsStore
+}
+
+func NewDynamicGroups(users UserStore, groups GroupStore, ugs UserGroupsStore) *DynamicGroups {
+	return &DynamicGroups{
+		users:  users,
+		groups: groups,
+		ugs:    ugs,
+	}
+}
+
+// Sync приводит членство u в динамических группах его арендатора
+// в соответствие с их правилами. Вызывается в транзакции изменения u,
+// возвращает события для неё.
+func (dg *DynamicGroups) Sync(ctx context.Context, u User) ([]Event, error) {
+	if !u.DeletedAt.IsZero() {
+		return nil, nil
+	}
+	gs, err := dg.groups.ListDynamicGroups(ctx, u.Tenant)
+	if err != nil || len(gs) == 0 {
+		return nil, err
+	}
+	ch, err := dg.ugs.GetUserGroups(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	member := make(map[uuid.UUID]bool)
+	for m := range ch {
+		member[m.Group.ID] = true
+	}
+	var evs []Event
+	for _, g := range gs {
+		r, err := ParseRule(g.Rule)
+		if err != nil {
+			return nil, err
+		}
+		ev, err := dg.set(ctx, u, g, member[g.ID], r.Match(u))
+		if err != nil {
+			return nil, err
+		}
+		if ev != nil {
+			evs = append(evs, *ev)
+		}
+	}
+	return evs, nil
+}
+
+// Recompute пересчитывает всех участников динамической группы g.
+// Если g стала обычной, её участники остаются как есть.
+func (dg *DynamicGroups) Recompute(ctx context.Context, g Group) ([]Event, error) {
+	if !g.Dynamic() {
+		return nil, nil
+	}
+	r, err := ParseRule(g.Rule)
+	if err != nil {
+		return nil, err
+	}
+	us, err := allUsers(WithTenant(ctx, g.Tenant), dg.users, UserQuery{})
+	if err != nil {
+		return nil, err
+	}
+	ch, err := dg.ugs.GetGroupUsers(ctx, g)
+	if err != nil {
+		return nil, err
+	}
+	member := make(map[uuid.UUID]User)
+	for m := range ch {
+		member[m.User.ID] = m.User
+	}
+	var evs []Event
+	for _, u := range us {
+		_, has := member[u.ID]
+		delete(member, u.ID)
+		ev, err := dg.set(ctx, u, g, has, r.Match(u))
+		if err != nil {
+			return nil, err
+		}
+		if ev != nil {
+			evs = append(evs, *ev)
+		}
+	}
+	// участники, которых нет среди пользователей арендатора
+	for _, u := range member {
+		ev, err := dg.set(ctx, u, g, true, false)
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, *ev)
+	}
+	return evs, nil
+}
+
+// set добавляет u в g или удаляет из неё, если has и want расходятся
+func (dg *DynamicGroups) set(ctx context.Context, u User, g Group, has, want bool) (*Event, error) {
+	m := Membership{UserID: u.ID, GroupID: g.ID, Role: RoleMember}
+	switch {
+	case want && !has:
+		if err := dg.checkGrant(ctx, u, g); err != nil {
+			return nil, err
+		}
+		if err := dg.ugs.AddUserToGroup(ctx, u, g, RoleMember, time.Time{}); err != nil {
+			return nil, err
+		}
+		return &Event{Type: EventMembershipAdded, Tenant: g.Tenant, UserID: u.ID, GroupID: g.ID, After: m}, nil
+	case has && !want:
+		if err := dg.ugs.DeleteUserFromGroup(ctx, u, g); err != nil {
+			return nil, err
+		}
+		return &Event{Type: EventMembershipRemoved, Tenant: g.Tenant, UserID: u.ID, GroupID: g.ID, Before: m}, nil
+	}
+	return nil, nil
+}
+
+// checkGrant запрещает правилу добавлять u в группу g, которая даёт права,
+// если пользователь запроса не может их раздавать: иначе user:write позволял
+// бы выдать себе права группы, подогнав атрибуты под её правило
+func (dg *DynamicGroups) checkGrant(ctx context.Context, u User, g Group) error {
+	if canGrant(ctx) {
+		return nil
+	}
+	perms, err := groupPermissions(ctx, dg.ugs, g)
+	if err != nil {
+		return err
+	}
+	if perms != 0 {
+		return Forbidden("user %s matches the rule of group %s which grants %s, this requires %s", u.ID, g.ID, perms, PermGrant)
+	}
+	return nil
+}
+
+// RulePreview - кто подошёл бы под правило. Для существующей группы
+// Added и Removed - кто войдёт в неё и кто выйдет, если сохранить правило.
+type RulePreview struct {
+	Users   []User      `json:"users"`
+	Added   []uuid.UUID `json:"added,omitempty"`
+	Removed []uuid.UUID `json:"removed,omitempty"`
+}
+
+// Preview показывает пользователей арендатора из ctx (или группы g, если
+// она задана), подходящих под правило expr, ничего не сохраняя
+func (dg *DynamicGroups) Preview(ctx context.Context, expr string, g *Group) (*RulePreview, error) {
+	r, err := ParseRule(expr)
+	if err != nil {
+		return nil, err
+	}
+	if g != nil {
+		ctx = WithTenant(ctx, g.Tenant)
+	}
+	us, err := allUsers(ctx, dg.users, UserQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("preview rule error: %w", err)
+	}
+	res := RulePreview{Users: []User{}}
+	matched := make(map[uuid.UUID]bool)
+	for _, u := range us {
+		if r.Match(u) {
+			res.Users = append(res.Users, u)
+			matched[u.ID] = true
+		}
+	}
+	sort.Slice(res.Users, func(i, j int) bool {
+		return res.Users[i].Name < res.Users[j].Name
+	})
+	if g == nil {
+		return &res, nil
+	}
+
+	ch, err := dg.ugs.GetGroupUsers(ctx, *g)
+	if err != nil {
+		return nil, fmt.Errorf("preview rule error: %w", err)
+	}
+	member := make(map[uuid.UUID]bool)
+	for m := range ch {
+		member[m.User.ID] = true
+		if !matched[m.User.ID] {
+			res.Removed = append(res.Removed, m.User.ID)
+		}
+	}
+	for _, u := range res.Users {
+		if !member[u.ID] {
+			res.Added = append(res.Added, u.ID)
+		}
+	}
+	return &res, nil
+}
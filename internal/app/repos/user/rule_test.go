@@ -0,0 +1,74 @@
+package user
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	u := User{
+		Name:  "ann",
+		Email: "ann@example.com",
+		State: StateActive,
+		Attrs: Attributes{
+			"department": "sales",
+			"level":      3.0,
+			"hired":      time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+			"region":     "us east",
+		},
+	}
+
+	for _, tc := range []struct {
+		rule  string
+		match bool
+	}{
+		{`attr.department = sales`, true},
+		{`attr.department != sales`, false},
+		{`attr.level >= 3 and attr.level < 4`, true},
+		{`attr.level > 3 or name = ann`, true},
+		{`not attr.region in (eu, "us east")`, false},
+		{`attr.region in (eu, "us east")`, true},
+		{`attr.hired < 2022-01-01T00:00:00Z`, true},
+		{`attr.missing = x`, false},
+		{`attr.missing != x`, true},
+		{`state = active AND (email = "ann@example.com" OR email_verified = true)`, true},
+		{`not (attr.department = sales and attr.level >= 2)`, false},
+		{`name in ("and", "or") or name = "not"`, false},
+	} {
+		r, err := ParseRule(tc.rule)
+		if err != nil {
+			t.Errorf("%s: %v", tc.rule, err)
+			continue
+		}
+		if got := r.Match(u); got != tc.match {
+			t.Errorf("%s: match %v, want %v", tc.rule, got, tc.match)
+		}
+		if r.String() != tc.rule {
+			t.Errorf("%s: source %q", tc.rule, r.String())
+		}
+	}
+}
+
+func TestParseRule_Errors(t *testing.T) {
+	for _, rule := range []string{
+		``,
+		`attr.department`,
+		`attr.department =`,
+		`department = sales`,
+		`"name" = ann`,
+		`name "=" ann`,
+		`name = ann and`,
+		`(name = ann`,
+		`name = ann)`,
+		`name in ann`,
+		`name in (ann bob)`,
+		`name = "ann`,
+		`name ! ann`,
+	} {
+		_, err := ParseRule(rule)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: error %v", rule, err)
+		}
+	}
+}
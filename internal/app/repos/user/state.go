@@ -0,0 +1,120 @@
+package user
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// State - состояние жизненного цикла пользователя. Удаление (DeletedAt)
+// от состояния не зависит.
+type State string
+
+const (
+	// StateInvited - пользователь приглашён, но ещё не начал работу
+	StateInvited State = "invited"
+	StateActive  State = "active"
+	// StateSuspended - работа приостановлена администратором
+	StateSuspended State = "suspended"
+	// StateLocked - вход заблокирован, например после неудачных попыток
+	StateLocked State = "locked"
+	// StateDeactivated - учётная запись выключена, но не удалена
+	StateDeactivated State = "deactivated"
+)
+
+// transitions - разрешённые переходы между состояниями
+var transitions = map[State][]State{
+	StateInvited:     {StateActive, StateDeactivated},
+	StateActive:      {StateSuspended, StateLocked, StateDeactivated},
+	StateSuspended:   {StateActive, StateDeactivated},
+	StateLocked:      {StateActive, StateSuspended, StateDeactivated},
+	StateDeactivated: {StateActive},
+}
+
+func ParseState(s string) (State, error) {
+	st := State(s)
+	if _, ok := transitions[st]; !ok {
+		return "", InvalidField("state", "unknown state %q", s)
+	}
+	return st, nil
+}
+
+// CanTransition сообщает, можно ли перейти из s в to
+func (s State) CanTransition(to State) bool {
+	for _, st := range transitions[s] {
+		if st == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Listed сообщает, показывается ли пользователь в этом состоянии
+// в списках участников групп по умолчанию
+func (s State) Listed() bool {
+	return s != StateSuspended && s != StateDeactivated
+}
+
+// CanSignIn возвращает ошибку, если пользователю в его состоянии
+// нельзя выполнять запросы. Входить может только активный пользователь.
+func (u User) CanSignIn() error {
+	if u.State != StateActive {
+		return Forbidden("user is %s", u.State)
+	}
+	return nil
+}
+
+// SetState переводит пользователя в состояние to, если переход разрешён
+func (us *Users) SetState(ctx context.Context, uid uuid.UUID, to State) (*User, error) {
+	if _, err := ParseState(string(to)); err != nil {
+		return nil, err
+	}
+	var nu *User
+	err := us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, err := us.store.ReadUser(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		if !old.State.CanTransition(to) {
+			return nil, Conflict("user cannot change state from %s to %s", old.State, to)
+		}
+		u := *old
+		u.State = to
+		nu, err = us.store.UpdateUser(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		evs, err := us.dynamic.Sync(ctx, *nu)
+		if err != nil {
+			return nil, err
+		}
+		return append([]Event{{Type: EventUserStateChanged, UserID: uid, Before: *old, After: *nu}}, evs...), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("set user state error: %w", err)
+	}
+	return nu, nil
+}
+
+// Suspend приостанавливает работу пользователя
+func (us *Users) Suspend(ctx context.Context, uid uuid.UUID) (*User, error) {
+	return us.SetState(ctx, uid, StateSuspended)
+}
+
+// Reactivate возвращает пользователя в активное состояние
+func (us *Users) Reactivate(ctx context.Context, uid uuid.UUID) (*User, error) {
+	return us.SetState(ctx, uid, StateActive)
+}
+
+// initState задаёт состояние нового пользователя: приглашённый или активный
+func initState(u *User) error {
+	switch u.State {
+	case "":
+		u.State = StateActive
+	case StateInvited, StateActive:
+	default:
+		return InvalidField("state", "must be %s or %s", StateInvited, StateActive)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// DefaultTenant - арендатор по умолчанию. Его пользователи с правами
+// могут работать с любым арендатором (операторы сервиса).
+const DefaultTenant = ""
+
+// CodeQuotaExceeded - превышена квота арендатора
+const CodeQuotaExceeded ErrorCode = "quota_exceeded"
+
+var ErrQuotaExceeded = &Error{Code: CodeQuotaExceeded}
+
+// ErrNoTenant - вызов хранилища без WithTenant и AllTenants в контексте
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantKey struct{}
+
+// allTenants - значение tenantKey, положенное AllTenants
+type allTenants struct{}
+
+// WithTenant ограничивает все вызовы хранилищ с ctx арендатором tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// AllTenants открывает вызовам хранилищ с ctx данные всех арендаторов:
+// для фоновых задач и поиска пользователя при входе без арендатора
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, allTenants{})
+}
+
+// TenantFromContext возвращает арендатора, положенного WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	t, ok := ctx.Value(tenantKey{}).(string)
+	return t, ok
+}
+
+// CheckTenant возвращает ErrNoTenant, если в ctx нет ни арендатора,
+// ни AllTenants. Хранилища отказывают таким вызовам, а не показывают
+// им данные всех арендаторов.
+func CheckTenant(ctx context.Context) error {
+	switch ctx.Value(tenantKey{}).(type) {
+	case string, allTenants:
+		return nil
+	}
+	return ErrNoTenant
+}
+
+// InTenant сообщает, видна ли запись арендатора tenant в ctx.
+// Без арендатора в ctx не видно ничего.
+func InTenant(ctx context.Context, tenant string) bool {
+	switch t := ctx.Value(tenantKey{}).(type) {
+	case string:
+		return t == tenant
+	case allTenants:
+		return true
+	}
+	return false
+}
+
+// Quota - ограничения арендатора, 0 - без ограничения
+type Quota struct {
+	MaxUsers  int
+	MaxGroups int
+}
+
+// Quotas - квоты по арендаторам, Default - для остальных
+type Quotas struct {
+	Default Quota
+	Tenants map[string]Quota
+}
+
+func (q Quotas) For(tenant string) Quota {
+	if tq, ok := q.Tenants[tenant]; ok {
+		return tq
+	}
+	return q.Default
+}
+
+func quotaExceeded(what, tenant string, limit int) error {
+	return &Error{
+		Code:    CodeQuotaExceeded,
+		Message: fmt.Sprintf("%s quota exceeded", what),
+		Fields:  map[string]string{"tenant": tenant, "limit": strconv.Itoa(limit)},
+	}
+}
+
+// checkUserQuota проверяет, что арендатору можно добавить ещё n пользователей
+func (us *Users) checkUserQuota(ctx context.Context, tenant string, n int) error {
+	max := us.quotas.For(tenant).MaxUsers
+	if max == 0 {
+		return nil
+	}
+	cnt, err := us.store.CountUsers(ctx, tenant)
+	if err != nil {
+		return fmt.Errorf("count users error: %w", err)
+	}
+	if cnt+n > max {
+		return quotaExceeded("user", tenant, max)
+	}
+	return nil
+}
+
+// checkGroupQuota проверяет, что арендатору можно добавить ещё n групп
+func (gs *Groups) checkGroupQuota(ctx context.Context, tenant string, n int) error {
+	max := gs.quotas.For(tenant).MaxGroups
+	if max == 0 {
+		return nil
+	}
+	cnt, err := gs.store.CountGroups(ctx, tenant)
+	if err != nil {
+		return fmt.Errorf("count groups error: %w", err)
+	}
+	if cnt+n > max {
+		return quotaExceeded("group", tenant, max)
+	}
+	return nil
+}
+
+// checkTenants запрещает связи между записями разных арендаторов
+func checkTenants(a, b string) error {
+	if a != b {
+		return Forbidden("cross-tenant membership is not allowed")
+	}
+	return nil
+}
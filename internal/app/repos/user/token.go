@@ -0,0 +1,378 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// Session - сеанс входа, к нему привязаны access- и refresh-токены.
+// Refresh-токен хранится только в виде SHA-256 и при каждом обновлении
+// заменяется новым.
+type Session struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	RefreshHash []byte     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, s Session) error
+	ReadSession(ctx context.Context, id uuid.UUID) (*Session, error)
+	// UpdateSession заменяет сеанс с тем же ID
+	UpdateSession(ctx context.Context, s Session) error
+	// UserSessions возвращает все сеансы пользователя, в том числе отозванные
+	UserSessions(ctx context.Context, uid uuid.UUID) ([]Session, error)
+	// DeleteSessions удаляет сеансы, истёкшие до before
+	DeleteSessions(ctx context.Context, before time.Time) (int, error)
+}
+
+// TokenConfig - ключ подписи access-токенов и время их жизни.
+// Пустой Key заменяется случайным, тогда токены не переживают перезапуск.
+type TokenConfig struct {
+	Key        []byte
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// Claims - содержимое access-токена
+type Claims struct {
+	UserID    uuid.UUID   `json:"sub"`
+	SessionID uuid.UUID   `json:"sid"`
+	Groups    []uuid.UUID `json:"groups,omitempty"`
+	IssuedAt  int64       `json:"iat"`
+	ExpiresAt int64       `json:"exp"`
+}
+
+// TokenPair - токены, выдаваемые при входе и обновлении
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// Tokens выдаёт, обновляет и отзывает токены. Access-токен - JWT (HS256),
+// refresh-токен - ID сеанса и случайный секрет.
+type Tokens struct {
+	store  SessionStore
+	users  UserStore
+	groups *UserGroupMapper
+	creds  *Credentials
+	outbox *Outbox
+	conf   TokenConfig
+}
+
+func NewTokens(store SessionStore, users UserStore, groups *UserGroupMapper, creds *Credentials, outbox *Outbox, conf TokenConfig) *Tokens {
+	if len(conf.Key) == 0 {
+		conf.Key = make([]byte, 32)
+		_, _ = rand.Read(conf.Key)
+	}
+	if conf.AccessTTL == 0 {
+		conf.AccessTTL = DefaultAccessTTL
+	}
+	if conf.RefreshTTL == 0 {
+		conf.RefreshTTL = DefaultRefreshTTL
+	}
+	return &Tokens{
+		store:  store,
+		users:  users,
+		groups: groups,
+		creds:  creds,
+		outbox: outbox,
+		conf:   conf,
+	}
+}
+
+// Login проверяет пароль и открывает новый сеанс
+func (ts *Tokens) Login(ctx context.Context, login, password string) (*TokenPair, error) {
+	u, err := ts.creds.Authenticate(ctx, login, password)
+	if err != nil {
+		return nil, err
+	}
+	ctx = WithPrincipal(ctx, *u)
+
+	var tp *TokenPair
+	err = ts.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		now := time.Now().UTC()
+		secret := newSecret()
+		s := Session{
+			ID:          uuid.New(),
+			UserID:      u.ID,
+			RefreshHash: hashSecret(secret),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ts.conf.RefreshTTL),
+		}
+		if err := ts.store.CreateSession(ctx, s); err != nil {
+			return nil, err
+		}
+		var err error
+		tp, err = ts.issue(ctx, *u, s, secret, now)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventSessionStarted, UserID: u.ID, After: s}}, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("login error: %w", err)
+	}
+	return tp, nil
+}
+
+// Refresh меняет refresh-токен на новую пару токенов. Повторное
+// использование уже заменённого refresh-токена означает его утечку,
+// поэтому сеанс при этом отзывается.
+func (ts *Tokens) Refresh(ctx context.Context, refresh string) (*TokenPair, error) {
+	sid, secret, err := splitToken(refresh)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	var tp *TokenPair
+	reused := false
+	err = ts.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		s, err := ts.store.ReadSession(ctx, sid)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		if s.RevokedAt != nil || !now.Before(s.ExpiresAt) {
+			return nil, ErrUnauthorized
+		}
+		if !hmac.Equal(s.RefreshHash, hashSecret(secret)) {
+			reused = true
+			return ts.revoke(ctx, *s, now)
+		}
+		u, err := ts.users.ReadUser(ctx, s.UserID)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrUnauthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		secret = newSecret()
+		s.RefreshHash = hashSecret(secret)
+		s.ExpiresAt = now.Add(ts.conf.RefreshTTL)
+		if err := ts.store.UpdateSession(ctx, *s); err != nil {
+			return nil, err
+		}
+		tp, err = ts.issue(ctx, *u, *s, secret, now)
+		return nil, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("refresh error: %w", err)
+	}
+	if reused {
+		return nil, ErrUnauthorized
+	}
+	return tp, nil
+}
+
+// Authenticate проверяет подпись и срок access-токена, а также то,
+// что его сеанс не отозван и пользователь не удалён
+func (ts *Tokens) Authenticate(ctx context.Context, token string) (*User, *Claims, error) {
+	c, err := ts.parse(token)
+	if err != nil {
+		return nil, nil, ErrUnauthorized
+	}
+	if time.Now().Unix() >= c.ExpiresAt {
+		return nil, nil, ErrUnauthorized
+	}
+	s, err := ts.store.ReadSession(ctx, c.SessionID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read session error: %w", err)
+	}
+	if s.RevokedAt != nil || s.UserID != c.UserID {
+		return nil, nil, ErrUnauthorized
+	}
+	u, err := ts.users.ReadUser(ctx, c.UserID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read user error: %w", err)
+	}
+	return u, c, nil
+}
+
+// Revoke отзывает сеанс sid: его токены перестают приниматься сразу
+func (ts *Tokens) Revoke(ctx context.Context, sid uuid.UUID) error {
+	err := ts.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		s, err := ts.store.ReadSession(ctx, sid)
+		if err != nil {
+			return nil, err
+		}
+		// сеансы пользователей другого арендатора не видны
+		if _, err := ts.users.ReadUser(ctx, s.UserID); err != nil {
+			return nil, NotFound("session %s not found", sid)
+		}
+		return ts.revoke(ctx, *s, time.Now().UTC())
+	})
+	if err != nil {
+		return fmt.Errorf("revoke session error: %w", err)
+	}
+	return nil
+}
+
+// RevokeUser отзывает все сеансы пользователя uid и возвращает их число
+func (ts *Tokens) RevokeUser(ctx context.Context, uid uuid.UUID) (int, error) {
+	n := 0
+	err := ts.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if _, err := ts.users.ReadUser(ctx, uid); err != nil {
+			return nil, err
+		}
+		ss, err := ts.store.UserSessions(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC()
+		var evs []Event
+		for _, s := range ss {
+			ev, err := ts.revoke(ctx, s, now)
+			if err != nil {
+				return nil, err
+			}
+			n += len(ev)
+			evs = append(evs, ev...)
+		}
+		return evs, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("revoke sessions error: %w", err)
+	}
+	return n, nil
+}
+
+// revoke отзывает сеанс, если он ещё не отозван
+func (ts *Tokens) revoke(ctx context.Context, s Session, now time.Time) ([]Event, error) {
+	if s.RevokedAt != nil {
+		return nil, nil
+	}
+	s.RevokedAt = &now
+	if err := ts.store.UpdateSession(ctx, s); err != nil {
+		return nil, err
+	}
+	return []Event{{Type: EventSessionRevoked, UserID: s.UserID, After: s}}, nil
+}
+
+// Purge удаляет сеансы, истёкшие до now
+func (ts *Tokens) Purge(ctx context.Context, now time.Time) (int, error) {
+	var n int
+	err := ts.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		n, err = ts.store.DeleteSessions(ctx, now)
+		return nil, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge sessions error: %w", err)
+	}
+	return n, nil
+}
+
+func (ts *Tokens) issue(ctx context.Context, u User, s Session, secret string, now time.Time) (*TokenPair, error) {
+	// приостановленным и заблокированным токены не выдаются
+	if err := u.CanSignIn(); err != nil {
+		return nil, err
+	}
+	gs, err := ts.groups.GetUserGroupsTransitive(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	c := Claims{
+		UserID:    u.ID,
+		SessionID: s.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ts.conf.AccessTTL).Unix(),
+	}
+	for _, m := range gs {
+		c.Groups = append(c.Groups, m.Group.ID)
+	}
+	return &TokenPair{
+		AccessToken:      ts.sign(c),
+		AccessExpiresAt:  time.Unix(c.ExpiresAt, 0).UTC(),
+		RefreshToken:     s.ID.String() + "." + secret,
+		RefreshExpiresAt: s.ExpiresAt,
+	}, nil
+}
+
+// jwtHeader - заголовок JWT, других алгоритмов подписи нет
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func (ts *Tokens) sign(c Claims) string {
+	b, _ := json.Marshal(c)
+	s := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(b)
+	return s + "." + base64.RawURLEncoding.EncodeToString(ts.mac(s))
+}
+
+func (ts *Tokens) parse(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, errors.New("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, ts.mac(parts[0]+"."+parts[1])) {
+		return nil, errors.New("bad signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	c := Claims{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (ts *Tokens) mac(s string) []byte {
+	m := hmac.New(sha256.New, ts.conf.Key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// splitToken разбирает refresh-токен или API-ключ: ID и секрет через точку
+func splitToken(token string) (uuid.UUID, string, error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return uuid.Nil, "", errors.New("malformed token")
+	}
+	id, err := uuid.Parse(token[:i])
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	return id, token[i+1:], nil
+}
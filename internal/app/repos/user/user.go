@@ -3,41 +3,122 @@ package user
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type User struct {
-	ID          uuid.UUID
-	Name        string
-	Data        string
-	Permissions int
+	ID uuid.UUID
+	// Tenant - арендатор, задаётся при создании и не меняется
+	Tenant string
+	// Name - имя входа, уникально в арендаторе, хранится в виде NormalizeName
+	Name string
+	// Email - необязательный адрес почты, уникален в арендаторе
+	Email string
+	// EmailVerified - адрес подтверждён, сбрасывается при смене адреса
+	EmailVerified bool
+	// State меняется только через SetState по разрешённым переходам
+	State State
+	// Attrs - атрибуты, описанные в схеме (Schema)
+	Attrs       Attributes
+	Permissions Permissions
+	CreatedAt   time.Time
+	// Version растёт при каждом изменении записи и проверяется при записи
+	Version int
+	// DeletedAt не нулевой у удалённых пользователей, такие записи
+	// не видны через Read и Search до восстановления или очистки
+	DeletedAt time.Time
+	DeletedBy uuid.UUID
 }
 
+// ErrVersionConflict возвращается хранилищем, если запись была изменена
+// после того, как её прочитали (версия не совпадает)
+var ErrVersionConflict = &Error{Code: CodeConflict, Message: "version conflict"}
+
 type UserStore interface {
 	CreateUser(ctx context.Context, u User) (*uuid.UUID, error)
+	// CreateUsers сохраняет пользователей и их членство в группах
+	// одной записью, данные уже проверены репозиторием
+	CreateUsers(ctx context.Context, us []User, ms []Membership) error
 	ReadUser(ctx context.Context, uid uuid.UUID) (*User, error)
-	DeleteUser(ctx context.Context, uid uuid.UUID) error
-	SearchUsers(ctx context.Context, s string) (chan User, error)
+	UpdateUser(ctx context.Context, u User) (*User, error)
+	// DeleteUser помечает пользователя удалённым, членство в группах сохраняется
+	DeleteUser(ctx context.Context, uid uuid.UUID, by uuid.UUID, at time.Time) error
+	// SearchUsers возвращает страницу выдачи, p уже проверена репозиторием
+	SearchUsers(ctx context.Context, q UserQuery, p Page) (*UserPage, error)
+	RestoreUser(ctx context.Context, uid uuid.UUID) (*User, error)
+	SearchDeletedUsers(ctx context.Context) (chan User, error)
+	// PurgeUsers окончательно удаляет пользователей, удалённых раньше before
+	PurgeUsers(ctx context.Context, before time.Time) (int, error)
+	// CountUsers возвращает число неудалённых пользователей арендатора
+	CountUsers(ctx context.Context, tenant string) (int, error)
+	// FindUserByName и FindUserByEmail ищут неудалённого пользователя
+	// по уникальному индексу, значение уже нормализовано
+	FindUserByName(ctx context.Context, name string) (*User, error)
+	FindUserByEmail(ctx context.Context, email string) (*User, error)
 }
 
 type Users struct {
 	store UserStore
+	// groups нужны для проверки групп при массовом создании
+	groups GroupStore
+	outbox *Outbox
+	schema *Schema
+	quotas Quotas
+	// dynamic пересчитывает членство в динамических группах
+	// при каждом изменении пользователя
+	dynamic *DynamicGroups
 }
 
-func NewUsers(store UserStore) *Users {
+func NewUsers(store UserStore, groups GroupStore, outbox *Outbox, schema *Schema, quotas Quotas,
+	dynamic *DynamicGroups) *Users {
 	return &Users{
-		store: store,
+		store:   store,
+		groups:  groups,
+		outbox:  outbox,
+		schema:  schema,
+		quotas:  quotas,
+		dynamic: dynamic,
 	}
 }
 
+// Create создаёт пользователя в арендаторе из ctx, а если его там нет -
+// в арендаторе u.Tenant
 func (us *Users) Create(ctx context.Context, u User) (*User, error) {
 	u.ID = uuid.New()
-	id, err := us.store.CreateUser(ctx, u)
+	u.Version = 1
+	u.CreatedAt = time.Now().UTC()
+	if t, ok := TenantFromContext(ctx); ok {
+		u.Tenant = t
+	}
+	if err := checkUser(&u); err != nil {
+		return nil, fmt.Errorf("create user error: %w", err)
+	}
+	if err := initState(&u); err != nil {
+		return nil, fmt.Errorf("create user error: %w", err)
+	}
+	err := us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if err := us.checkUserQuota(ctx, u.Tenant, 1); err != nil {
+			return nil, err
+		}
+		if err := us.schema.check(ctx, &u); err != nil {
+			return nil, err
+		}
+		id, err := us.store.CreateUser(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		u.ID = *id
+		evs, err := us.dynamic.Sync(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		return append([]Event{{Type: EventUserCreated, UserID: u.ID, After: u}}, evs...), nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("create user error: %w", err)
 	}
-	u.ID = *id
 	return &u, nil
 }
 
@@ -49,36 +130,128 @@ func (us *Users) Read(ctx context.Context, uid uuid.UUID) (*User, error) {
 	return u, nil
 }
 
+// FindByLogin находит пользователя по ID или имени входа. Если без
+// арендатора в ctx имя есть в нескольких арендаторах, возвращается Conflict.
+func (us *Users) FindByLogin(ctx context.Context, login string) (*User, error) {
+	if id, err := uuid.Parse(login); err == nil {
+		return us.Read(ctx, id)
+	}
+	return us.FindByName(ctx, login)
+}
+
+// Update сохраняет u, если u.Version совпадает с версией в хранилище.
+// При смене адреса почты подтверждение сбрасывается.
+func (us *Users) Update(ctx context.Context, u User) (*User, error) {
+	if err := checkUser(&u); err != nil {
+		return nil, fmt.Errorf("update user error: %w", err)
+	}
+	var nu *User
+	err := us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, err := us.store.ReadUser(ctx, u.ID)
+		if err != nil {
+			return nil, err
+		}
+		if u.Email != old.Email {
+			u.EmailVerified = false
+		}
+		u.State = old.State
+		if err := us.schema.check(ctx, &u); err != nil {
+			return nil, err
+		}
+		nu, err = us.store.UpdateUser(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		evs, err := us.dynamic.Sync(ctx, *nu)
+		if err != nil {
+			return nil, err
+		}
+		return append([]Event{{Type: EventUserUpdated, UserID: u.ID, Before: *old, After: *nu}}, evs...), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("update user error: %w", err)
+	}
+	return nu, nil
+}
+
 func (us *Users) Delete(ctx context.Context, uid uuid.UUID) (*User, error) {
-	u, err := us.store.ReadUser(ctx, uid)
+	var u *User
+	err := us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		u, err = us.store.ReadUser(ctx, uid)
+		if err != nil {
+			return nil, fmt.Errorf("search user error: %w", err)
+		}
+		var by uuid.UUID
+		if p, ok := PrincipalFromContext(ctx); ok {
+			by = p.ID
+		}
+		if err := us.store.DeleteUser(ctx, uid, by, time.Now()); err != nil {
+			return nil, err
+		}
+		return []Event{{Type: EventUserDeleted, UserID: uid, Before: *u}}, nil
+	})
+	return u, err
+}
+
+// Restore возвращает удалённого пользователя вместе с его членством в группах
+func (us *Users) Restore(ctx context.Context, uid uuid.UUID) (*User, error) {
+	var u *User
+	err := us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		u, err = us.store.RestoreUser(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		if err := us.checkUserQuota(ctx, u.Tenant, 0); err != nil {
+			return nil, err
+		}
+		evs, err := us.dynamic.Sync(ctx, *u)
+		if err != nil {
+			return nil, err
+		}
+		return append([]Event{{Type: EventUserRestored, UserID: uid, After: *u}}, evs...), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("restore user error: %w", err)
+	}
+	return u, nil
+}
+
+func (us *Users) SearchDeleted(ctx context.Context) (chan User, error) {
+	ch, err := us.store.SearchDeletedUsers(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("search user error: %w", err)
+		return nil, fmt.Errorf("search deleted users error: %w", err)
 	}
-	return u, us.store.DeleteUser(ctx, uid)
+	return ch, nil
 }
 
-func (us *Users) SearchUsers(ctx context.Context, s string) (chan User, error) {
-	// FIXME: здесь нужно использвоать паттерн Unit of Work
-	// бизнес-транзакция
-	chin, err := us.store.SearchUsers(ctx, s)
+// Purge окончательно удаляет пользователей, удалённых раньше before
+func (us *Users) Purge(ctx context.Context, before time.Time) (int, error) {
+	var n int
+	err := us.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		var err error
+		n, err = us.store.PurgeUsers(ctx, before)
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		return []Event{{Type: EventUsersPurged, After: Purge{DeletedBefore: before, Count: n}}}, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("purge users error: %w", err)
+	}
+	return n, nil
+}
+
+// SearchUsers возвращает страницу выдачи поиска, упорядоченную по p.Sort
+func (us *Users) SearchUsers(ctx context.Context, q UserQuery, p Page) (*UserPage, error) {
+	p, err := p.check()
 	if err != nil {
 		return nil, err
 	}
-	chout := make(chan User, 100)
-	go func() {
-		defer close(chout)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case u, ok := <-chin:
-				if !ok {
-					return
-				}
-				u.Permissions = 0755
-				chout <- u
-			}
-		}
-	}()
-	return chout, nil
+	page, err := us.store.SearchUsers(ctx, q, p)
+	if err != nil {
+		return nil, fmt.Errorf("search users error: %w", err)
+	}
+	return page, nil
 }
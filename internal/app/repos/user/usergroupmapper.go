@@ -3,27 +3,116 @@ package user
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"github.com/google/uuid"
 )
 
+// ErrMembershipCycle возвращается при попытке вложить группу саму в себя,
+// прямо или через другие группы
+var ErrMembershipCycle = &Error{Code: CodeConflict, Message: "membership cycle"}
+
 type UserGroupsStore interface {
-	AddUserToGroup(ctx context.Context, u User, g Group) error
+	// AddUserToGroup добавляет u в g с ролью role до expiresAt (нулевое
+	// время - бессрочно), если u уже в группе - меняет роль и срок
+	AddUserToGroup(ctx context.Context, u User, g Group, role Role, expiresAt time.Time) error
 	DeleteUserFromGroup(ctx context.Context, u User, g Group) error
-	GetUserGroups(ctx context.Context, u User) (chan Group, error)
-	GetGroupUsers(ctx context.Context, g Group) (chan User, error)
+	// SetUserRole меняет роль участника группы и возвращает прежнюю
+	SetUserRole(ctx context.Context, u User, g Group, role Role) (Role, error)
+	// ExtendMembership меняет срок членства и возвращает прежний
+	ExtendMembership(ctx context.Context, u User, g Group, expiresAt time.Time) (time.Time, error)
+	// ExpireMemberships удаляет членство, истёкшее к now, и возвращает его
+	ExpireMemberships(ctx context.Context, now time.Time) ([]Membership, error)
+	// GetUserGroups возвращает группы, в которые u входит напрямую,
+	// с его ролью в них, Path состоит из самой группы.
+	// Истёкшее членство не возвращается, даже если ещё не удалено.
+	GetUserGroups(ctx context.Context, u User) (chan GroupMembership, error)
+	// GetGroupUsers возвращает прямых участников g с их ролями
+	GetGroupUsers(ctx context.Context, g Group) (chan UserMembership, error)
+
+	// AddGroupToGroup делает child участником parent,
+	// если это не приводит к циклу
+	AddGroupToGroup(ctx context.Context, child, parent Group) error
+	DeleteGroupFromGroup(ctx context.Context, child, parent Group) error
+	// GetGroupParents возвращает группы, в которые g входит напрямую
+	GetGroupParents(ctx context.Context, g Group) (chan Group, error)
+	// GetGroupChildren возвращает группы, которые напрямую входят в g
+	GetGroupChildren(ctx context.Context, g Group) (chan Group, error)
+}
+
+// GroupMembership - группа, в которую пользователь входит напрямую или через
+// другие группы. Path начинается с группы, в которую пользователь входит
+// напрямую, и заканчивается самой Group. Role - роль пользователя
+// в первой группе Path.
+type GroupMembership struct {
+	Group Group
+	Role  Role
+	// ExpiresAt - срок членства в первой группе Path, нулевой - бессрочно
+	ExpiresAt time.Time
+	Path      []Group
+}
+
+// UserMembership - участник группы. Path начинается с группы, в которую
+// пользователь входит напрямую, и заканчивается запрошенной группой.
+// Role - роль пользователя в первой группе Path.
+type UserMembership struct {
+	User      User
+	Role      Role
+	ExpiresAt time.Time
+	Path      []Group
 }
 
 type UserGroupMapper struct {
-	store UserGroupsStore
+	store  UserGroupsStore
+	outbox *Outbox
+	now    func() time.Time
 }
 
-func NewUserGroups(store UserGroupsStore) *UserGroupMapper {
+// NewUserGroups создаёт UserGroupMapper, now - часы, по которым
+// проверяется срок членства, nil - time.Now
+func NewUserGroups(store UserGroupsStore, outbox *Outbox, now func() time.Time) *UserGroupMapper {
+	if now == nil {
+		now = time.Now
+	}
 	return &UserGroupMapper{
-		store: store,
+		store:  store,
+		outbox: outbox,
+		now:    now,
 	}
 }
 
-func (ugm *UserGroupMapper) AddUserToGroup(ctx context.Context, u User, g Group) error {
-	err := ugm.store.AddUserToGroup(ctx, u, g)
+// Now - текущее время по часам, по которым истекает членство
+func (ugm *UserGroupMapper) Now() time.Time {
+	return ugm.now()
+}
+
+// AddUserToGroup добавляет u в g до expiresAt, пустая role означает
+// RoleMember, нулевой expiresAt - бессрочное членство. Состав динамических
+// групп через UserGroupMapper не меняется.
+func (ugm *UserGroupMapper) AddUserToGroup(ctx context.Context, u User, g Group, role Role, expiresAt time.Time) error {
+	if g.Dynamic() {
+		return ErrDynamicGroup
+	}
+	if role == "" {
+		role = RoleMember
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(ugm.now()) {
+		return InvalidField("expires_at", "must be in the future")
+	}
+	if err := checkTenants(u.Tenant, g.Tenant); err != nil {
+		return err
+	}
+	err := ugm.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if err := ugm.store.AddUserToGroup(ctx, u, g, role, expiresAt); err != nil {
+			return nil, err
+		}
+		return []Event{{
+			Type:    EventMembershipAdded,
+			UserID:  u.ID,
+			GroupID: g.ID,
+			After:   Membership{UserID: u.ID, GroupID: g.ID, Role: role, ExpiresAt: expiry(expiresAt)},
+		}}, nil
+	})
 	if err != nil {
 		return fmt.Errorf("error: %w", err)
 	}
@@ -31,14 +120,116 @@ func (ugm *UserGroupMapper) AddUserToGroup(ctx context.Context, u User, g Group)
 }
 
 func (ugm *UserGroupMapper) DeleteUserFromGroup(ctx context.Context, u User, g Group) error {
-	err := ugm.store.DeleteUserFromGroup(ctx, u, g)
+	if g.Dynamic() {
+		return ErrDynamicGroup
+	}
+	err := ugm.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if err := ugm.store.DeleteUserFromGroup(ctx, u, g); err != nil {
+			return nil, err
+		}
+		return []Event{{
+			Type:    EventMembershipRemoved,
+			UserID:  u.ID,
+			GroupID: g.ID,
+			Before:  Membership{UserID: u.ID, GroupID: g.ID},
+		}}, nil
+	})
 	if err != nil {
 		return fmt.Errorf("error: %w", err)
 	}
 	return nil
 }
 
-func (ugm *UserGroupMapper) GetUserGroups(ctx context.Context, u User) (chan Group, error) {
+// SetUserRole меняет роль участника группы
+func (ugm *UserGroupMapper) SetUserRole(ctx context.Context, u User, g Group, role Role) error {
+	if g.Dynamic() {
+		return ErrDynamicGroup
+	}
+	err := ugm.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, err := ugm.store.SetUserRole(ctx, u, g, role)
+		if err != nil {
+			return nil, err
+		}
+		if old == role {
+			return nil, nil
+		}
+		return []Event{{
+			Type:    EventMembershipRoleChanged,
+			UserID:  u.ID,
+			GroupID: g.ID,
+			Before:  Membership{UserID: u.ID, GroupID: g.ID, Role: old},
+			After:   Membership{UserID: u.ID, GroupID: g.ID, Role: role},
+		}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("set role error: %w", err)
+	}
+	return nil
+}
+
+// ExtendMembership переносит окончание членства u в g на expiresAt,
+// нулевой expiresAt делает членство бессрочным
+func (ugm *UserGroupMapper) ExtendMembership(ctx context.Context, u User, g Group, expiresAt time.Time) error {
+	if g.Dynamic() {
+		return ErrDynamicGroup
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(ugm.now()) {
+		return InvalidField("expires_at", "must be in the future")
+	}
+	err := ugm.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		old, err := ugm.store.ExtendMembership(ctx, u, g, expiresAt)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{{
+			Type:    EventMembershipExtended,
+			UserID:  u.ID,
+			GroupID: g.ID,
+			Before:  Membership{UserID: u.ID, GroupID: g.ID, ExpiresAt: expiry(old)},
+			After:   Membership{UserID: u.ID, GroupID: g.ID, ExpiresAt: expiry(expiresAt)},
+		}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("extend membership error: %w", err)
+	}
+	return nil
+}
+
+// Expire удаляет членство, истёкшее к now, и для каждого
+// записывает событие EventMembershipRemoved
+func (ugm *UserGroupMapper) Expire(ctx context.Context, now time.Time) (int, error) {
+	var n int
+	err := ugm.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		ms, err := ugm.store.ExpireMemberships(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		n = len(ms)
+		evs := make([]Event, 0, len(ms))
+		for _, m := range ms {
+			evs = append(evs, Event{
+				Type:    EventMembershipRemoved,
+				UserID:  m.UserID,
+				GroupID: m.GroupID,
+				Before:  m,
+			})
+		}
+		return evs, nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("expire memberships error: %w", err)
+	}
+	return n, nil
+}
+
+func expiry(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (ugm *UserGroupMapper) GetUserGroups(ctx context.Context, u User) (chan GroupMembership, error) {
 	ug, err := ugm.store.GetUserGroups(ctx, u)
 	if err != nil {
 		return nil, fmt.Errorf("error: %w", err)
@@ -46,10 +237,153 @@ func (ugm *UserGroupMapper) GetUserGroups(ctx context.Context, u User) (chan Gro
 	return ug, nil
 }
 
-func (ugm *UserGroupMapper) GetGroupUsers(ctx context.Context, g Group) (chan User, error) {
+// UserRole возвращает роль, с которой u входит в g напрямую,
+// ok=false, если u не участник g
+func (ugm *UserGroupMapper) UserRole(ctx context.Context, u User, g Group) (role Role, ok bool, err error) {
+	ch, err := ugm.store.GetUserGroups(ctx, u)
+	if err != nil {
+		return "", false, fmt.Errorf("error: %w", err)
+	}
+	for m := range ch {
+		if m.Group.ID == g.ID {
+			role, ok = m.Role, true
+		}
+	}
+	return role, ok, nil
+}
+
+func (ugm *UserGroupMapper) GetGroupUsers(ctx context.Context, g Group) (chan UserMembership, error) {
 	gu, err := ugm.store.GetGroupUsers(ctx, g)
 	if err != nil {
 		return nil, fmt.Errorf("error: %w", err)
 	}
 	return gu, nil
 }
+
+func (ugm *UserGroupMapper) AddGroupToGroup(ctx context.Context, child, parent Group) error {
+	if parent.Dynamic() {
+		return ErrDynamicGroup
+	}
+	if err := checkTenants(child.Tenant, parent.Tenant); err != nil {
+		return err
+	}
+	err := ugm.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if err := ugm.store.AddGroupToGroup(ctx, child, parent); err != nil {
+			return nil, err
+		}
+		return []Event{{
+			Type:     EventGroupNested,
+			GroupID:  child.ID,
+			ParentID: parent.ID,
+			After:    Membership{GroupID: child.ID, ParentID: parent.ID},
+		}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
+func (ugm *UserGroupMapper) DeleteGroupFromGroup(ctx context.Context, child, parent Group) error {
+	err := ugm.outbox.Do(ctx, func(ctx context.Context) ([]Event, error) {
+		if err := ugm.store.DeleteGroupFromGroup(ctx, child, parent); err != nil {
+			return nil, err
+		}
+		return []Event{{
+			Type:     EventGroupUnnested,
+			GroupID:  child.ID,
+			ParentID: parent.ID,
+			Before:   Membership{GroupID: child.ID, ParentID: parent.ID},
+		}}, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
+// GetUserGroupsTransitive возвращает все группы пользователя, включая те,
+// в которые он входит через вложенные группы. Для каждой группы
+// возвращается кратчайший путь.
+func (ugm *UserGroupMapper) GetUserGroupsTransitive(ctx context.Context, u User) ([]GroupMembership, error) {
+	ch, err := ugm.store.GetUserGroups(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+
+	res := []GroupMembership{}
+	seen := make(map[uuid.UUID]struct{})
+	for m := range ch {
+		if _, ok := seen[m.Group.ID]; !ok {
+			seen[m.Group.ID] = struct{}{}
+			res = append(res, m)
+		}
+	}
+
+	// обход в ширину вверх по родительским группам
+	for i := 0; i < len(res); i++ {
+		m := res[i]
+		ch, err := ugm.store.GetGroupParents(ctx, m.Group)
+		if err != nil {
+			return nil, fmt.Errorf("error: %w", err)
+		}
+		for p := range ch {
+			if _, ok := seen[p.ID]; ok {
+				continue
+			}
+			seen[p.ID] = struct{}{}
+			path := make([]Group, len(m.Path), len(m.Path)+1)
+			copy(path, m.Path)
+			res = append(res, GroupMembership{Group: p, Role: m.Role, ExpiresAt: m.ExpiresAt, Path: append(path, p)})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetGroupUsersTransitive возвращает всех пользователей группы, включая
+// участников вложенных групп. Для каждого пользователя возвращается
+// кратчайший путь.
+func (ugm *UserGroupMapper) GetGroupUsersTransitive(ctx context.Context, g Group) ([]UserMembership, error) {
+	// пути от каждой вложенной группы до g, обход в ширину вниз
+	groups := []GroupMembership{{Group: g, Path: []Group{g}}}
+	seen := map[uuid.UUID]struct{}{g.ID: {}}
+	for i := 0; i < len(groups); i++ {
+		m := groups[i]
+		ch, err := ugm.store.GetGroupChildren(ctx, m.Group)
+		if err != nil {
+			return nil, fmt.Errorf("error: %w", err)
+		}
+		for c := range ch {
+			if _, ok := seen[c.ID]; ok {
+				continue
+			}
+			seen[c.ID] = struct{}{}
+			path := make([]Group, 0, len(m.Path)+1)
+			path = append(path, c)
+			groups = append(groups, GroupMembership{Group: c, Path: append(path, m.Path...)})
+		}
+	}
+
+	res := []UserMembership{}
+	users := make(map[uuid.UUID]struct{})
+	for _, m := range groups {
+		ch, err := ugm.store.GetGroupUsers(ctx, m.Group)
+		if err != nil {
+			return nil, fmt.Errorf("error: %w", err)
+		}
+		for um := range ch {
+			if _, ok := users[um.User.ID]; ok {
+				continue
+			}
+			users[um.User.ID] = struct{}{}
+			res = append(res, UserMembership{User: um.User, Role: um.Role, ExpiresAt: um.ExpiresAt, Path: m.Path})
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
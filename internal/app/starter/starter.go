@@ -2,18 +2,39 @@ package starter
 
 import (
 	"context"
+	"log"
 	"sync"
+	"time"
 
+	"gb-backend2/internal/app/events"
+	"gb-backend2/internal/app/repos/user"
 	"gb-backend2/internal/app/store"
 )
 
+// как часто удалять окончательно пользователей и группы с истёкшим сроком хранения
+const purgeInterval = time.Hour
+
+// как часто удалять истёкшее членство в группах и истёкшие сеансы входа
+const sweepInterval = time.Minute
+
 type App struct {
 	st *store.Store
+	// retention - сколько хранить удалённых пользователей и группы,
+	// 0 - хранить бессрочно
+	retention time.Duration
+	workers   []Worker
+	// Subscribers - подписчики на события внутри процесса,
+	// подписаться можно и после запуска
+	Subscribers *events.Subscribers
 }
 
-func NewApp(st *store.Store) *App {
+// NewApp создаёт приложение, subs получают события от диспетчера из workers
+func NewApp(st *store.Store, retention time.Duration, subs *events.Subscribers, workers ...Worker) *App {
 	a := &App{
-		st: st,
+		st:          st,
+		retention:   retention,
+		workers:     workers,
+		Subscribers: subs,
 	}
 	return a
 }
@@ -23,9 +44,91 @@ type APIServer interface {
 	Stop()
 }
 
+// Worker - фоновая задача, работает, пока не отменён ctx
+type Worker interface {
+	Run(ctx context.Context)
+}
+
 func (a *App) Serve(ctx context.Context, wg *sync.WaitGroup, hs APIServer) {
 	defer wg.Done()
 	hs.Start()
+	if a.retention > 0 {
+		wg.Add(1)
+		go a.purge(ctx, wg)
+	}
+	wg.Add(1)
+	go a.sweep(ctx, wg)
+	for _, w := range a.workers {
+		wg.Add(1)
+		go func(w Worker) {
+			defer wg.Done()
+			w.Run(ctx)
+		}(w)
+	}
 	<-ctx.Done()
 	hs.Stop()
 }
+
+func (a *App) purge(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	t := time.NewTicker(purgeInterval)
+	defer t.Stop()
+	for {
+		a.purgeOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// purgeOnce и sweep обходят записи всех арендаторов
+func (a *App) purgeOnce(ctx context.Context) {
+	ctx = user.AllTenants(ctx)
+	before := time.Now().Add(-a.retention)
+	if n, err := a.st.User.Purge(ctx, before); err != nil {
+		log.Println(err)
+	} else if n > 0 {
+		log.Printf("purged %d users", n)
+	}
+	if n, err := a.st.Group.Purge(ctx, before); err != nil {
+		log.Println(err)
+	} else if n > 0 {
+		log.Printf("purged %d groups", n)
+	}
+}
+
+// sweep удаляет истёкшее членство в группах, истёкшие сеансы входа
+// и приглашения, а также завершает просроченные кампании пересмотра доступа.
+// Пока членство не удалено, хранилища его уже не показывают, так что
+// задержка влияет только на момент события об удалении.
+func (a *App) sweep(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ctx = user.AllTenants(ctx)
+	t := time.NewTicker(sweepInterval)
+	defer t.Stop()
+	for {
+		if n, err := a.st.UserGroup.Expire(ctx, a.st.UserGroup.Now()); err != nil {
+			log.Println(err)
+		} else if n > 0 {
+			log.Printf("expired %d memberships", n)
+		}
+		if _, err := a.st.Tokens.Purge(ctx, time.Now()); err != nil {
+			log.Println(err)
+		}
+		if _, err := a.st.Invitations.Purge(ctx, time.Now()); err != nil {
+			log.Println(err)
+		}
+		if n, err := a.st.Reviews.Expire(ctx, time.Now()); err != nil {
+			log.Println(err)
+		} else if n > 0 {
+			log.Printf("expired %d access reviews", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
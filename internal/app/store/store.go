@@ -1,24 +1,175 @@
 package store
 
 import (
+	"context"
+	"crypto/ed25519"
+	"time"
+
+	"gb-backend2/internal/app/repos/audit"
 	"gb-backend2/internal/app/repos/user"
 	"gb-backend2/internal/db/mem/memstore"
 )
 
+// Transactor выполняет fn атомарно: изменения всех вызовов хранилища,
+// сделанных с переданным в fn контекстом, применяются, только если fn
+// вернула nil. Хранилище на SQL открывает *sql.Tx и кладёт его в контекст,
+// memstore меняет данные на месте и откатывает их по журналу отката.
+type Transactor interface {
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
 type Store struct {
 	User      *user.Users
+	Schema    *user.Schema
 	Group     *user.Groups
 	UserGroup *user.UserGroupMapper
+	Outbox    *user.Outbox
+	Audit     *audit.Log
+	// Credentials - пароли пользователей
+	Credentials *user.Credentials
+	// Tokens - сеансы входа и токены доступа
+	Tokens *user.Tokens
+	// APIKeys - ключи доступа для вызовов без входа
+	APIKeys *user.APIKeys
+	// Invitations - приглашения в группы
+	Invitations *user.Invitations
+	// Dynamic - членство в динамических группах
+	Dynamic *user.DynamicGroups
+	// JoinRequests - заявки на вступление в группы
+	JoinRequests *user.JoinRequests
+	// Reviews - кампании пересмотра доступа
+	Reviews *user.Reviews
+
+	tx Transactor
+}
+
+type options struct {
+	audit      audit.AuditStore
+	policy     user.PasswordPolicy
+	bcryptCost int
+	sessions   user.SessionStore
+	tokens     user.TokenConfig
+	quotas     user.Quotas
+	inviteTTL  time.Duration
+	reviewKey  ed25519.PrivateKey
+	now        func() time.Time
+}
+
+type Option func(*options)
+
+// WithAuditStore задаёт хранилище журнала аудита, по умолчанию журнал
+// хранится вместе с остальными данными. Если as - audit.TxStore, записи
+// откатившихся транзакций в него не попадают.
+func WithAuditStore(as audit.AuditStore) Option {
+	return func(o *options) {
+		o.audit = as
+	}
+}
+
+// WithPasswordPolicy задаёт требования к новым паролям,
+// по умолчанию user.DefaultPasswordPolicy
+func WithPasswordPolicy(p user.PasswordPolicy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithBcryptCost задаёт сложность хеширования паролей,
+// в тестах удобно bcrypt.MinCost
+func WithBcryptCost(cost int) Option {
+	return func(o *options) {
+		o.bcryptCost = cost
+	}
+}
+
+// WithSessionStore задаёт хранилище сеансов входа (refresh-токенов),
+// по умолчанию сеансы хранятся вместе с остальными данными
+func WithSessionStore(ss user.SessionStore) Option {
+	return func(o *options) {
+		o.sessions = ss
+	}
+}
+
+// WithTokenConfig задаёт ключ подписи и время жизни токенов
+func WithTokenConfig(c user.TokenConfig) Option {
+	return func(o *options) {
+		o.tokens = c
+	}
+}
+
+// WithQuotas задаёт квоты арендаторов на число пользователей и групп,
+// по умолчанию квот нет
+func WithQuotas(q user.Quotas) Option {
+	return func(o *options) {
+		o.quotas = q
+	}
+}
+
+// WithInviteTTL задаёт срок действия приглашений в группы,
+// по умолчанию user.DefaultInviteTTL
+func WithInviteTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.inviteTTL = ttl
+	}
 }
 
-func NewStore() (*Store, error) {
+// WithReviewKey задаёт закрытый ключ Ed25519 подписи отчётов о пересмотре
+// доступа, по умолчанию случайный
+func WithReviewKey(key ed25519.PrivateKey) Option {
+	return func(o *options) {
+		o.reviewKey = key
+	}
+}
+
+// WithClock задаёт часы, по которым истекает членство в группах,
+// по умолчанию time.Now
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+func NewStore(opts ...Option) (*Store, error) {
 	var store Store
 
 	s := memstore.NewStore()
 
-	store.User = user.NewUsers(s)
-	store.Group = user.NewGroups(s)
-	store.UserGroup = user.NewUserGroups(s)
+	o := options{
+		audit:    s,
+		sessions: s,
+		policy:   user.DefaultPasswordPolicy,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.now != nil {
+		s.UseClock(o.now)
+	}
+	// отдельное хранилище журнала пишет записи только при фиксации транзакции
+	if ts, ok := o.audit.(audit.TxStore); ok {
+		ts.UseCommitter(s)
+	}
+	store.Audit = audit.NewLog(o.audit)
+	store.Outbox = user.NewOutbox(s, s, store.Audit)
+	store.Schema = user.NewSchema(s, s, store.Outbox)
+	store.Dynamic = user.NewDynamicGroups(s, s, s)
+	store.User = user.NewUsers(s, s, store.Outbox, store.Schema, o.quotas, store.Dynamic)
+	store.Group = user.NewGroups(s, store.Outbox, o.quotas, store.Dynamic)
+	store.UserGroup = user.NewUserGroups(s, store.Outbox, o.now)
+	store.Credentials = user.NewCredentials(s, s, store.Outbox, o.policy, o.bcryptCost)
+	store.Tokens = user.NewTokens(o.sessions, s, store.UserGroup, store.Credentials, store.Outbox, o.tokens)
+	store.APIKeys = user.NewAPIKeys(s, s, store.Outbox)
+	store.Invitations = user.NewInvitations(s, s, s, store.UserGroup, store.Outbox, o.inviteTTL)
+	store.JoinRequests = user.NewJoinRequests(s, s, s, store.UserGroup, store.Outbox)
+	store.Reviews = user.NewReviews(s, s, s, store.UserGroup, store.Outbox, o.reviewKey)
+	store.tx = s
 
 	return &store, nil
 }
+
+// InTx выполняет бизнес-операцию fn как одну транзакцию (Unit of Work).
+// Внутри fn репозитории нужно вызывать с контекстом, переданным в fn.
+func (s *Store) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.tx.RunInTx(ctx, fn)
+}
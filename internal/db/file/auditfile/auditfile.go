@@ -0,0 +1,176 @@
+package auditfile
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"gb-backend2/internal/app/repos/audit"
+)
+
+var _ audit.TxStore = &Store{}
+
+// Store хранит журнал аудита в NDJSON-файле, который только дописывается.
+// Для поиска записи держатся и в памяти.
+// Записи транзакции основного хранилища копятся в pending и попадают
+// в файл только при её фиксации (UseCommitter).
+type Store struct {
+	sync.Mutex
+	f       *os.File
+	entries []audit.Entry
+	tx      audit.Committer
+	pending []audit.Entry
+}
+
+// Open открывает файл журнала, создавая его при необходимости, читает
+// уже записанные записи и проверяет их цепочку хешей
+func Open(path string) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	st := &Store{
+		f: f,
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		e := audit.Entry{}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		st.entries = append(st.entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if seq := verify(st.entries); seq != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s: audit chain is broken at entry %d", path, seq)
+	}
+	return st, nil
+}
+
+// verify возвращает номер первой записи, нарушающей цепочку, или 0
+func verify(es []audit.Entry) uint64 {
+	prev := ""
+	for i, e := range es {
+		seq := uint64(i + 1)
+		if e.Seq != seq || e.PrevHash != prev || e.Hash != audit.Hash(e) {
+			return seq
+		}
+		prev = e.Hash
+	}
+	return 0
+}
+
+// UseCommitter связывает журнал с транзакциями основного хранилища
+func (st *Store) UseCommitter(c audit.Committer) {
+	st.Lock()
+	defer st.Unlock()
+	st.tx = c
+}
+
+func (st *Store) Close() error {
+	return st.f.Close()
+}
+
+func (st *Store) LastEntry(ctx context.Context) (*audit.Entry, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	// записи ещё не зафиксированной транзакции продолжают цепочку:
+	// пока она открыта, основное хранилище не пускает другие
+	if len(st.pending) > 0 {
+		e := st.pending[len(st.pending)-1]
+		return &e, nil
+	}
+	if len(st.entries) == 0 {
+		return nil, nil
+	}
+	e := st.entries[len(st.entries)-1]
+	return &e, nil
+}
+
+func (st *Store) AppendEntry(ctx context.Context, e audit.Entry) error {
+	st.Lock()
+	defer st.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if len(st.pending) == 0 && (st.tx == nil || !st.tx.OnCommit(ctx, st.commit, st.rollback)) {
+		return st.write([]audit.Entry{e})
+	}
+	st.pending = append(st.pending, e)
+	return nil
+}
+
+// commit пишет записи зафиксированной транзакции
+func (st *Store) commit() error {
+	st.Lock()
+	defer st.Unlock()
+
+	es := st.pending
+	st.pending = nil
+	return st.write(es)
+}
+
+// rollback отбрасывает записи откатившейся транзакции
+func (st *Store) rollback() {
+	st.Lock()
+	defer st.Unlock()
+
+	st.pending = nil
+}
+
+// write дописывает es в файл одним вызовом и ждёт записи на диск
+func (st *Store) write(es []audit.Entry) error {
+	var buf []byte
+	for _, e := range es {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	if _, err := st.f.Write(buf); err != nil {
+		return err
+	}
+	if err := st.f.Sync(); err != nil {
+		return err
+	}
+	st.entries = append(st.entries, es...)
+	return nil
+}
+
+func (st *Store) SearchEntries(ctx context.Context, f audit.Filter) ([]audit.Entry, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	res := []audit.Entry{}
+	for _, e := range st.entries {
+		if f.Limit > 0 && len(res) >= f.Limit {
+			break
+		}
+		if e.Seq > f.After && f.Match(e) {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
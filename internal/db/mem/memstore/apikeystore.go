@@ -0,0 +1,118 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+var _ user.APIKeyStore = &Store{}
+
+func (st *Store) CreateAPIKey(ctx context.Context, k user.APIKey) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.apikeys[k.ID]; ok {
+		return user.AlreadyExists("api key %s already exists", k.ID)
+	}
+	d.saveAPIKey(k.ID)
+	d.apikeys[k.ID] = cloneAPIKey(k)
+	return nil
+}
+
+func (st *Store) ReadAPIKey(ctx context.Context, id uuid.UUID) (*user.APIKey, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	k, ok := d.apikeys[id]
+	if !ok {
+		return nil, user.NotFound("api key %s not found", id)
+	}
+	k = cloneAPIKey(k)
+	return &k, nil
+}
+
+func (st *Store) UserAPIKeys(ctx context.Context, uid uuid.UUID) ([]user.APIKey, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	kk := []user.APIKey{}
+	for _, k := range d.apikeys {
+		if k.UserID == uid {
+			kk = append(kk, cloneAPIKey(k))
+		}
+	}
+	return kk, nil
+}
+
+func (st *Store) DeleteAPIKey(ctx context.Context, id uuid.UUID) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.apikeys[id]; !ok {
+		return user.NotFound("api key %s not found", id)
+	}
+	d.saveAPIKey(id)
+	delete(d.apikeys, id)
+	return nil
+}
+
+func (st *Store) TouchAPIKey(ctx context.Context, id uuid.UUID, at time.Time) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	k, ok := d.apikeys[id]
+	if !ok {
+		return user.NotFound("api key %s not found", id)
+	}
+	k.LastUsedAt = &at
+	d.saveAPIKey(id)
+	d.apikeys[id] = k
+	return nil
+}
+
+func cloneAPIKey(k user.APIKey) user.APIKey {
+	k.Hash = append([]byte(nil), k.Hash...)
+	if k.ExpiresAt != nil {
+		t := *k.ExpiresAt
+		k.ExpiresAt = &t
+	}
+	if k.LastUsedAt != nil {
+		t := *k.LastUsedAt
+		k.LastUsedAt = &t
+	}
+	return k
+}
@@ -0,0 +1,73 @@
+package memstore
+
+import (
+	"context"
+
+	"gb-backend2/internal/app/repos/user"
+)
+
+var _ user.AttrStore = &Store{}
+
+func (st *Store) PutAttr(ctx context.Context, def user.AttrDef) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if def.Unique {
+		ix, err := d.indexAttr(def.Name)
+		if err != nil {
+			return err
+		}
+		d.saveAttrIndex(def.Name)
+		d.uattrs[def.Name] = ix
+	} else if _, ok := d.uattrs[def.Name]; ok {
+		d.saveAttrIndex(def.Name)
+		delete(d.uattrs, def.Name)
+	}
+	def.Enum = append([]string(nil), def.Enum...)
+	d.saveAttr(def.Name)
+	d.attrs[def.Name] = def
+	return nil
+}
+
+func (st *Store) DeleteAttr(ctx context.Context, name string) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.attrs[name]; !ok {
+		return user.NotFound("attribute %s not found", name)
+	}
+	d.saveAttrIndex(name)
+	delete(d.uattrs, name)
+	d.saveAttr(name)
+	delete(d.attrs, name)
+	return nil
+}
+
+func (st *Store) ListAttrs(ctx context.Context) ([]user.AttrDef, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	defs := make([]user.AttrDef, 0, len(d.attrs))
+	for _, def := range d.attrs {
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
@@ -0,0 +1,83 @@
+package memstore
+
+import (
+	"context"
+	"gb-backend2/internal/app/repos/audit"
+)
+
+var _ audit.AuditStore = &Store{}
+
+func (st *Store) LastEntry(ctx context.Context) (*audit.Entry, error) {
+	t, unlock := st.lockTx(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	// пока транзакция открыта, st.audit не меняется: RunInTx держит блокировку
+	if t != nil && len(t.audit) > 0 {
+		e := t.audit[len(t.audit)-1]
+		return &e, nil
+	}
+	if len(st.audit) > 0 {
+		e := st.audit[len(st.audit)-1]
+		return &e, nil
+	}
+	return nil, nil
+}
+
+func (st *Store) AppendEntry(ctx context.Context, e audit.Entry) error {
+	t, unlock := st.lockTx(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if t != nil {
+		t.audit = append(t.audit, e)
+	} else {
+		st.audit = append(st.audit, e)
+	}
+	return nil
+}
+
+func (st *Store) SearchEntries(ctx context.Context, f audit.Filter) ([]audit.Entry, error) {
+	t, unlock := st.lockTx(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	entries := st.audit
+	if t != nil {
+		entries = append(entries[:len(entries):len(entries)], t.audit...)
+	}
+
+	// номера записей идут подряд с 1, поэтому страница начинается с индекса After
+	start := 0
+	if f.After < uint64(len(entries)) {
+		start = int(f.After)
+	} else {
+		start = len(entries)
+	}
+
+	res := []audit.Entry{}
+	for _, e := range entries[start:] {
+		if f.Limit > 0 && len(res) >= f.Limit {
+			break
+		}
+		if e.Seq > f.After && f.Match(e) {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
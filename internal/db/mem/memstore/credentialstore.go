@@ -0,0 +1,45 @@
+package memstore
+
+import (
+	"context"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+var _ user.CredentialStore = &Store{}
+
+func (st *Store) PutCredential(ctx context.Context, c user.Credential) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	c.Hash = append([]byte(nil), c.Hash...)
+	d.saveCredential(c.UserID)
+	d.cred[c.UserID] = c
+	return nil
+}
+
+func (st *Store) ReadCredential(ctx context.Context, uid uuid.UUID) (*user.Credential, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	c, ok := d.cred[uid]
+	if !ok {
+		return nil, user.NotFound("credential of user %s not found", uid)
+	}
+	c.Hash = append([]byte(nil), c.Hash...)
+	return &c, nil
+}
@@ -0,0 +1,68 @@
+package memstore
+
+import (
+	"context"
+	"gb-backend2/internal/app/repos/user"
+)
+
+var _ user.EventStore = &Store{}
+
+func (st *Store) AppendEvents(ctx context.Context, evs ...user.Event) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	d.saveEvents()
+	for _, ev := range evs {
+		d.seq++
+		ev.Seq = d.seq
+		d.ev = append(d.ev, ev)
+	}
+	return nil
+}
+
+func (st *Store) ReadEvents(ctx context.Context, after uint64, limit int) ([]user.Event, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	res := []user.Event{}
+	for _, ev := range d.ev {
+		if len(res) >= limit {
+			break
+		}
+		if ev.Seq > after {
+			res = append(res, ev)
+		}
+	}
+	return res, nil
+}
+
+func (st *Store) DeleteEvents(ctx context.Context, upTo uint64) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	i := 0
+	for i < len(d.ev) && d.ev[i].Seq <= upTo {
+		i++
+	}
+	d.saveEvents()
+	d.ev = append([]user.Event(nil), d.ev[i:]...)
+	return nil
+}
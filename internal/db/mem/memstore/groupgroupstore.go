@@ -0,0 +1,111 @@
+package memstore
+
+import (
+	"context"
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func (st *Store) AddGroupToGroup(ctx context.Context, child, parent user.Group) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
+
+	if d.isAncestor(child.ID, parent.ID) {
+		return user.ErrMembershipCycle
+	}
+
+	if _, ok := d.gp[child.ID]; !ok {
+		d.gp[child.ID] = make(map[uuid.UUID]struct{})
+	}
+	if _, ok := d.gc[parent.ID]; !ok {
+		d.gc[parent.ID] = make(map[uuid.UUID]struct{})
+	}
+
+	d.saveEdge(d.gp, child.ID, parent.ID)
+	d.saveEdge(d.gc, parent.ID, child.ID)
+	d.gp[child.ID][parent.ID] = struct{}{}
+	d.gc[parent.ID][child.ID] = struct{}{}
+	return nil
+}
+
+// isAncestor сообщает, что группа a совпадает с g или является одной из её
+// родительских групп на любом уровне. Вызывается под блокировкой.
+func (d *data) isAncestor(a, g uuid.UUID) bool {
+	seen := map[uuid.UUID]struct{}{g: {}}
+	queue := []uuid.UUID{g}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == a {
+			return true
+		}
+		for p := range d.gp[id] {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				queue = append(queue, p)
+			}
+		}
+	}
+	return false
+}
+
+func (st *Store) DeleteGroupFromGroup(ctx context.Context, child, parent user.Group) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
+
+	d.saveEdge(d.gp, child.ID, parent.ID)
+	d.saveEdge(d.gc, parent.ID, child.ID)
+	delete(d.gp[child.ID], parent.ID)
+	delete(d.gc[parent.ID], child.ID)
+
+	return nil
+}
+
+func (st *Store) GetGroupParents(ctx context.Context, g user.Group) (chan user.Group, error) {
+	return st.relatedGroups(ctx, func(d *data) map[uuid.UUID]struct{} { return d.gp[g.ID] })
+}
+
+func (st *Store) GetGroupChildren(ctx context.Context, g user.Group) (chan user.Group, error) {
+	return st.relatedGroups(ctx, func(d *data) map[uuid.UUID]struct{} { return d.gc[g.ID] })
+}
+
+func (st *Store) relatedGroups(ctx context.Context, rel func(d *data) map[uuid.UUID]struct{}) (chan user.Group, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	var gs []user.Group
+	for i := range rel(d) {
+		if g := d.g[i]; g.DeletedAt.IsZero() {
+			gs = append(gs, g)
+		}
+	}
+	return groupChan(gs), nil
+}
@@ -2,8 +2,8 @@ package memstore
 
 import (
 	"context"
-	"database/sql"
 	"gb-backend2/internal/app/repos/user"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,81 +13,292 @@ import (
 var _ user.GroupStore = &Store{}
 
 func (st *Store) CreateGroup(ctx context.Context, g user.Group) (*uuid.UUID, error) {
-	st.Lock()
-	defer st.Unlock()
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
 
-	st.g[g.ID] = g
+	if err := d.indexGroup(g, nil); err != nil {
+		return nil, err
+	}
+	d.saveGroup(g.ID)
+	d.g[g.ID] = g
 	return &g.ID, nil
 }
 
 func (st *Store) ReadGroup(ctx context.Context, uid uuid.UUID) (*user.Group, error) {
-	st.Lock()
-	defer st.Unlock()
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
-	g, ok := st.g[uid]
-	if ok {
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+	g, ok := d.group(ctx, uid)
+	if ok && g.DeletedAt.IsZero() {
 		return &g, nil
 	}
-	return nil, sql.ErrNoRows
+	return nil, user.NotFound("group %s not found", uid)
+}
+
+func (st *Store) UpdateGroup(ctx context.Context, g user.Group) (*user.Group, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+	old, ok := d.group(ctx, g.ID)
+	if !ok || !old.DeletedAt.IsZero() {
+		return nil, user.NotFound("group %s not found", g.ID)
+	}
+	g.Tenant = old.Tenant
+	if old.Version != g.Version {
+		return nil, user.ErrVersionConflict
+	}
+	if err := d.indexGroup(g, &old); err != nil {
+		return nil, err
+	}
+	g.DeletedAt = time.Time{}
+	g.DeletedBy = uuid.UUID{}
+	g.Version++
+	d.saveGroup(g.ID)
+	d.g[g.ID] = g
+	return &g, nil
 }
 
 // не возвращает ошибку если не нашли
-func (st *Store) DeleteGroup(ctx context.Context, uid uuid.UUID) error {
-	st.Lock()
-	defer st.Unlock()
+func (st *Store) DeleteGroup(ctx context.Context, uid uuid.UUID, by uuid.UUID, at time.Time) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
 
-	delete(st.g, uid)
-	delete(st.gu, uid)
+	g, ok := d.group(ctx, uid)
+	if !ok || !g.DeletedAt.IsZero() {
+		return nil
+	}
+	d.unindexGroup(g)
+	g.DeletedAt = at
+	g.DeletedBy = by
+	g.Version++
+	d.saveGroup(uid)
+	d.g[uid] = g
 	return nil
 }
 
-func (st *Store) SearchGroups(ctx context.Context, s string) (chan user.Group, error) {
-	st.Lock()
-	defer st.Unlock()
+func (st *Store) RestoreGroup(ctx context.Context, uid uuid.UUID) (*user.Group, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
 
-	// FIXME: переделать на дерево остатков
+	g, ok := d.group(ctx, uid)
+	if !ok || g.DeletedAt.IsZero() {
+		return nil, user.NotFound("group %s not found", uid)
+	}
+	if err := d.indexGroup(g, nil); err != nil {
+		return nil, err
+	}
+	g.DeletedAt = time.Time{}
+	g.DeletedBy = uuid.UUID{}
+	g.Version++
+	d.saveGroup(uid)
+	d.g[uid] = g
+	return &g, nil
+}
 
-	chout := make(chan user.Group, 100)
-
-	go func() {
-		defer close(chout)
-		st.Lock()
-		defer st.Unlock()
-		for _, g := range st.g {
-			if strings.Contains(g.Name, s) {
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.After(2 * time.Second):
-					return
-				case chout <- g:
-				}
+func (st *Store) PurgeGroups(ctx context.Context, before time.Time) (int, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for gid, g := range d.g {
+		if g.DeletedAt.IsZero() || !g.DeletedAt.Before(before) || !user.InTenant(ctx, g.Tenant) {
+			continue
+		}
+		for uid := range d.gu[gid] {
+			d.saveMember(d.ug, uid, gid)
+			delete(d.ug[uid], gid)
+		}
+		for p := range d.gp[gid] {
+			d.saveEdge(d.gc, p, gid)
+			delete(d.gc[p], gid)
+		}
+		for c := range d.gc[gid] {
+			d.saveEdge(d.gp, c, gid)
+			delete(d.gp[c], gid)
+		}
+		for id, inv := range d.invites {
+			if inv.GroupID == gid {
+				d.saveInvitation(id)
+				delete(d.invites, id)
 			}
 		}
-	}()
+		for id, jr := range d.joinreqs {
+			if jr.GroupID == gid {
+				d.saveJoinRequest(id)
+				delete(d.joinreqs, id)
+			}
+		}
+		for id, it := range d.reviews {
+			if it.GroupID == gid {
+				d.saveReviewItem(id)
+				delete(d.reviews, id)
+			}
+		}
+		d.saveMembers(d.gu, gid)
+		d.saveEdges(d.gp, gid)
+		d.saveEdges(d.gc, gid)
+		d.saveGroup(gid)
+		delete(d.gu, gid)
+		delete(d.gp, gid)
+		delete(d.gc, gid)
+		delete(d.g, gid)
+		n++
+	}
+	return n, nil
+}
+
+func (st *Store) SearchGroups(ctx context.Context, s string, p user.Page) (*user.GroupPage, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
-	return chout, nil
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	start, err := p.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	var gs []user.Group
+	for _, g := range d.g {
+		if !g.DeletedAt.IsZero() || !user.InTenant(ctx, g.Tenant) || !strings.Contains(g.Name, s) {
+			continue
+		}
+		if start != nil && !p.Less(*start, g.SortKey()) {
+			continue
+		}
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool {
+		return p.Less(gs[i].SortKey(), gs[j].SortKey())
+	})
+
+	page := &user.GroupPage{}
+	if len(gs) > p.Limit {
+		gs = gs[:p.Limit]
+		page.HasMore = true
+		page.Next = p.CursorAfter(gs[len(gs)-1].SortKey())
+	}
+	page.Groups = gs
+	return page, nil
+}
+
+func (st *Store) SearchDeletedGroups(ctx context.Context) (chan user.Group, error) {
+	return st.searchGroups(ctx, func(g user.Group) bool {
+		return !g.DeletedAt.IsZero()
+	})
+}
+
+func (st *Store) searchGroups(ctx context.Context, match func(user.Group) bool) (chan user.Group, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	// FIXME: переделать на дерево остатков
+
+	var gs []user.Group
+	for _, g := range d.g {
+		if user.InTenant(ctx, g.Tenant) && match(g) {
+			gs = append(gs, g)
+		}
+	}
+	return groupChan(gs), nil
+}
+
+// groupChan отдаёт gs через закрытый канал, вмещающий их все
+func groupChan(gs []user.Group) chan user.Group {
+	chout := make(chan user.Group, len(gs))
+	for _, g := range gs {
+		chout <- g
+	}
+	close(chout)
+	return chout
+}
+
+func (st *Store) ListDynamicGroups(ctx context.Context, tenant string) ([]user.Group, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	var gs []user.Group
+	for _, g := range d.g {
+		if g.Dynamic() && g.Tenant == tenant && g.DeletedAt.IsZero() {
+			gs = append(gs, g)
+		}
+	}
+	return gs, nil
 }
@@ -0,0 +1,48 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func TestStore_PurgeGroups(t *testing.T) {
+	st := NewStore()
+	ctx := user.AllTenants(context.Background())
+
+	g := user.Group{ID: uuid.New(), Name: "group123"}
+	if _, err := st.CreateGroup(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateInvitation(ctx, user.Invitation{ID: uuid.New(), GroupID: g.ID, Email: "x@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateJoinRequest(ctx, user.JoinRequest{ID: uuid.New(), GroupID: g.ID, UserID: uuid.New()}); err != nil {
+		t.Fatal(err)
+	}
+	c := user.Campaign{ID: uuid.New(), GroupIDs: []uuid.UUID{g.ID}}
+	if err := st.CreateCampaign(ctx, c, []user.ReviewItem{{ID: uuid.New(), CampaignID: c.ID, GroupID: g.ID, UserID: uuid.New()}}); err != nil {
+		t.Fatal(err)
+	}
+	deletedAt := time.Now()
+	if err := st.DeleteGroup(ctx, g.ID, uuid.Nil, deletedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := st.PurgeGroups(ctx, deletedAt.Add(time.Second)); n != 1 || err != nil {
+		t.Fatalf("purged %d groups: %v", n, err)
+	}
+	if len(st.invites) != 0 {
+		t.Errorf("invitations left: %v", st.invites)
+	}
+	if len(st.joinreqs) != 0 {
+		t.Errorf("join requests left: %v", st.joinreqs)
+	}
+	if len(st.reviews) != 0 {
+		t.Errorf("review items left: %v", st.reviews)
+	}
+}
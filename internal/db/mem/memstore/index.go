@@ -0,0 +1,206 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// index - уникальный вторичный индекс: значение -> арендатор -> ID записи.
+// Меняется вместе с таблицей под той же блокировкой, поэтому проверка
+// уникальности и запись атомарны. Удалённые записи в индексе не держатся.
+type index map[string]map[string]uuid.UUID
+
+// free сообщает, может ли запись id занять значение key в арендаторе tenant
+func (ix index) free(tenant, key string, id uuid.UUID) bool {
+	owner, ok := ix[key][tenant]
+	return key == "" || !ok || owner == id
+}
+
+func (ix index) put(tenant, key string, id uuid.UUID) {
+	if key == "" {
+		return
+	}
+	if ix[key] == nil {
+		ix[key] = make(map[string]uuid.UUID)
+	}
+	ix[key][tenant] = id
+}
+
+// remove освобождает значение, если его занимает запись id
+func (ix index) remove(tenant, key string, id uuid.UUID) {
+	if owner, ok := ix[key][tenant]; ok && owner == id {
+		delete(ix[key], tenant)
+		if len(ix[key]) == 0 {
+			delete(ix, key)
+		}
+	}
+}
+
+// find возвращает записи со значением key, видимые арендатору из ctx
+func (ix index) find(ctx context.Context, key string) []uuid.UUID {
+	var ids []uuid.UUID
+	for tenant, id := range ix[key] {
+		if user.InTenant(ctx, tenant) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// indexUser занимает имя и почту u, освобождая значения old
+// (прежней версии записи), если она есть
+func (d *data) indexUser(u user.User, old *user.User) error {
+	if !d.uname.free(u.Tenant, u.Name, u.ID) {
+		return user.ErrNameTaken
+	}
+	if !d.uemail.free(u.Tenant, u.Email, u.ID) {
+		return user.ErrEmailTaken
+	}
+	for name, ix := range d.uattrs {
+		if v, ok := u.Attrs[name]; ok && !ix.free(u.Tenant, attrKey(v), u.ID) {
+			return user.AttrTaken(name)
+		}
+	}
+	if old != nil {
+		d.unindexUser(*old)
+	}
+	d.saveIndex(d.uname, u.Name)
+	d.saveIndex(d.uemail, u.Email)
+	d.uname.put(u.Tenant, u.Name, u.ID)
+	d.uemail.put(u.Tenant, u.Email, u.ID)
+	for name, ix := range d.uattrs {
+		if v, ok := u.Attrs[name]; ok {
+			d.saveIndex(ix, attrKey(v))
+			ix.put(u.Tenant, attrKey(v), u.ID)
+		}
+	}
+	return nil
+}
+
+func (d *data) unindexUser(u user.User) {
+	d.saveIndex(d.uname, u.Name)
+	d.saveIndex(d.uemail, u.Email)
+	d.uname.remove(u.Tenant, u.Name, u.ID)
+	d.uemail.remove(u.Tenant, u.Email, u.ID)
+	for name, ix := range d.uattrs {
+		if v, ok := u.Attrs[name]; ok {
+			d.saveIndex(ix, attrKey(v))
+			ix.remove(u.Tenant, attrKey(v), u.ID)
+		}
+	}
+}
+
+// attrKey - ключ значения атрибута в индексе, моменты времени
+// сравниваются независимо от часового пояса
+func attrKey(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		v = t.UTC()
+	}
+	return user.FormatAttr(v)
+}
+
+// indexAttr строит индекс уникального атрибута name по неудалённым
+// пользователям, Conflict - если значения уже повторяются
+func (d *data) indexAttr(name string) (index, error) {
+	ix := make(index)
+	for _, u := range d.u {
+		v, ok := u.Attrs[name]
+		if !ok || !u.DeletedAt.IsZero() {
+			continue
+		}
+		if !ix.free(u.Tenant, attrKey(v), u.ID) {
+			return nil, user.Conflict("attribute %s has duplicate values", name)
+		}
+		ix.put(u.Tenant, attrKey(v), u.ID)
+	}
+	return ix, nil
+}
+
+func (d *data) indexGroup(g user.Group, old *user.Group) error {
+	key := user.NormalizeName(g.Name)
+	if !d.gname.free(g.Tenant, key, g.ID) {
+		return user.ErrNameTaken
+	}
+	if old != nil {
+		d.unindexGroup(*old)
+	}
+	d.saveIndex(d.gname, key)
+	d.gname.put(g.Tenant, key, g.ID)
+	return nil
+}
+
+func (d *data) unindexGroup(g user.Group) {
+	d.saveIndex(d.gname, user.NormalizeName(g.Name))
+	d.gname.remove(g.Tenant, user.NormalizeName(g.Name), g.ID)
+}
+
+// findUser ищет пользователя по индексу ix
+func (d *data) findUser(ctx context.Context, ix index, key, what string) (*user.User, error) {
+	ids := ix.find(ctx, key)
+	switch len(ids) {
+	case 0:
+		return nil, user.NotFound("user with %s %q not found", what, key)
+	case 1:
+		u := d.u[ids[0]]
+		u.Attrs = u.Attrs.Clone()
+		return &u, nil
+	}
+	return nil, user.Conflict("user %s %q is ambiguous", what, key)
+}
+
+func (st *Store) FindUserByName(ctx context.Context, name string) (*user.User, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+	return d.findUser(ctx, d.uname, name, "name")
+}
+
+func (st *Store) FindUserByEmail(ctx context.Context, email string) (*user.User, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+	return d.findUser(ctx, d.uemail, email, "email")
+}
+
+func (st *Store) FindGroupByName(ctx context.Context, name string) (*user.Group, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+	ids := d.gname.find(ctx, name)
+	switch len(ids) {
+	case 0:
+		return nil, user.NotFound("group %q not found", name)
+	case 1:
+		g := d.g[ids[0]]
+		return &g, nil
+	}
+	return nil, user.Conflict("group name %q is ambiguous", name)
+}
@@ -0,0 +1,123 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+var _ user.InvitationStore = &Store{}
+
+func (st *Store) CreateInvitation(ctx context.Context, inv user.Invitation) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.invites[inv.ID]; ok {
+		return user.AlreadyExists("invitation %s already exists", inv.ID)
+	}
+	d.saveInvitation(inv.ID)
+	d.invites[inv.ID] = cloneInvitation(inv)
+	return nil
+}
+
+func (st *Store) ReadInvitation(ctx context.Context, id uuid.UUID) (*user.Invitation, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	inv, ok := d.invites[id]
+	if !ok {
+		return nil, user.NotFound("invitation %s not found", id)
+	}
+	inv = cloneInvitation(inv)
+	return &inv, nil
+}
+
+func (st *Store) UpdateInvitation(ctx context.Context, inv user.Invitation) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.invites[inv.ID]; !ok {
+		return user.NotFound("invitation %s not found", inv.ID)
+	}
+	d.saveInvitation(inv.ID)
+	d.invites[inv.ID] = cloneInvitation(inv)
+	return nil
+}
+
+func (st *Store) GroupInvitations(ctx context.Context, gid uuid.UUID) ([]user.Invitation, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	invs := []user.Invitation{}
+	for _, inv := range d.invites {
+		if inv.GroupID == gid {
+			invs = append(invs, cloneInvitation(inv))
+		}
+	}
+	return invs, nil
+}
+
+func (st *Store) DeleteInvitations(ctx context.Context, before time.Time) (int, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	n := 0
+	for id, inv := range d.invites {
+		if inv.ExpiresAt.Before(before) {
+			d.saveInvitation(id)
+			delete(d.invites, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func cloneInvitation(inv user.Invitation) user.Invitation {
+	inv.Hash = append([]byte(nil), inv.Hash...)
+	if inv.UserID != nil {
+		id := *inv.UserID
+		inv.UserID = &id
+	}
+	if inv.ResolvedAt != nil {
+		t := *inv.ResolvedAt
+		inv.ResolvedAt = &t
+	}
+	if inv.ResolvedBy != nil {
+		id := *inv.ResolvedBy
+		inv.ResolvedBy = &id
+	}
+	return inv
+}
@@ -0,0 +1,101 @@
+package memstore
+
+import (
+	"context"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+var _ user.JoinRequestStore = &Store{}
+
+func (st *Store) CreateJoinRequest(ctx context.Context, jr user.JoinRequest) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.joinreqs[jr.ID]; ok {
+		return user.AlreadyExists("join request %s already exists", jr.ID)
+	}
+	d.saveJoinRequest(jr.ID)
+	d.joinreqs[jr.ID] = cloneJoinRequest(jr)
+	return nil
+}
+
+func (st *Store) ReadJoinRequest(ctx context.Context, id uuid.UUID) (*user.JoinRequest, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	jr, ok := d.joinreqs[id]
+	if !ok {
+		return nil, user.NotFound("join request %s not found", id)
+	}
+	jr = cloneJoinRequest(jr)
+	return &jr, nil
+}
+
+func (st *Store) UpdateJoinRequest(ctx context.Context, jr user.JoinRequest) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.joinreqs[jr.ID]; !ok {
+		return user.NotFound("join request %s not found", jr.ID)
+	}
+	d.saveJoinRequest(jr.ID)
+	d.joinreqs[jr.ID] = cloneJoinRequest(jr)
+	return nil
+}
+
+func (st *Store) GroupJoinRequests(ctx context.Context, gids []uuid.UUID, status user.RequestStatus) ([]user.JoinRequest, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	want := make(map[uuid.UUID]bool, len(gids))
+	for _, gid := range gids {
+		want[gid] = true
+	}
+	jrs := []user.JoinRequest{}
+	for _, jr := range d.joinreqs {
+		if want[jr.GroupID] && jr.Status == status {
+			jrs = append(jrs, cloneJoinRequest(jr))
+		}
+	}
+	return jrs, nil
+}
+
+func cloneJoinRequest(jr user.JoinRequest) user.JoinRequest {
+	jr.Approvals = append([]uuid.UUID(nil), jr.Approvals...)
+	if jr.ResolvedAt != nil {
+		t := *jr.ResolvedAt
+		jr.ResolvedAt = &t
+	}
+	if jr.ResolvedBy != nil {
+		id := *jr.ResolvedBy
+		jr.ResolvedBy = &id
+	}
+	return jr
+}
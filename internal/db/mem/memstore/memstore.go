@@ -1,8 +1,11 @@
 package memstore
 
 import (
+	"context"
 	"sync"
+	"time"
 
+	"gb-backend2/internal/app/repos/audit"
 	"gb-backend2/internal/app/repos/user"
 
 	"github.com/google/uuid"
@@ -10,17 +13,203 @@ import (
 
 type Store struct {
 	sync.Mutex
-	u  map[uuid.UUID]user.User
-	g  map[uuid.UUID]user.Group
-	ug map[uuid.UUID]map[uuid.UUID]struct{}
-	gu map[uuid.UUID]map[uuid.UUID]struct{}
+	*data
+	// журнал аудита только растёт, поэтому транзакция копит свои записи
+	// отдельно и дописывает их при фиксации, а откатывать нечего
+	audit []audit.Entry
+}
+
+// member - членство пользователя в группе
+type member struct {
+	role user.Role
+	// expiresAt - окончание членства, нулевое - бессрочно
+	expiresAt time.Time
+}
+
+func (m member) expired(now time.Time) bool {
+	return !m.expiresAt.IsZero() && !now.Before(m.expiresAt)
+}
+
+// data - все таблицы хранилища. Транзакция меняет их на месте, а как
+// вернуть прежние значения, записывает в журнал отката undo.
+type data struct {
+	u map[uuid.UUID]user.User
+	g map[uuid.UUID]user.Group
+	// членство пользователей в группах:
+	// пользователь -> группа и группа -> пользователь
+	ug map[uuid.UUID]map[uuid.UUID]member
+	gu map[uuid.UUID]map[uuid.UUID]member
+	// вложенные группы: дочерняя -> родительские и наоборот
+	gp map[uuid.UUID]map[uuid.UUID]struct{}
+	gc map[uuid.UUID]map[uuid.UUID]struct{}
+	// уникальные имена и почта пользователей, имена групп
+	uname  index
+	uemail index
+	gname  index
+	// уникальные атрибуты пользователей: имя атрибута -> индекс значений
+	uattrs map[string]index
+	// схема атрибутов пользователей
+	attrs map[string]user.AttrDef
+	// пароли пользователей
+	cred map[uuid.UUID]user.Credential
+	// сеансы входа
+	sessions map[uuid.UUID]user.Session
+	// API-ключи
+	apikeys map[uuid.UUID]user.APIKey
+	// приглашения в группы
+	invites map[uuid.UUID]user.Invitation
+	// заявки на вступление в группы
+	joinreqs map[uuid.UUID]user.JoinRequest
+	// кампании пересмотра доступа и их пункты
+	campaigns map[uuid.UUID]user.Campaign
+	reviews   map[uuid.UUID]user.ReviewItem
+	// outbox событий, seq - номер последнего записанного события
+	ev  []user.Event
+	seq uint64
+
+	// undo - журнал отката открытой транзакции, вне транзакции nil
+	undo *[]func()
+	// now - часы, по которым истекает членство в группах
+	now func() time.Time
 }
 
 func NewStore() *Store {
 	return &Store{
-		u:  make(map[uuid.UUID]user.User),
-		g:  make(map[uuid.UUID]user.Group),
-		ug: make(map[uuid.UUID]map[uuid.UUID]struct{}),
-		gu: make(map[uuid.UUID]map[uuid.UUID]struct{}),
+		data: &data{
+			u:  make(map[uuid.UUID]user.User),
+			g:  make(map[uuid.UUID]user.Group),
+			ug: make(map[uuid.UUID]map[uuid.UUID]member),
+			gu: make(map[uuid.UUID]map[uuid.UUID]member),
+			gp: make(map[uuid.UUID]map[uuid.UUID]struct{}),
+			gc: make(map[uuid.UUID]map[uuid.UUID]struct{}),
+
+			uname:  make(index),
+			uemail: make(index),
+			gname:  make(index),
+			uattrs: make(map[string]index),
+
+			attrs:    make(map[string]user.AttrDef),
+			cred:     make(map[uuid.UUID]user.Credential),
+			sessions: make(map[uuid.UUID]user.Session),
+			apikeys:  make(map[uuid.UUID]user.APIKey),
+			invites:  make(map[uuid.UUID]user.Invitation),
+			joinreqs: make(map[uuid.UUID]user.JoinRequest),
+
+			campaigns: make(map[uuid.UUID]user.Campaign),
+			reviews:   make(map[uuid.UUID]user.ReviewItem),
+			now:       time.Now,
+		},
+	}
+}
+
+// UseClock задаёт часы хранилища, по умолчанию time.Now
+func (st *Store) UseClock(now func() time.Time) {
+	st.Lock()
+	defer st.Unlock()
+	st.now = now
+}
+
+type txKey struct {
+	st *Store
+}
+
+// tx - транзакция, открытая RunInTx
+type tx struct {
+	sync.Mutex
+	// undo - откат изменений транзакции в порядке их внесения
+	undo  []func()
+	audit []audit.Entry
+	// commit и rollback - действия, отложенные через OnCommit
+	commit   []func() error
+	rollback []func()
+	closed   bool
+}
+
+// lock блокирует данные, с которыми работает ctx: внутри открытой
+// транзакции - её блокировкой, иначе - блокировкой хранилища
+func (st *Store) lock(ctx context.Context) (*data, func()) {
+	_, unlock := st.lockTx(ctx)
+	return st.data, unlock
+}
+
+// lockTx блокирует открытую транзакцию ctx и возвращает её,
+// а если её нет - блокирует хранилище и возвращает nil
+func (st *Store) lockTx(ctx context.Context) (*tx, func()) {
+	if t, ok := ctx.Value(txKey{st}).(*tx); ok {
+		t.Lock()
+		if !t.closed {
+			return t, t.Unlock
+		}
+		// транзакция уже завершена, а её контекст ещё используется
+		t.Unlock()
+	}
+	st.Lock()
+	return nil, st.Unlock
+}
+
+// RunInTx выполняет fn в транзакции: все вызовы хранилища с переданным
+// в fn контекстом меняют данные на месте и пишут в журнал отката, по
+// которому изменения отменяются, если fn вернула ошибку или запаниковала.
+// Стоимость транзакции зависит только от числа её изменений.
+// Пока транзакция открыта, остальные запросы к хранилищу ждут.
+// Вложенный вызов RunInTx присоединяется к уже открытой транзакции.
+func (st *Store) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{st}).(*tx); ok {
+		return fn(ctx)
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	t := &tx{}
+	st.data.undo = &t.undo
+	committed := false
+	defer func() {
+		t.Lock()
+		defer t.Unlock()
+		t.closed = true
+		st.data.undo = nil
+		if committed {
+			st.audit = append(st.audit, t.audit...)
+			return
+		}
+		for i := len(t.undo) - 1; i >= 0; i-- {
+			t.undo[i]()
+		}
+		for _, f := range t.rollback {
+			f()
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{st}, t)); err != nil {
+		return err
+	}
+	// отложенные действия выполняются, пока изменения ещё не видны
+	// остальным запросам, и ошибка любого из них откатывает транзакцию
+	for i, f := range t.commit {
+		if err := f(); err != nil {
+			t.rollback = t.rollback[i:]
+			return err
+		}
+	}
+	committed = true
+	return nil
+}
+
+// OnCommit откладывает commit до фиксации транзакции ctx, а если она
+// откатится (в том числе из-за ошибки самого commit) - вызывает rollback.
+// Возвращает false, если ctx вне транзакции.
+func (st *Store) OnCommit(ctx context.Context, commit func() error, rollback func()) bool {
+	t, ok := ctx.Value(txKey{st}).(*tx)
+	if !ok {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return false
 	}
+	t.commit = append(t.commit, commit)
+	t.rollback = append(t.rollback, rollback)
+	return true
 }
@@ -0,0 +1,103 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func TestStore_RunInTx(t *testing.T) {
+	st := NewStore()
+	ctx := user.AllTenants(context.Background())
+
+	u := user.User{ID: uuid.New(), Name: "user123", Version: 1}
+	if _, err := st.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	g := user.Group{ID: uuid.New(), Name: "group123"}
+	errFail := errors.New("fail")
+
+	// change меняет всё, что транзакция должна откатить
+	change := func(ctx context.Context) {
+		t.Helper()
+		nu := u
+		nu.Name = "user456"
+		if _, err := st.UpdateUser(ctx, nu); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.CreateGroup(ctx, g); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.AddUserToGroup(ctx, nu, g, user.RoleMember, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(name string, group bool) {
+		t.Helper()
+		ru, err := st.ReadUser(ctx, u.ID)
+		if err != nil || ru.Name != name {
+			t.Errorf("wrong user: %+v %v", ru, err)
+		}
+		if _, err := st.ReadGroup(ctx, g.ID); (err == nil) != group {
+			t.Errorf("group exists: %v", err == nil)
+		}
+		if _, ok := st.gu[g.ID][u.ID]; ok != group {
+			t.Errorf("membership exists: %v", ok)
+		}
+		// имя занято только у сохранённой версии пользователя
+		_, err = st.CreateUser(ctx, user.User{ID: uuid.New(), Name: name})
+		if !errors.Is(err, user.ErrAlreadyExists) {
+			t.Errorf("name %s is free: %v", name, err)
+		}
+	}
+
+	err := st.RunInTx(ctx, func(ctx context.Context) error {
+		change(ctx)
+		// вложенная транзакция присоединяется к внешней
+		return st.RunInTx(ctx, func(ctx context.Context) error {
+			return errFail
+		})
+	})
+	if !errors.Is(err, errFail) {
+		t.Fatal(err)
+	}
+	check("user123", false)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic is not propagated")
+			}
+		}()
+		_ = st.RunInTx(ctx, func(ctx context.Context) error {
+			change(ctx)
+			panic("fail")
+		})
+	}()
+	check("user123", false)
+
+	// отложенное действие с ошибкой откатывает транзакцию
+	rolledBack := false
+	err = st.RunInTx(ctx, func(ctx context.Context) error {
+		change(ctx)
+		st.OnCommit(ctx, func() error { return errFail }, func() { rolledBack = true })
+		return nil
+	})
+	if !errors.Is(err, errFail) || !rolledBack {
+		t.Fatalf("commit hook: %v %v", err, rolledBack)
+	}
+	check("user123", false)
+
+	if err := st.RunInTx(ctx, func(ctx context.Context) error {
+		change(ctx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	check("user456", true)
+}
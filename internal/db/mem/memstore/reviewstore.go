@@ -0,0 +1,181 @@
+package memstore
+
+import (
+	"context"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+var _ user.ReviewStore = &Store{}
+
+func (st *Store) CreateCampaign(ctx context.Context, c user.Campaign, items []user.ReviewItem) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := d.campaigns[c.ID]; ok {
+		return user.AlreadyExists("review %s already exists", c.ID)
+	}
+	d.saveCampaign(c.ID)
+	d.campaigns[c.ID] = cloneCampaign(c)
+	for _, it := range items {
+		d.saveReviewItem(it.ID)
+		d.reviews[it.ID] = cloneReviewItem(it)
+	}
+	return nil
+}
+
+func (st *Store) ReadCampaign(ctx context.Context, id uuid.UUID) (*user.Campaign, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	c, ok := d.campaigns[id]
+	if !ok || !user.InTenant(ctx, c.Tenant) {
+		return nil, user.NotFound("review %s not found", id)
+	}
+	c = cloneCampaign(c)
+	return &c, nil
+}
+
+func (st *Store) UpdateCampaign(ctx context.Context, c user.Campaign) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := d.campaigns[c.ID]; !ok {
+		return user.NotFound("review %s not found", c.ID)
+	}
+	d.saveCampaign(c.ID)
+	d.campaigns[c.ID] = cloneCampaign(c)
+	return nil
+}
+
+func (st *Store) ListCampaigns(ctx context.Context) ([]user.Campaign, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	cs := []user.Campaign{}
+	for _, c := range d.campaigns {
+		if user.InTenant(ctx, c.Tenant) {
+			cs = append(cs, cloneCampaign(c))
+		}
+	}
+	return cs, nil
+}
+
+func (st *Store) ReadReviewItem(ctx context.Context, id uuid.UUID) (*user.ReviewItem, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	it, ok := d.reviews[id]
+	if !ok {
+		return nil, user.NotFound("review item %s not found", id)
+	}
+	it = cloneReviewItem(it)
+	return &it, nil
+}
+
+func (st *Store) UpdateReviewItem(ctx context.Context, it user.ReviewItem) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.reviews[it.ID]; !ok {
+		return user.NotFound("review item %s not found", it.ID)
+	}
+	d.saveReviewItem(it.ID)
+	d.reviews[it.ID] = cloneReviewItem(it)
+	return nil
+}
+
+func (st *Store) CampaignItems(ctx context.Context, cid uuid.UUID) ([]user.ReviewItem, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	items := []user.ReviewItem{}
+	for _, it := range d.reviews {
+		if it.CampaignID == cid {
+			items = append(items, cloneReviewItem(it))
+		}
+	}
+	return items, nil
+}
+
+func cloneCampaign(c user.Campaign) user.Campaign {
+	c.GroupIDs = append([]uuid.UUID(nil), c.GroupIDs...)
+	if c.ClosedAt != nil {
+		t := *c.ClosedAt
+		c.ClosedAt = &t
+	}
+	return c
+}
+
+func cloneReviewItem(it user.ReviewItem) user.ReviewItem {
+	it.Reviewers = append([]uuid.UUID(nil), it.Reviewers...)
+	if it.ExpiresAt != nil {
+		t := *it.ExpiresAt
+		it.ExpiresAt = &t
+	}
+	if it.DecidedAt != nil {
+		t := *it.DecidedAt
+		it.DecidedAt = &t
+	}
+	if it.DecidedBy != nil {
+		id := *it.DecidedBy
+		it.DecidedBy = &id
+	}
+	return it
+}
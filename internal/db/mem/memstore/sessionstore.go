@@ -0,0 +1,115 @@
+package memstore
+
+import (
+	"context"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+var _ user.SessionStore = &Store{}
+
+func (st *Store) CreateSession(ctx context.Context, s user.Session) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.sessions[s.ID]; ok {
+		return user.AlreadyExists("session %s already exists", s.ID)
+	}
+	d.saveSession(s.ID)
+	d.sessions[s.ID] = cloneSession(s)
+	return nil
+}
+
+func (st *Store) ReadSession(ctx context.Context, id uuid.UUID) (*user.Session, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	s, ok := d.sessions[id]
+	if !ok {
+		return nil, user.NotFound("session %s not found", id)
+	}
+	s = cloneSession(s)
+	return &s, nil
+}
+
+func (st *Store) UpdateSession(ctx context.Context, s user.Session) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := d.sessions[s.ID]; !ok {
+		return user.NotFound("session %s not found", s.ID)
+	}
+	d.saveSession(s.ID)
+	d.sessions[s.ID] = cloneSession(s)
+	return nil
+}
+
+func (st *Store) UserSessions(ctx context.Context, uid uuid.UUID) ([]user.Session, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var ss []user.Session
+	for _, s := range d.sessions {
+		if s.UserID == uid {
+			ss = append(ss, cloneSession(s))
+		}
+	}
+	return ss, nil
+}
+
+func (st *Store) DeleteSessions(ctx context.Context, before time.Time) (int, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+
+	n := 0
+	for id, s := range d.sessions {
+		if s.ExpiresAt.Before(before) {
+			d.saveSession(id)
+			delete(d.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func cloneSession(s user.Session) user.Session {
+	s.RefreshHash = append([]byte(nil), s.RefreshHash...)
+	if s.RevokedAt != nil {
+		t := *s.RevokedAt
+		s.RevokedAt = &t
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package memstore
+
+import (
+	"context"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+// Записи всех арендаторов лежат в общих таблицах, а каждый вызов видит
+// только записи арендатора из контекста (user.WithTenant) или, с
+// user.AllTenants, всех арендаторов. Вызовы без арендатора отклоняются
+// (user.CheckTenant). Хранилище на SQL делает то же условием по колонке tenant.
+
+// user возвращает пользователя uid, если он виден арендатору из ctx
+func (d *data) user(ctx context.Context, uid uuid.UUID) (user.User, bool) {
+	u, ok := d.u[uid]
+	if !ok || !user.InTenant(ctx, u.Tenant) {
+		return user.User{}, false
+	}
+	return u, true
+}
+
+// group возвращает группу gid, если она видна арендатору из ctx
+func (d *data) group(ctx context.Context, gid uuid.UUID) (user.Group, bool) {
+	g, ok := d.g[gid]
+	if !ok || !user.InTenant(ctx, g.Tenant) {
+		return user.Group{}, false
+	}
+	return g, true
+}
+
+func (st *Store) CountUsers(ctx context.Context, tenant string) (int, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, u := range d.u {
+		if u.Tenant == tenant && u.DeletedAt.IsZero() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (st *Store) CountGroups(ctx context.Context, tenant string) (int, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, g := range d.g {
+		if g.Tenant == tenant && g.DeletedAt.IsZero() {
+			n++
+		}
+	}
+	return n, nil
+}
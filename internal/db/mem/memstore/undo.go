@@ -0,0 +1,281 @@
+package memstore
+
+import "github.com/google/uuid"
+
+// Перед изменением записи внутри транзакции хранилище вызывает save*
+// для неё: в журнал отката попадает функция, возвращающая прежнее
+// значение или удаляющая запись, которой не было. Вне транзакции save*
+// ничего не делают.
+
+func (d *data) logUndo(f func()) {
+	if d.undo != nil {
+		*d.undo = append(*d.undo, f)
+	}
+}
+
+func (d *data) saveUser(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.u[id]
+	d.logUndo(func() {
+		if ok {
+			d.u[id] = old
+		} else {
+			delete(d.u, id)
+		}
+	})
+}
+
+func (d *data) saveGroup(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.g[id]
+	d.logUndo(func() {
+		if ok {
+			d.g[id] = old
+		} else {
+			delete(d.g, id)
+		}
+	})
+}
+
+func (d *data) saveAttr(name string) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.attrs[name]
+	d.logUndo(func() {
+		if ok {
+			d.attrs[name] = old
+		} else {
+			delete(d.attrs, name)
+		}
+	})
+}
+
+func (d *data) saveCredential(uid uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.cred[uid]
+	d.logUndo(func() {
+		if ok {
+			d.cred[uid] = old
+		} else {
+			delete(d.cred, uid)
+		}
+	})
+}
+
+func (d *data) saveSession(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.sessions[id]
+	d.logUndo(func() {
+		if ok {
+			d.sessions[id] = old
+		} else {
+			delete(d.sessions, id)
+		}
+	})
+}
+
+func (d *data) saveAPIKey(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.apikeys[id]
+	d.logUndo(func() {
+		if ok {
+			d.apikeys[id] = old
+		} else {
+			delete(d.apikeys, id)
+		}
+	})
+}
+
+func (d *data) saveInvitation(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.invites[id]
+	d.logUndo(func() {
+		if ok {
+			d.invites[id] = old
+		} else {
+			delete(d.invites, id)
+		}
+	})
+}
+
+func (d *data) saveJoinRequest(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.joinreqs[id]
+	d.logUndo(func() {
+		if ok {
+			d.joinreqs[id] = old
+		} else {
+			delete(d.joinreqs, id)
+		}
+	})
+}
+
+func (d *data) saveCampaign(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.campaigns[id]
+	d.logUndo(func() {
+		if ok {
+			d.campaigns[id] = old
+		} else {
+			delete(d.campaigns, id)
+		}
+	})
+}
+
+func (d *data) saveReviewItem(id uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.reviews[id]
+	d.logUndo(func() {
+		if ok {
+			d.reviews[id] = old
+		} else {
+			delete(d.reviews, id)
+		}
+	})
+}
+
+// saveMember сохраняет одну связь m[a][b] (ug или gu)
+func (d *data) saveMember(m map[uuid.UUID]map[uuid.UUID]member, a, b uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := m[a][b]
+	d.logUndo(func() {
+		if !ok {
+			delete(m[a], b)
+			return
+		}
+		if m[a] == nil {
+			m[a] = make(map[uuid.UUID]member)
+		}
+		m[a][b] = old
+	})
+}
+
+// saveMembers сохраняет все связи m[a]
+func (d *data) saveMembers(m map[uuid.UUID]map[uuid.UUID]member, a uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := m[a]
+	if ok {
+		old = make(map[uuid.UUID]member, len(m[a]))
+		for id, e := range m[a] {
+			old[id] = e
+		}
+	}
+	d.logUndo(func() {
+		if ok {
+			m[a] = old
+		} else {
+			delete(m, a)
+		}
+	})
+}
+
+// saveEdge сохраняет одну связь вложенных групп m[a][b] (gp или gc)
+func (d *data) saveEdge(m map[uuid.UUID]map[uuid.UUID]struct{}, a, b uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	_, ok := m[a][b]
+	d.logUndo(func() {
+		if !ok {
+			delete(m[a], b)
+			return
+		}
+		if m[a] == nil {
+			m[a] = make(map[uuid.UUID]struct{})
+		}
+		m[a][b] = struct{}{}
+	})
+}
+
+// saveEdges сохраняет все связи m[a]
+func (d *data) saveEdges(m map[uuid.UUID]map[uuid.UUID]struct{}, a uuid.UUID) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := m[a]
+	if ok {
+		old = make(map[uuid.UUID]struct{}, len(m[a]))
+		for id := range m[a] {
+			old[id] = struct{}{}
+		}
+	}
+	d.logUndo(func() {
+		if ok {
+			m[a] = old
+		} else {
+			delete(m, a)
+		}
+	})
+}
+
+// saveIndex сохраняет владельцев значения key в индексе ix
+func (d *data) saveIndex(ix index, key string) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := ix[key]
+	if ok {
+		old = make(map[string]uuid.UUID, len(ix[key]))
+		for t, id := range ix[key] {
+			old[t] = id
+		}
+	}
+	d.logUndo(func() {
+		if ok {
+			ix[key] = old
+		} else {
+			delete(ix, key)
+		}
+	})
+}
+
+// saveAttrIndex сохраняет индекс уникального атрибута name,
+// который PutAttr не меняет, а заменяет новым
+func (d *data) saveAttrIndex(name string) {
+	if d.undo == nil {
+		return
+	}
+	old, ok := d.uattrs[name]
+	d.logUndo(func() {
+		if ok {
+			d.uattrs[name] = old
+		} else {
+			delete(d.uattrs, name)
+		}
+	})
+}
+
+// saveEvents сохраняет outbox: события только дописываются в конец
+// или удаляются из начала новым срезом, поэтому достаточно прежнего среза
+func (d *data) saveEvents() {
+	if d.undo == nil {
+		return
+	}
+	ev, seq := d.ev, d.seq
+	d.logUndo(func() {
+		d.ev, d.seq = ev, seq
+	})
+}
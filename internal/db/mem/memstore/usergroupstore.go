@@ -10,104 +10,198 @@ import (
 
 var _ user.UserGroupsStore = &Store{}
 
-func (st *Store) AddUserToGroup(ctx context.Context, u user.User, g user.Group) error {
-	st.Lock()
-	defer st.Unlock()
+func (st *Store) AddUserToGroup(ctx context.Context, u user.User, g user.Group, role user.Role, expiresAt time.Time) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
+
+	d.addUserToGroup(u.ID, g.ID, member{role: role, expiresAt: expiresAt})
+	return nil
+}
 
-	if _, ok := st.ug[u.ID]; !ok {
-		st.ug[u.ID] = make(map[uuid.UUID]struct{})
+func (d *data) addUserToGroup(uid, gid uuid.UUID, m member) {
+	if _, ok := d.ug[uid]; !ok {
+		d.ug[uid] = make(map[uuid.UUID]member)
 	}
-	if _, ok := st.gu[g.ID]; !ok {
-		st.gu[g.ID] = make(map[uuid.UUID]struct{})
+	if _, ok := d.gu[gid]; !ok {
+		d.gu[gid] = make(map[uuid.UUID]member)
 	}
 
-	st.ug[u.ID][g.ID] = struct{}{}
-	st.gu[g.ID][u.ID] = struct{}{}
-	return nil
+	d.saveMember(d.ug, uid, gid)
+	d.saveMember(d.gu, gid, uid)
+	d.ug[uid][gid] = m
+	d.gu[gid][uid] = m
+}
+
+// activeMember возвращает неистёкшее членство uid в gid
+func (d *data) activeMember(uid, gid uuid.UUID) (member, error) {
+	m, ok := d.ug[uid][gid]
+	if !ok || m.expired(d.now()) {
+		return member{}, user.NotFound("user %s is not a member of group %s", uid, gid)
+	}
+	return m, nil
+}
+
+func (st *Store) SetUserRole(ctx context.Context, u user.User, g user.Group, role user.Role) (user.Role, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return "", err
+	}
+
+	m, err := d.activeMember(u.ID, g.ID)
+	if err != nil {
+		return "", err
+	}
+	old := m.role
+	m.role = role
+	d.addUserToGroup(u.ID, g.ID, m)
+	return old, nil
+}
+
+func (st *Store) ExtendMembership(ctx context.Context, u user.User, g user.Group, expiresAt time.Time) (time.Time, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return time.Time{}, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return time.Time{}, err
+	}
+
+	m, err := d.activeMember(u.ID, g.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	old := m.expiresAt
+	m.expiresAt = expiresAt
+	d.addUserToGroup(u.ID, g.ID, m)
+	return old, nil
+}
+
+func (st *Store) ExpireMemberships(ctx context.Context, now time.Time) ([]user.Membership, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	var ms []user.Membership
+	for uid, gs := range d.ug {
+		for gid, m := range gs {
+			if !m.expired(now) {
+				continue
+			}
+			at := m.expiresAt
+			ms = append(ms, user.Membership{UserID: uid, GroupID: gid, Role: m.role, ExpiresAt: &at})
+			d.saveMember(d.ug, uid, gid)
+			d.saveMember(d.gu, gid, uid)
+			delete(gs, gid)
+			delete(d.gu[gid], uid)
+		}
+	}
+	return ms, nil
 }
 
 func (st *Store) DeleteUserFromGroup(ctx context.Context, u user.User, g user.Group) error {
-	st.Lock()
-	defer st.Unlock()
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
 
-	delete(st.ug[u.ID], g.ID)
-	delete(st.gu[g.ID], u.ID)
+	d.saveMember(d.ug, u.ID, g.ID)
+	d.saveMember(d.gu, g.ID, u.ID)
+	delete(d.ug[u.ID], g.ID)
+	delete(d.gu[g.ID], u.ID)
 
 	return nil
 }
 
-func (st *Store) GetUserGroups(ctx context.Context, u user.User) (chan user.Group, error) {
-	st.Lock()
-	defer st.Unlock()
+func (st *Store) GetUserGroups(ctx context.Context, u user.User) (chan user.GroupMembership, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
 
 	// FIXME: переделать на дерево остатков
 
-	chout := make(chan user.Group, 100)
-
-	go func() {
-		defer close(chout)
-		st.Lock()
-		defer st.Unlock()
-		for i := range st.ug[u.ID] {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(2 * time.Second):
-				return
-			case chout <- st.g[i]:
-			}
+	now := d.now()
+	chout := make(chan user.GroupMembership, len(d.ug[u.ID]))
+	for i, e := range d.ug[u.ID] {
+		g := d.g[i]
+		if !g.DeletedAt.IsZero() || e.expired(now) {
+			continue
 		}
-	}()
+		chout <- user.GroupMembership{Group: g, Role: e.role, ExpiresAt: e.expiresAt, Path: []user.Group{g}}
+	}
+	close(chout)
 
 	return chout, nil
 }
 
-func (st *Store) GetGroupUsers(ctx context.Context, g user.Group) (chan user.User, error) {
-	st.Lock()
-	defer st.Unlock()
+func (st *Store) GetGroupUsers(ctx context.Context, g user.Group) (chan user.UserMembership, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
 
 	// FIXME: переделать на дерево остатков
 
-	chout := make(chan user.User, 100)
-
-	go func() {
-		defer close(chout)
-		st.Lock()
-		defer st.Unlock()
-		for i := range st.gu[g.ID] {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(2 * time.Second):
-				return
-			case chout <- st.u[i]:
-			}
+	now := d.now()
+	chout := make(chan user.UserMembership, len(d.gu[g.ID]))
+	for i, e := range d.gu[g.ID] {
+		u := d.u[i]
+		if !u.DeletedAt.IsZero() || e.expired(now) {
+			continue
 		}
-	}()
+		u.Attrs = u.Attrs.Clone()
+		chout <- user.UserMembership{User: u, Role: e.role, ExpiresAt: e.expiresAt, Path: []user.Group{g}}
+	}
+	close(chout)
 
 	return chout, nil
 }
@@ -0,0 +1,68 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func TestStore_ExpireMemberships(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	st := NewStore()
+	st.UseClock(func() time.Time { return now })
+	ctx := user.AllTenants(context.Background())
+
+	u := user.User{ID: uuid.New(), Name: "user123"}
+	if _, err := st.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := now.Add(time.Hour)
+	var gs []user.Group
+	for _, name := range []string{"temporary", "permanent"} {
+		g := user.Group{ID: uuid.New(), Name: name}
+		if _, err := st.CreateGroup(ctx, g); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.AddUserToGroup(ctx, u, g, user.RoleMember, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+		expiresAt = time.Time{}
+		gs = append(gs, g)
+	}
+	groups := func() int {
+		t.Helper()
+		ch, err := st.GetUserGroups(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for range ch {
+			n++
+		}
+		return n
+	}
+
+	if n := groups(); n != 2 {
+		t.Errorf("%d groups before expiry", n)
+	}
+	if ms, err := st.ExpireMemberships(ctx, now); len(ms) != 0 || err != nil {
+		t.Errorf("expired early: %+v %v", ms, err)
+	}
+
+	// истёкшее членство не видно ещё до удаления
+	now = now.Add(time.Hour)
+	if n := groups(); n != 1 {
+		t.Errorf("%d groups after expiry", n)
+	}
+	ms, err := st.ExpireMemberships(ctx, now)
+	if err != nil || len(ms) != 1 || ms[0].GroupID != gs[0].ID || ms[0].ExpiresAt == nil {
+		t.Fatalf("wrong expired memberships: %+v %v", ms, err)
+	}
+	if _, ok := st.gu[gs[0].ID][u.ID]; ok {
+		t.Error("expired membership is not deleted")
+	}
+}
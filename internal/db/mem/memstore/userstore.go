@@ -2,9 +2,8 @@ package memstore
 
 import (
 	"context"
-	"database/sql"
 	"gb-backend2/internal/app/repos/user"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,81 +12,311 @@ import (
 var _ user.UserStore = &Store{}
 
 func (st *Store) CreateUser(ctx context.Context, u user.User) (*uuid.UUID, error) {
-	st.Lock()
-	defer st.Unlock()
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
 
-	st.u[u.ID] = u
+	if err := d.indexUser(u, nil); err != nil {
+		return nil, err
+	}
+	u.Attrs = u.Attrs.Clone()
+	d.saveUser(u.ID)
+	d.u[u.ID] = u
 	return &u.ID, nil
 }
 
+// CreateUsers записывает всех пользователей под одной блокировкой
+func (st *Store) CreateUsers(ctx context.Context, us []user.User, ms []user.Membership) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
+
+	for _, u := range us {
+		if err := d.indexUser(u, nil); err != nil {
+			return err
+		}
+		u.Attrs = u.Attrs.Clone()
+		d.saveUser(u.ID)
+		d.u[u.ID] = u
+	}
+	for _, m := range ms {
+		e := member{role: m.Role}
+		if m.ExpiresAt != nil {
+			e.expiresAt = *m.ExpiresAt
+		}
+		d.addUserToGroup(m.UserID, m.GroupID, e)
+	}
+	return nil
+}
+
 func (st *Store) ReadUser(ctx context.Context, uid uuid.UUID) (*user.User, error) {
-	st.Lock()
-	defer st.Unlock()
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
-	u, ok := st.u[uid]
-	if ok {
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+	u, ok := d.user(ctx, uid)
+	if ok && u.DeletedAt.IsZero() {
+		u.Attrs = u.Attrs.Clone()
 		return &u, nil
 	}
-	return nil, sql.ErrNoRows
+	return nil, user.NotFound("user %s not found", uid)
+}
+
+func (st *Store) UpdateUser(ctx context.Context, u user.User) (*user.User, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+	old, ok := d.user(ctx, u.ID)
+	if !ok || !old.DeletedAt.IsZero() {
+		return nil, user.NotFound("user %s not found", u.ID)
+	}
+	u.Tenant = old.Tenant
+	if old.Version != u.Version {
+		return nil, user.ErrVersionConflict
+	}
+	if err := d.indexUser(u, &old); err != nil {
+		return nil, err
+	}
+	u.DeletedAt = time.Time{}
+	u.DeletedBy = uuid.UUID{}
+	u.Version++
+	u.Attrs = u.Attrs.Clone()
+	d.saveUser(u.ID)
+	d.u[u.ID] = u
+	u.Attrs = u.Attrs.Clone()
+	return &u, nil
 }
 
 // не возвращает ошибку если не нашли
-func (st *Store) DeleteUser(ctx context.Context, uid uuid.UUID) error {
-	st.Lock()
-	defer st.Unlock()
+func (st *Store) DeleteUser(ctx context.Context, uid uuid.UUID, by uuid.UUID, at time.Time) error {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return err
+	}
 
-	delete(st.u, uid)
-	delete(st.ug, uid)
+	u, ok := d.user(ctx, uid)
+	if !ok || !u.DeletedAt.IsZero() {
+		return nil
+	}
+	d.unindexUser(u)
+	u.DeletedAt = at
+	u.DeletedBy = by
+	u.Version++
+	d.saveUser(uid)
+	d.u[uid] = u
 	return nil
 }
 
-func (st *Store) SearchUsers(ctx context.Context, s string) (chan user.User, error) {
-	st.Lock()
-	defer st.Unlock()
+func (st *Store) RestoreUser(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
 
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	default:
 	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
 
-	// FIXME: переделать на дерево остатков
+	u, ok := d.user(ctx, uid)
+	if !ok || u.DeletedAt.IsZero() {
+		return nil, user.NotFound("user %s not found", uid)
+	}
+	// имя или почту могли занять, пока пользователь был удалён
+	if err := d.indexUser(u, nil); err != nil {
+		return nil, err
+	}
+	u.DeletedAt = time.Time{}
+	u.DeletedBy = uuid.UUID{}
+	u.Version++
+	d.saveUser(uid)
+	d.u[uid] = u
+	u.Attrs = u.Attrs.Clone()
+	return &u, nil
+}
 
-	chout := make(chan user.User, 100)
-
-	go func() {
-		defer close(chout)
-		st.Lock()
-		defer st.Unlock()
-		for _, u := range st.u {
-			if strings.Contains(u.Name, s) {
-				select {
-				case <-ctx.Done():
-					return
-				case <-time.After(2 * time.Second):
-					return
-				case chout <- u:
-				}
+func (st *Store) PurgeUsers(ctx context.Context, before time.Time) (int, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for uid, u := range d.u {
+		if u.DeletedAt.IsZero() || !u.DeletedAt.Before(before) || !user.InTenant(ctx, u.Tenant) {
+			continue
+		}
+		for gid := range d.ug[uid] {
+			d.saveMember(d.gu, gid, uid)
+			delete(d.gu[gid], uid)
+		}
+		d.saveMembers(d.ug, uid)
+		delete(d.ug, uid)
+		d.saveCredential(uid)
+		delete(d.cred, uid)
+		for id, ss := range d.sessions {
+			if ss.UserID == uid {
+				d.saveSession(id)
+				delete(d.sessions, id)
+			}
+		}
+		for id, k := range d.apikeys {
+			if k.UserID == uid {
+				d.saveAPIKey(id)
+				delete(d.apikeys, id)
 			}
 		}
-	}()
+		for id, inv := range d.invites {
+			if inv.UserID != nil && *inv.UserID == uid || inv.InvitedBy == uid {
+				d.saveInvitation(id)
+				delete(d.invites, id)
+			}
+		}
+		for id, jr := range d.joinreqs {
+			if jr.UserID == uid {
+				d.saveJoinRequest(id)
+				delete(d.joinreqs, id)
+			}
+		}
+		for id, it := range d.reviews {
+			if it.UserID == uid {
+				d.saveReviewItem(id)
+				delete(d.reviews, id)
+			}
+		}
+		d.saveUser(uid)
+		delete(d.u, uid)
+		n++
+	}
+	return n, nil
+}
+
+func (st *Store) SearchUsers(ctx context.Context, q user.UserQuery, p user.Page) (*user.UserPage, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
+
+	start, err := p.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	var us []user.User
+	for _, u := range d.u {
+		if !u.DeletedAt.IsZero() || !user.InTenant(ctx, u.Tenant) || !q.Match(u) {
+			continue
+		}
+		if start != nil && !p.Less(*start, u.SortKey()) {
+			continue
+		}
+		us = append(us, u)
+	}
+	sort.Slice(us, func(i, j int) bool {
+		return p.Less(us[i].SortKey(), us[j].SortKey())
+	})
+
+	page := &user.UserPage{}
+	if len(us) > p.Limit {
+		us = us[:p.Limit]
+		page.HasMore = true
+		page.Next = p.CursorAfter(us[len(us)-1].SortKey())
+	}
+	for i := range us {
+		us[i].Attrs = us[i].Attrs.Clone()
+	}
+	page.Users = us
+	return page, nil
+}
+
+func (st *Store) SearchDeletedUsers(ctx context.Context) (chan user.User, error) {
+	return st.searchUsers(ctx, func(u user.User) bool {
+		return !u.DeletedAt.IsZero()
+	})
+}
+
+func (st *Store) searchUsers(ctx context.Context, match func(user.User) bool) (chan user.User, error) {
+	d, unlock := st.lock(ctx)
+	defer unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	if err := user.CheckTenant(ctx); err != nil {
+		return nil, err
+	}
 
+	// FIXME: переделать на дерево остатков
+
+	// результаты собираются под блокировкой, и канал вмещает их все:
+	// читатель получает полный ответ и может не дочитывать его
+	var us []user.User
+	for _, u := range d.u {
+		if user.InTenant(ctx, u.Tenant) && match(u) {
+			u.Attrs = u.Attrs.Clone()
+			us = append(us, u)
+		}
+	}
+	chout := make(chan user.User, len(us))
+	for _, u := range us {
+		chout <- u
+	}
+	close(chout)
 	return chout, nil
 }
@@ -0,0 +1,118 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gb-backend2/internal/app/repos/user"
+
+	"github.com/google/uuid"
+)
+
+func TestStore_SearchDeletedUsers(t *testing.T) {
+	st := NewStore()
+	ctx := user.AllTenants(context.Background())
+
+	const n = 150
+	for i := 0; i < n; i++ {
+		id, err := st.CreateUser(ctx, user.User{ID: uuid.New(), Name: fmt.Sprintf("user%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := st.DeleteUser(ctx, *id, uuid.Nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ch, err := st.SearchDeletedUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ответ собран целиком, читателю не нужно спешить
+	if len(ch) != n {
+		t.Errorf("channel holds %d users, want %d", len(ch), n)
+	}
+	if _, err := st.CreateUser(ctx, user.User{ID: uuid.New(), Name: "late"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := 0
+	for range ch {
+		got++
+	}
+	if got != n {
+		t.Errorf("found %d deleted users, want %d", got, n)
+	}
+}
+
+func TestStore_PurgeUsers(t *testing.T) {
+	st := NewStore()
+	ctx := user.AllTenants(context.Background())
+
+	u := user.User{ID: uuid.New(), Name: "user123"}
+	g := user.Group{ID: uuid.New(), Name: "group123"}
+	if _, err := st.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateGroup(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AddUserToGroup(ctx, u, g, user.RoleMember, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.PutCredential(ctx, user.Credential{UserID: u.ID, Hash: []byte("hash")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateSession(ctx, user.Session{ID: uuid.New(), UserID: u.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateAPIKey(ctx, user.APIKey{ID: uuid.New(), UserID: u.ID}); err != nil {
+		t.Fatal(err)
+	}
+	for _, inv := range []user.Invitation{
+		{ID: uuid.New(), GroupID: g.ID, UserID: &u.ID},
+		{ID: uuid.New(), GroupID: g.ID, Email: "x@example.com", InvitedBy: u.ID},
+	} {
+		if err := st.CreateInvitation(ctx, inv); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.CreateJoinRequest(ctx, user.JoinRequest{ID: uuid.New(), GroupID: g.ID, UserID: u.ID}); err != nil {
+		t.Fatal(err)
+	}
+	c := user.Campaign{ID: uuid.New(), GroupIDs: []uuid.UUID{g.ID}}
+	if err := st.CreateCampaign(ctx, c, []user.ReviewItem{{ID: uuid.New(), CampaignID: c.ID, GroupID: g.ID, UserID: u.ID}}); err != nil {
+		t.Fatal(err)
+	}
+	deletedAt := time.Now()
+	if err := st.DeleteUser(ctx, u.ID, uuid.Nil, deletedAt); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := st.PurgeUsers(ctx, deletedAt); n != 0 || err != nil {
+		t.Fatalf("purged %d users: %v", n, err)
+	}
+	if n, err := st.PurgeUsers(ctx, deletedAt.Add(time.Second)); n != 1 || err != nil {
+		t.Fatalf("purged %d users: %v", n, err)
+	}
+	if _, err := st.ReadUser(ctx, u.ID); err == nil {
+		t.Error("purged user is readable")
+	}
+	if len(st.ug[u.ID]) != 0 || len(st.gu[g.ID]) != 0 {
+		t.Errorf("memberships left: %v %v", st.ug[u.ID], st.gu[g.ID])
+	}
+	if len(st.cred) != 0 || len(st.sessions) != 0 {
+		t.Errorf("credentials or sessions left: %v %v", st.cred, st.sessions)
+	}
+	if len(st.apikeys) != 0 || len(st.invites) != 0 {
+		t.Errorf("API keys or invitations left: %v %v", st.apikeys, st.invites)
+	}
+	if len(st.joinreqs) != 0 {
+		t.Errorf("join requests left: %v", st.joinreqs)
+	}
+	if len(st.reviews) != 0 {
+		t.Errorf("review items left: %v", st.reviews)
+	}
+}